@@ -1,11 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/sha256"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"time"
 
@@ -13,194 +13,313 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 
-	"github.com/consensys/gnark-crypto/ecc/bn254"
-	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
-	"github.com/consensys/gnark-crypto/ecc/bn254/fr/kzg"
-	fiatshamir "github.com/consensys/gnark-crypto/fiat-shamir"
-
 	blockchainPkg "github.com/xm0onh/thesis/packages/blockchain"
+	commitment "github.com/xm0onh/thesis/packages/commitment"
+	fraud "github.com/xm0onh/thesis/packages/fraud"
+	kzg "github.com/xm0onh/thesis/packages/kzg"
 	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+	rs2d "github.com/xm0onh/thesis/packages/rs2d"
 	utils "github.com/xm0onh/thesis/packages/utils"
 )
 
 var setupTableName = os.Getenv("SETUP_DB")
 var tableName = os.Getenv("DDB_TABLE_NAME")
 var timeKeeperTable = os.Getenv("TIME_KEEPER_TABLE")
-
-// var bucketName = os.Getenv("BLOCKCHAIN_S3_BUCKET")
-
-var Droplets []lubyTransform.LTBlock
-var ddbClient *dynamodb.Client
+var dropletKey = os.Getenv("DROPLET_KEY")
+var localStoreDir = os.Getenv("LOCAL_STORE_DIR")
+var bucketName = os.Getenv("BLOCKCHAIN_S3_BUCKET")
+var requestedBlocks = os.Getenv("REQUESTED_BLOCKS")
+var kzgCeremonyFile = os.Getenv("KZG_CEREMONY_FILE")
+var kzgSRSCache = os.Getenv("KZG_SRS_CACHE")
+var kzgInsecureSetup = os.Getenv("KZG_INSECURE_SETUP") != ""
 
 func init() {
 	gob.Register(blockchainPkg.Transaction{})
 	gob.Register(blockchainPkg.Block{})
-	gob.Register(kzg.OpeningProof{})
-	gob.Register(bn254.G1Affine{})
-	gob.Register(fr.Element{})
-
-	cfg, err := config.LoadDefaultConfig(context.Background())
-	if err != nil {
-		panic(fmt.Sprintf("unable to load SDK config, %v", err))
-	}
-	ddbClient = dynamodb.NewFromConfig(cfg)
 }
 
-func Handler(ctx context.Context, snsEvent events.SNSEvent) (bool, error) {
-	fmt.Println("Received notification from SNS, downloading items from DynamoDB")
+// Decoder collects the droplets from the droplet store and decodes the
+// message. SRS selects the trusted SRS that published KZG commitments are
+// checked against. TimeKeeper is optional; without it the finishing time is
+// not recorded, which is how the decoder runs offline.
+type Decoder struct {
+	Stores     utils.Stores
+	SRS        kzg.SRSConfig
+	TimeKeeper *dynamodb.Client
+}
 
-	pag := dynamodb.NewScanPaginator(ddbClient, &dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-	})
+func (h *Decoder) Handler(ctx context.Context, snsEvent events.SNSEvent) (bool, error) {
+	fmt.Println("Received notification from SNS, downloading droplets from the droplet store")
 
-	for pag.HasMorePages() {
-		out, err := pag.NextPage(ctx)
+	setupRecord, err := utils.LoadSetup(ctx, h.Stores.Setup, h.Stores.Blobs)
+	if err != nil {
+		fmt.Printf("Failed to load setup: %v\n", err)
+		return false, err
+	}
+	param := setupRecord.Parameters()
+	if setupRecord.Matrix != nil {
+		blocks, err := h.decodeCells(ctx, setupRecord)
 		if err != nil {
-			fmt.Printf("Failed to scan DynamoDB table: %v\n", err)
 			return false, err
 		}
+		answerRequests(snsEvent, blocks)
+		return h.recordTime(ctx)
+	}
 
-		for _, item := range out.Items {
-			var block utils.LTBlock
-			err := attributevalue.UnmarshalMap(item, &block)
-			if err != nil {
-				fmt.Printf("Failed to unmarshal DynamoDB item to LTBlock: %v\n", err)
-				continue
-			}
-			if len(block.Data) != 0 {
-				Droplets = append(Droplets, lubyTransform.LTBlock{
-					BlockCode: block.BlockCode,
-					Data:      block.Data,
-				})
-			}
+	droplets, err := h.Stores.Droplets.ListDroplets(ctx)
+	if err != nil {
+		fmt.Printf("Failed to list droplets: %v\n", err)
+		return false, err
+	}
+	fmt.Printf("Downloaded %d LTBlocks.\n", len(droplets))
+
+	key, err := utils.ParseDropletKey(dropletKey)
+	if err != nil {
+		return false, err
+	}
+	dropletCipher, err := utils.DropletCipherForSetup(param, key)
+	if err != nil {
+		return false, err
+	}
+	if dropletCipher != nil {
+		var rejected int
+		droplets, rejected = dropletCipher.OpenDroplets(droplets)
+		fmt.Printf("Opened %d droplets, rejected %d that failed authentication.\n", len(droplets), rejected)
+	}
+	var verified []lubyTransform.LTBlock
+	if setupRecord.Commitment != nil {
+		dropletCommitment, err := commitment.ForSetup(h.SRS, setupRecord.Commitment, param.EncodedBlockIDs)
+		if err != nil {
+			fmt.Printf("Failed to read the droplet commitment: %v\n", err)
+			return false, err
+		}
+		ranges, err := h.Stores.Droplets.ListRangeProofs(ctx)
+		if err != nil {
+			fmt.Printf("Failed to list range proofs: %v\n", err)
+			return false, err
+		}
+		startTime := time.Now()
+		var rejected []int64
+		verified, rejected = dropletCommitment.VerifyDroplets(setupRecord.Commitment.Digest, droplets, ranges)
+		fmt.Printf("Verified %d droplets, rejected %d with invalid proofs.\n", len(verified), len(rejected))
+		if len(rejected) > 0 {
+			fmt.Println("Rejected droplets: ", rejected)
+		}
+		fmt.Println("Time to verify: ", time.Since(startTime))
+	} else {
+		fmt.Println("Setup publishes no droplet commitment; droplets are not verified.")
+		for _, droplet := range droplets {
+			verified = append(verified, droplet.LTBlock)
 		}
 	}
 
-	param := utils.SetupParameters{}
-	param.DegreeCDF, param.SourceBlocks, param.EncodedBlockIDs, param.RandomSeed, param.NumberOfBlocks, _, param.MessageSize, _ = utils.PullDataFromSetup(ctx, setupTableName)
-	fmt.Printf("Downloaded %d LTBlocks.\n", len(Droplets))
 	// Decoding the blocks
 	startTime := time.Now()
-	utils.Decoder(Droplets, param)
-	fmt.Println("Successfully Decoded the blocks.")
-	fmt.Println("Time to decode: ", time.Since(startTime))
-	// verification
-
-	srs, digest, point, proof, err := PullKZGData(ctx, setupTableName)
+	message, err := utils.DecodeDroplets(verified, param)
 	if err != nil {
-		fmt.Printf("Failed to pull KZG data: %v\n", err)
+		// Worth retrying once more responders have written.
+		fmt.Printf("Failed to decode the blocks: %v\n", err)
 		return false, err
 	}
-	startTime = time.Now()
-	fmt.Println(Verifier(digest, proof, point, &srs.Vk))
-	fmt.Println("Time to verify: ", time.Since(startTime))
-
-	/// Submit the time to the time keeper table
-	_, err = ddbClient.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(timeKeeperTable),
-		Item: map[string]types.AttributeValue{
-			"ID":        &types.AttributeValueMemberS{Value: "decoder"},
-			"Timestamp": &types.AttributeValueMemberS{Value: time.Now().Format("2006-01-02T15:04:05.999999")},
-		},
-	})
+	if setupRecord.Commitment != nil {
+		if err := h.checkEncoding(ctx, setupRecord, droplets, verified, message); err != nil {
+			return false, err
+		}
+	}
+	blocks, err := utils.DecodeMessage(message, param)
 	if err != nil {
-		fmt.Printf("Failed to submit time to time keeper table: %v\n", err)
+		// ErrCorruptMessage points at a faulty responder.
+		fmt.Printf("Failed to decode the blocks: %v\n", err)
 		return false, err
 	}
-	//decoder
+	fmt.Println("Successfully Decoded the blocks.")
+	fmt.Println("Time to decode: ", time.Since(startTime))
+	answerRequests(snsEvent, blocks)
+	return h.recordTime(ctx)
+}
 
-	return true, nil
+// answerRequests looks up the blocks asked for by SNS records that carry
+// a utils.RequestedBlocks, by number or by hash, in the decoded blocks.
+// Other records are notifications and are skipped.
+func answerRequests(snsEvent events.SNSEvent, blocks []blockchainPkg.Block) {
+	for _, record := range snsEvent.Records {
+		var request utils.RequestedBlocks
+		if err := json.Unmarshal([]byte(record.SNS.Message), &request); err != nil {
+			continue
+		}
+		if len(request.BlockNumber) == 0 && len(request.BlockHashes) == 0 && len(request.Ranges) == 0 {
+			continue
+		}
+		selected, err := utils.SelectBlocks(blocks, request)
+		if err != nil {
+			fmt.Printf("Failed to answer block request %s: %v\n", record.SNS.Message, err)
+			continue
+		}
+		for _, block := range selected {
+			fmt.Printf("Block %d: %s, %d transactions\n", block.Index, block.Hash, len(block.Transactions))
+		}
+	}
 }
 
-func PullKZGData(ctx context.Context, setupTableName string) (
-	srs *kzg.SRS,
-	digest bn254.G1Affine,
-	point fr.Element,
-	proof kzg.OpeningProof,
-	err error,
-) {
-	cfg, err := config.LoadDefaultConfig(ctx)
+// checkEncoding checks the verified droplets against the source blocks of
+// the decoded message. If one contradicts the others, the committed
+// droplets encode no message at all; the decoder publishes a fraud proof
+// naming it and fails with fraud.ErrInconsistentDroplets.
+func (h *Decoder) checkEncoding(ctx context.Context, setupRecord utils.SetupRecord, droplets []utils.StoredDroplet, verified []lubyTransform.LTBlock, message []byte) error {
+	stored := make(map[int64]utils.StoredDroplet, len(droplets))
+	for _, droplet := range droplets {
+		stored[droplet.BlockCode] = droplet
+	}
+	candidates := make([]utils.StoredDroplet, len(verified))
+	for i, droplet := range verified {
+		candidates[i] = utils.StoredDroplet{LTBlock: droplet, Proof: stored[droplet.BlockCode].Proof}
+	}
+	codec := utils.NewCodec(setupRecord.Parameters())
+	startTime := time.Now()
+	proof, err := fraud.Find(codec, lubyTransform.SplitMessage(message, codec), candidates)
 	if err != nil {
-		return nil, bn254.G1Affine{}, fr.Element{}, kzg.OpeningProof{}, fmt.Errorf("failed to load AWS configuration: %v", err)
+		fmt.Printf("Failed to check the droplet encoding: %v\n", err)
+		return err
+	}
+	if proof == nil {
+		return nil
 	}
+	fmt.Printf("Droplet %d contradicts %d other droplets, found in %s\n", proof.Droplet, len(proof.Droplets)-1, time.Since(startTime))
 
-	ddbClient := dynamodb.NewFromConfig(cfg)
-	result, err := ddbClient.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(setupTableName),
-		Key: map[string]types.AttributeValue{
-			"ID": &types.AttributeValueMemberS{Value: "setup"},
-		},
-	})
+	// Droplets covered by a range proof carry no proof of their own; the
+	// fraud proof uses the per-droplet proofs setup published instead.
+	var proofs [][]byte
+	for i := range proof.Droplets {
+		if len(proof.Droplets[i].Proof) > 0 {
+			continue
+		}
+		if proofs == nil {
+			if proofs, err = setupRecord.LoadDropletProofs(ctx, h.Stores.Blobs); err != nil {
+				return err
+			}
+		}
+		proof.Droplets[i].Proof = proofs[proof.Droplets[i].BlockCode]
+	}
+	if err := fraud.Verify(h.SRS, setupRecord, proof); err != nil {
+		fmt.Printf("Fraud proof does not verify: %v\n", err)
+		return err
+	}
+	data, err := fraud.MarshalProof(proof)
 	if err != nil {
-		return nil, bn254.G1Affine{}, fr.Element{}, kzg.OpeningProof{}, fmt.Errorf("failed to get item from DynamoDB: %v", err)
+		return err
+	}
+	if err := h.Stores.Blobs.Put(ctx, fraud.ProofKey, data); err != nil {
+		fmt.Printf("Failed to publish the fraud proof: %v\n", err)
+		return err
 	}
+	fmt.Printf("Published a fraud proof of %d bytes as %s\n", proof.Size(), fraud.ProofKey)
+	return fmt.Errorf("droplet %d: %w", proof.Droplet, fraud.ErrInconsistentDroplets)
+}
 
-	// Deserialize SRS
-	srsData := result.Item["srs"].(*types.AttributeValueMemberB).Value
-	srs, err = DeserializeSRS(srsData)
+// decodeCells recovers the message of a two-dimensional run from the
+// stored cells: cells with invalid openings are dropped, and missing cells
+// are repaired from their rows and columns.
+func (h *Decoder) decodeCells(ctx context.Context, setupRecord utils.SetupRecord) ([]blockchainPkg.Block, error) {
+	cells, err := h.Stores.Droplets.ListCells(ctx)
 	if err != nil {
-		return nil, bn254.G1Affine{}, fr.Element{}, kzg.OpeningProof{}, fmt.Errorf("failed to deserialize SRS: %v", err)
+		fmt.Printf("Failed to list cells: %v\n", err)
+		return nil, err
 	}
-
-	// Deserialize Digest
-	digestData := result.Item["digest"].(*types.AttributeValueMemberB).Value
-	digest = bn254.G1Affine{}
-	digest.Unmarshal(digestData)
-
-	// Deserialize Point
-	pointData := result.Item["point"].(*types.AttributeValueMemberB).Value
-	point.Unmarshal(pointData)
-
-	// Deserialize Proof
-	proofData := result.Item["proof"].(*types.AttributeValueMemberB).Value
-	proof, err = DeserializeOpeningProof(proofData)
+	fmt.Printf("Downloaded %d cells.\n", len(cells))
+	verifier, err := rs2d.VerifierForSetup(h.SRS, setupRecord.Matrix)
 	if err != nil {
-		return nil, bn254.G1Affine{}, fr.Element{}, kzg.OpeningProof{}, fmt.Errorf("failed to deserialize Proof: %v", err)
+		fmt.Printf("Failed to read the matrix commitments: %v\n", err)
+		return nil, err
 	}
-	return
-}
+	startTime := time.Now()
+	cells, rejected := verifier.VerifyCells(cells)
+	fmt.Printf("Verified %d cells, rejected %d with invalid proofs.\n", len(cells), len(rejected))
+	fmt.Println("Time to verify: ", time.Since(startTime))
 
-func DeserializeSRS(data []byte) (*kzg.SRS, error) {
-	var srs kzg.SRS
-	buf := bytes.NewReader(data)
-	dec := gob.NewDecoder(buf)
-	err := dec.Decode(&srs)
+	startTime = time.Now()
+	matrix, repaired, err := verifier.Repair(cells)
 	if err != nil {
+		// ErrUnrecoverable is worth retrying once more responders have
+		// written; ErrInconsistentEncoding points at a faulty setup.
+		fmt.Printf("Failed to repair the matrix: %v\n", err)
 		return nil, err
 	}
-	return &srs, nil
+	fmt.Printf("Repaired %d cells in %s\n", repaired, time.Since(startTime))
+	message, err := verifier.Code().Message(matrix, setupRecord.MessageSize)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := utils.DecodeMessage(message, setupRecord.Parameters())
+	if err != nil {
+		fmt.Printf("Failed to decode the blocks: %v\n", err)
+		return nil, err
+	}
+	fmt.Println("Successfully Decoded the blocks.")
+	return blocks, nil
 }
 
-func DeserializeOpeningProof(data []byte) (kzg.OpeningProof, error) {
-	var proof kzg.OpeningProof
-	buf := bytes.NewReader(data)
-	dec := gob.NewDecoder(buf)
-	err := dec.Decode(&proof)
+// recordTime submits the finishing time to the time keeper table, if any.
+func (h *Decoder) recordTime(ctx context.Context) (bool, error) {
+	if h.TimeKeeper == nil {
+		return true, nil
+	}
+
+	/// Submit the time to the time keeper table
+	_, err := h.TimeKeeper.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(timeKeeperTable),
+		Item: map[string]types.AttributeValue{
+			"ID":        &types.AttributeValueMemberS{Value: "decoder"},
+			"Timestamp": &types.AttributeValueMemberS{Value: time.Now().Format("2006-01-02T15:04:05.999999")},
+		},
+	})
 	if err != nil {
-		return kzg.OpeningProof{}, err
+		fmt.Printf("Failed to submit time to time keeper table: %v\n", err)
+		return false, err
 	}
-	return proof, nil
-}
+	//decoder
 
-func Verifier(digest bn254.G1Affine, proof kzg.OpeningProof, point fr.Element, vk *kzg.VerifyingKey) bool {
-	transcript := fiatshamir.NewTranscript(sha256.New())
-	digestBytes := digest.Marshal()
-	transcript.Bind("commitment_digest", digestBytes)
-	challengeBytes, _ := transcript.ComputeChallenge("evaluation_point")
-	var verifierPoint fr.Element
-	verifierPoint.SetBytes(challengeBytes)
-	if !verifierPoint.Equal(&point) {
-		fmt.Println("Computed point does not match the proof point")
-		return false
-	}
-	return kzg.Verify(&digest, &proof, verifierPoint, *vk) == nil
+	return true, nil
 }
 
 func main() {
-
-	lambda.Start(Handler)
+	stores, err := utils.OpenStores(context.Background(), utils.StoreConfig{
+		Dir:          localStoreDir,
+		Bucket:       bucketName,
+		SetupTable:   setupTableName,
+		DropletTable: tableName,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	decoder := &Decoder{
+		Stores: stores,
+		SRS: kzg.SRSConfig{
+			Ceremony: kzgCeremonyFile,
+			Cache:    kzgSRSCache,
+			Insecure: kzgInsecureSetup,
+		},
+	}
+	if localStoreDir == "" {
+		cfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			log.Fatalf("unable to load SDK config, %v", err)
+		}
+		decoder.TimeKeeper = dynamodb.NewFromConfig(cfg)
+	}
+	if localStoreDir != "" {
+		// Offline run: decode whatever is in the droplet store once, and
+		// answer REQUESTED_BLOCKS if given.
+		var event events.SNSEvent
+		if requestedBlocks != "" {
+			event.Records = []events.SNSEventRecord{{SNS: events.SNSEntity{Message: requestedBlocks}}}
+		}
+		if _, err := decoder.Handler(context.Background(), event); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	lambda.Start(decoder.Handler)
 }
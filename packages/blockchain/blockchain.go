@@ -9,8 +9,8 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/cbergoon/merkletree"
@@ -25,15 +25,40 @@ type Block struct {
 	Hash         string
 	MerkleRoot   []byte
 	Proof        bool
+
+	// Consensus fields, filled in by an Engine. Signature is not part of
+	// the block hash, it signs it.
+	Difficulty uint64
+	Nonce      uint64
+	Signer     string
+	Signature  []byte
+
+	// Origin is empty for blocks hashed by calculateHashForBlock and names
+	// the source chain of imported blocks, whose Hash is the source chain's
+	// hash of a header we do not keep.
+	Origin string
 }
 
+// OriginEthereum marks blocks imported by ImportEthereumBlocks.
+const OriginEthereum = "ethereum"
+
 type Blockchain struct {
 	Chain []Block
+
+	// base is the state after a prefix of Chain whose bodies are gone;
+	// see Prune and Snapshot.Blockchain.
+	base *chainBase
+
+	indexMu sync.Mutex
+	index   *chainIndex
 }
 type Transaction struct {
-	Sender   string
-	Receiver string
-	Amount   float64
+	Sender   string  `json:"sender"`
+	Receiver string  `json:"receiver"`
+	Amount   float64 `json:"amount"`
+	Payload  []byte  `json:"payload,omitempty"`
+	Nonce    uint64  `json:"nonce,omitempty"`
+	Fee      float64 `json:"fee,omitempty"`
 }
 
 func (t Transaction) CalculateHash() ([]byte, error) {
@@ -41,6 +66,15 @@ func (t Transaction) CalculateHash() ([]byte, error) {
 	if _, err := h.Write([]byte(fmt.Sprintf("%s%s%f", t.Sender, t.Receiver, t.Amount))); err != nil {
 		return nil, err
 	}
+	if _, err := h.Write(t.Payload); err != nil {
+		return nil, err
+	}
+	// Transactions without mempool metadata keep the hashes they always had.
+	if t.Nonce != 0 || t.Fee != 0 {
+		if _, err := h.Write([]byte(fmt.Sprintf("|%d|%f", t.Nonce, t.Fee))); err != nil {
+			return nil, err
+		}
+	}
 
 	return h.Sum(nil), nil
 }
@@ -50,12 +84,31 @@ func (t Transaction) Equals(other merkletree.Content) (bool, error) {
 	if !ok {
 		return false, errors.New("not the same Transaction type")
 	}
-	return t.Sender == otherT.Sender && t.Receiver == otherT.Receiver && t.Amount == otherT.Amount, nil
+	return t.Sender == otherT.Sender && t.Receiver == otherT.Receiver && t.Amount == otherT.Amount && bytes.Equal(t.Payload, otherT.Payload) &&
+		t.Nonce == otherT.Nonce && t.Fee == otherT.Fee, nil
 }
 
-func (bc *Blockchain) AddBlock(newBlock Block) {
+// AddBlock links newBlock to the tip of the chain and appends it.
+func (bc *Blockchain) AddBlock(newBlock Block) error {
+	var parent *Block
 	if len(bc.Chain) > 0 {
-		newBlock.PrevHash = bc.Chain[len(bc.Chain)-1].Hash
+		parent = &bc.Chain[len(bc.Chain)-1]
+	}
+
+	sealed, err := SealBlock(parent, newBlock)
+	if err != nil {
+		return fmt.Errorf("block %d: %w", newBlock.Index, err)
+	}
+
+	bc.Chain = append(bc.Chain, sealed)
+	return nil
+}
+
+// SealBlock links newBlock to parent (nil for a genesis block) and fills in
+// its Merkle root and hash.
+func SealBlock(parent *Block, newBlock Block) (Block, error) {
+	if parent != nil {
+		newBlock.PrevHash = parent.Hash
 	} else {
 		newBlock.PrevHash = ""
 	}
@@ -63,37 +116,61 @@ func (bc *Blockchain) AddBlock(newBlock Block) {
 	// Creating the Merkle Tree for the transactions
 	t, err := merkletree.NewTree(newBlock.Transactions)
 	if err != nil {
-		log.Fatal(err)
+		return Block{}, err
 	}
 	newBlock.MerkleRoot = t.MerkleRoot()
 
 	// Calculating hash of the block
+	newBlock.Origin = ""
 	newBlock.Hash = calculateHashForBlock(newBlock)
 
-	bc.Chain = append(bc.Chain, newBlock)
+	return newBlock, nil
 }
 
 func (bc *Blockchain) GetBlockByIndex(index int) (Block, error) {
 	if index < 0 || index >= len(bc.Chain) {
-		return Block{}, fmt.Errorf("block index out of range")
+		return Block{}, fmt.Errorf("block index %d out of range: %w", index, ErrBlockNotFound)
+	}
+	return verifyBlock(bc.Chain[index])
+}
+
+// verifyBlock rebuilds the Merkle tree of the block's transactions and
+// records in Proof whether the tree is valid.
+func verifyBlock(block Block) (Block, error) {
+	if len(block.Transactions) == 0 {
+		return block, nil
 	}
-	tempBlock := bc.Chain[index]
-	// Verify the entire tree (hashes for each node) is valid
-	t, err := merkletree.NewTree(tempBlock.Transactions)
+	t, err := merkletree.NewTree(block.Transactions)
 	if err != nil {
-		log.Fatal(err)
+		return Block{}, fmt.Errorf("block %d: failed to build merkle tree: %w", block.Index, err)
 	}
 	vt, err := t.VerifyTree()
 	if err != nil {
-		log.Fatal(err)
+		return Block{}, fmt.Errorf("block %d: failed to verify merkle tree: %w", block.Index, err)
 	}
-	tempBlock.Proof = vt
+	block.Proof = vt
+
+	return block, nil
+}
 
-	return tempBlock, nil
+// hashMatches reports whether block.Hash is the hash of its contents. The
+// hash of an imported block cannot be recomputed; it only has to be a
+// well-formed 32-byte hash, while its linkage and Merkle root are still
+// checked by the callers.
+func hashMatches(block Block) bool {
+	if block.Origin != "" {
+		_, err := hashKey(block.Hash)
+		return err == nil
+	}
+	return calculateHashForBlock(block) == block.Hash
 }
 
 func calculateHashForBlock(block Block) string {
 	record := string(rune(block.Index)) + block.Timestamp + block.PrevHash + string(block.MerkleRoot)
+	// Blocks without consensus data keep the hashes they always had.
+	if block.Difficulty != 0 || block.Nonce != 0 || block.Signer != "" {
+		record += fmt.Sprintf("|%d|%d|%s", block.Difficulty, block.Nonce, block.Signer)
+	}
 	h := sha256.New()
 	h.Write([]byte(record))
 	hashed := h.Sum(nil)
@@ -121,23 +198,29 @@ func (block *Block) GenerateMerkleProofByTransactionIndex(transactionIndex int)
 	return tree.MerkleRoot(), nil
 }
 
-func CreateBlock(index int, transactions []merkletree.Content) Block {
+func CreateBlock(index int, transactions []merkletree.Content) (Block, error) {
+	return CreateBlockAt(index, transactions, time.Now())
+}
+
+// CreateBlockAt is CreateBlock with the block timestamp given, so that
+// generated chains can be reproduced hash for hash.
+func CreateBlockAt(index int, transactions []merkletree.Content, timestamp time.Time) (Block, error) {
 	t, err := merkletree.NewTree(transactions)
 	if err != nil {
-		log.Fatal(err)
+		return Block{}, fmt.Errorf("block %d: failed to build merkle tree: %w", index, err)
 	}
 	vt, err := t.VerifyTree()
 	if err != nil {
-		log.Fatal(err)
+		return Block{}, fmt.Errorf("block %d: failed to verify merkle tree: %w", index, err)
 	}
 	Proof := vt
 
 	return Block{
 		Index:        index,
-		Timestamp:    time.Now().String(),
+		Timestamp:    timestamp.String(),
 		Transactions: transactions,
 		Proof:        Proof,
-	}
+	}, nil
 }
 
 func GenerateTransactionsForBlock(TransactionsPerBlock int) []merkletree.Content {
@@ -177,83 +260,24 @@ func GenerateEthereumAddress() (string, error) {
 	return "0x" + hex.EncodeToString(address), nil
 }
 
-func CalculateBlockSize(block Block) int {
+func CalculateBlockSize(block Block) (int, error) {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
 	err := enc.Encode(block)
 	if err != nil {
-		log.Fatalf("Failed to encode block: %v", err)
+		return 0, fmt.Errorf("failed to encode block %d: %w", block.Index, err)
 	}
-	return buf.Len()
+	return buf.Len(), nil
 }
 
-func (bc *Blockchain) CalculateBlockchainSize() int {
+func (bc *Blockchain) CalculateBlockchainSize() (int, error) {
 	totalSize := 0
 	for _, block := range bc.Chain {
-		totalSize += CalculateBlockSize(block)
+		size, err := CalculateBlockSize(block)
+		if err != nil {
+			return 0, err
+		}
+		totalSize += size
 	}
-	return totalSize
+	return totalSize, nil
 }
-
-// func main() {
-// 	// Initialize blockchain
-// 	bc := Blockchain{}
-
-// 	// Simulate transaction data
-// 	transactions := GenerateTransactionsForBlock()
-
-// 	// Add blocks to the blockchain
-// 	for i := 0; i < 100; i++ {
-// 		block := CreateBlock(i, transactions)
-// 		bc.AddBlock(block)
-// 	}
-
-// 	// Print the blockchain data
-// 	for _, block := range bc.Chain {
-// 		log.Printf("Block %d, Hash: %s, PrevHash: %s, MerkleRoot: %s", block.Index, block.Hash, block.PrevHash, hex.EncodeToString(block.MerkleRoot))
-
-// 		// // Verify the entire tree (hashes for each node) is valid
-// 		// t, err := merkletree.NewTree(block.Transactions)
-// 		// if err != nil {
-// 		// 	log.Fatal(err)
-// 		// }
-// 		// vt, err := t.VerifyTree()
-// 		// if err != nil {
-// 		// 	log.Fatal(err)
-// 		// }
-// 		// log.Println("Verify Tree: ", vt)
-
-// 		// // Verify a specific content in in the tree
-// 		// for _, tx := range block.Transactions {
-// 		// 	vc, err := t.VerifyContent(tx)
-// 		// 	if err != nil {
-// 		// 		log.Fatal(err)
-// 		// 	}
-// 		// 	log.Println("Verify Content: ", vc)
-// 		// }
-// 	}
-
-// 	tempBlock := bc.Chain[50]
-// 	// Verify the entire tree (hashes for each node) is valid
-// 	t, err := merkletree.NewTree(tempBlock.Transactions)
-// 	if err != nil {
-// 		log.Fatal(err)
-// 	}
-// 	vt, err := t.VerifyTree()
-// 	if err != nil {
-// 		log.Fatal(err)
-// 	}
-// 	log.Println("Verify Tree: ", vt)
-
-// 	// checking out specific transaction within the block
-
-// 	// Verify a specific content in in the tree
-// 	tempTxn := tempBlock.Transactions[123]
-// 	vc, err := t.VerifyContent(tempTxn)
-// 	if err != nil {
-// 		log.Fatal(err)
-// 	}
-
-// 	log.Println("Verify Content: ", vc)
-
-// }
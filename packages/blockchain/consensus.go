@@ -0,0 +1,207 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/cbergoon/merkletree"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var ErrInvalidBlock = errors.New("invalid block")
+
+// Engine produces and checks the consensus fields of blocks.
+type Engine interface {
+	// Seal links block to parent (nil for genesis) like SealBlock and then
+	// fills in the consensus fields and the final hash.
+	Seal(parent *Block, block Block) (Block, error)
+
+	// Verify checks the consensus rules for block on top of parent.
+	Verify(parent *Block, block Block) error
+}
+
+// AddSealedBlock seals newBlock on top of the current tip with engine and
+// appends it.
+func (bc *Blockchain) AddSealedBlock(newBlock Block, engine Engine) error {
+	var parent *Block
+	if len(bc.Chain) > 0 {
+		parent = &bc.Chain[len(bc.Chain)-1]
+	}
+	sealed, err := engine.Seal(parent, newBlock)
+	if err != nil {
+		return fmt.Errorf("block %d: %w", newBlock.Index, err)
+	}
+	bc.Chain = append(bc.Chain, sealed)
+	return nil
+}
+
+// ValidateChain checks hash linkage, Merkle roots and block hashes of the
+// whole chain and, if engine is not nil, its consensus rules. Imported
+// blocks keep hashes that cannot be recomputed, so they are only accepted
+// without an engine. Pruned blocks, with a Merkle root but no transactions,
+// may only form a prefix of the chain, as Prune and Snapshot.Blockchain
+// leave them.
+func ValidateChain(bc *Blockchain, engine Engine) error {
+	bodies := false
+	for i := range bc.Chain {
+		block := bc.Chain[i]
+		var parent *Block
+		if i > 0 {
+			parent = &bc.Chain[i-1]
+			if block.PrevHash != parent.Hash {
+				return fmt.Errorf("block %d: previous hash does not match block %d: %w", block.Index, parent.Index, ErrInvalidBlock)
+			}
+		}
+		if len(block.Transactions) > 0 {
+			bodies = true
+			t, err := merkletree.NewTree(block.Transactions)
+			if err != nil {
+				return fmt.Errorf("block %d: failed to build merkle tree: %w", block.Index, err)
+			}
+			if !bytes.Equal(t.MerkleRoot(), block.MerkleRoot) {
+				return fmt.Errorf("block %d: merkle root mismatch: %w", block.Index, ErrInvalidBlock)
+			}
+		} else if len(block.MerkleRoot) != 0 && bodies {
+			return fmt.Errorf("block %d: merkle root without transactions after unpruned blocks: %w", block.Index, ErrInvalidBlock)
+		}
+		if !hashMatches(block) {
+			return fmt.Errorf("block %d: hash mismatch: %w", block.Index, ErrInvalidBlock)
+		}
+		if engine != nil {
+			if block.Origin != "" {
+				return fmt.Errorf("block %d: %s block under a consensus engine: %w", block.Index, block.Origin, ErrInvalidBlock)
+			}
+			if err := engine.Verify(parent, block); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ProofOfWork is a toy proof of work: the block hash must start with
+// Difficulty zero bits. Difficulty can be changed between blocks; each block
+// records the difficulty it was mined at and Verify accepts any block mined
+// at Difficulty or harder, up to MaxDifficulty.
+type ProofOfWork struct {
+	Difficulty uint64
+}
+
+// MaxDifficulty bounds the difficulty of ProofOfWork. Sealing takes about
+// 2^Difficulty hashes, so anything much above it would never finish.
+const MaxDifficulty = 32
+
+func leadingZeroBits(hash string) int {
+	raw, err := hex.DecodeString(hash)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, b := range raw {
+		n += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return n
+}
+
+func (p ProofOfWork) Seal(parent *Block, block Block) (Block, error) {
+	if p.Difficulty == 0 || p.Difficulty > MaxDifficulty {
+		return Block{}, fmt.Errorf("proof of work difficulty must be in [1, %d], got %d", MaxDifficulty, p.Difficulty)
+	}
+	block, err := SealBlock(parent, block)
+	if err != nil {
+		return Block{}, err
+	}
+	block.Difficulty = p.Difficulty
+	block.Signer, block.Signature = "", nil
+	for block.Nonce = 0; ; block.Nonce++ {
+		block.Hash = calculateHashForBlock(block)
+		if uint64(leadingZeroBits(block.Hash)) >= block.Difficulty {
+			return block, nil
+		}
+	}
+}
+
+func (p ProofOfWork) Verify(parent *Block, block Block) error {
+	if block.Difficulty < p.Difficulty {
+		return fmt.Errorf("block %d: difficulty %d below minimum %d: %w", block.Index, block.Difficulty, p.Difficulty, ErrInvalidBlock)
+	}
+	if block.Difficulty > MaxDifficulty {
+		return fmt.Errorf("block %d: difficulty %d above maximum %d: %w", block.Index, block.Difficulty, MaxDifficulty, ErrInvalidBlock)
+	}
+	if uint64(leadingZeroBits(block.Hash)) < block.Difficulty {
+		return fmt.Errorf("block %d: hash does not meet difficulty %d: %w", block.Index, block.Difficulty, ErrInvalidBlock)
+	}
+	return nil
+}
+
+// ProofOfAuthority lets a fixed set of signers produce blocks. A block
+// names its Signer and carries an ECDSA signature over its hash; Verify
+// recovers the signer from the signature and checks it is authorised. Key is
+// only needed to seal.
+type ProofOfAuthority struct {
+	Signers []string
+	Key     *ecdsa.PrivateKey
+}
+
+// AuthorityAddress returns the address that identifies key as a signer.
+func AuthorityAddress(key *ecdsa.PrivateKey) string {
+	return "0x" + hex.EncodeToString(crypto.PubkeyToAddress(key.PublicKey).Bytes())
+}
+
+func (p ProofOfAuthority) authorised(signer string) bool {
+	for _, s := range p.Signers {
+		if strings.EqualFold(s, signer) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p ProofOfAuthority) Seal(parent *Block, block Block) (Block, error) {
+	if p.Key == nil {
+		return Block{}, errors.New("proof of authority sealing needs a signing key")
+	}
+	signer := AuthorityAddress(p.Key)
+	if !p.authorised(signer) {
+		return Block{}, fmt.Errorf("signer %s is not authorised", signer)
+	}
+	block, err := SealBlock(parent, block)
+	if err != nil {
+		return Block{}, err
+	}
+	block.Difficulty, block.Nonce = 0, 0
+	block.Signer = signer
+	block.Hash = calculateHashForBlock(block)
+	digest, _ := hex.DecodeString(block.Hash)
+	block.Signature, err = crypto.Sign(digest, p.Key)
+	if err != nil {
+		return Block{}, fmt.Errorf("failed to sign block %d: %w", block.Index, err)
+	}
+	return block, nil
+}
+
+func (p ProofOfAuthority) Verify(parent *Block, block Block) error {
+	if !p.authorised(block.Signer) {
+		return fmt.Errorf("block %d: signer %s is not authorised: %w", block.Index, block.Signer, ErrInvalidBlock)
+	}
+	digest, err := hex.DecodeString(block.Hash)
+	if err != nil || len(digest) != 32 {
+		return fmt.Errorf("block %d: malformed hash: %w", block.Index, ErrInvalidBlock)
+	}
+	pub, err := crypto.SigToPub(digest, block.Signature)
+	if err != nil {
+		return fmt.Errorf("block %d: bad signature: %v: %w", block.Index, err, ErrInvalidBlock)
+	}
+	if recovered := "0x" + hex.EncodeToString(crypto.PubkeyToAddress(*pub).Bytes()); !strings.EqualFold(recovered, block.Signer) {
+		return fmt.Errorf("block %d: signed by %s, not %s: %w", block.Index, recovered, block.Signer, ErrInvalidBlock)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func sealedChain(t *testing.T, engine Engine, n int) *Blockchain {
+	t.Helper()
+	bc := &Blockchain{}
+	for i := 0; i < n; i++ {
+		block, err := CreateBlock(i, GenerateTransactionsForBlock(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.AddSealedBlock(block, engine); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc
+}
+
+func TestProofOfWork(t *testing.T) {
+	engine := ProofOfWork{Difficulty: 8}
+	bc := sealedChain(t, engine, 3)
+	if err := ValidateChain(bc, engine); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateChain(bc, ProofOfWork{Difficulty: 12}); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("chain mined below the minimum difficulty: %v", err)
+	}
+
+	// Claiming a higher difficulty changes the hash, which then no longer
+	// meets it or matches the block.
+	bc.Chain[1].Difficulty = 64
+	if err := ValidateChain(bc, engine); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("tampered difficulty: %v", err)
+	}
+	if _, err := (ProofOfWork{}).Seal(nil, bc.Chain[0]); err == nil {
+		t.Fatal("sealing at difficulty 0 succeeded")
+	}
+	if _, err := (ProofOfWork{Difficulty: MaxDifficulty + 1}).Seal(nil, bc.Chain[0]); err == nil {
+		t.Fatalf("sealing at difficulty %d succeeded", MaxDifficulty+1)
+	}
+}
+
+func TestProofOfAuthority(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := ProofOfAuthority{Signers: []string{AuthorityAddress(key)}, Key: key}
+	bc := sealedChain(t, engine, 3)
+	if err := ValidateChain(bc, engine); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := ProofOfAuthority{Signers: []string{AuthorityAddress(other)}}
+	if err := ValidateChain(bc, verifier); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("chain signed by an unknown signer: %v", err)
+	}
+	// A signature by another key under an authorised name.
+	forged := bc.Chain[2]
+	digest, _ := hex.DecodeString(forged.Hash)
+	forged.Signature, _ = crypto.Sign(digest, other)
+	if err := engine.Verify(&bc.Chain[1], forged); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("forged signature: %v", err)
+	}
+
+	unauthorised := ProofOfAuthority{Signers: engine.Signers, Key: other}
+	block, _ := CreateBlock(3, nil)
+	if err := bc.AddSealedBlock(block, unauthorised); err == nil {
+		t.Fatal("an unauthorised key sealed a block")
+	}
+}
+
+func TestValidateChainRejectsTampering(t *testing.T) {
+	bc := testBlockchain(t, 3)
+	bc.Chain[1].Transactions[0] = Transaction{Sender: "mallory", Receiver: "mallory", Amount: 1e6}
+	if err := ValidateChain(bc, nil); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("tampered transaction: %v", err)
+	}
+
+	bc = testBlockchain(t, 3)
+	bc.Chain[2].PrevHash = bc.Chain[0].Hash
+	if err := ValidateChain(bc, nil); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("broken linkage: %v", err)
+	}
+
+	// A block without transactions must not carry a Merkle root either.
+	bc = testBlockchain(t, 3)
+	bc.Chain[2].Transactions = nil
+	if err := ValidateChain(bc, nil); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("merkle root without transactions: %v", err)
+	}
+}
+
+func TestValidateChainRejectsImportedBlocksUnderEngine(t *testing.T) {
+	engine := ProofOfWork{Difficulty: 4}
+	bc := sealedChain(t, engine, 2)
+	// An imported block's hash is only checked for its form, so under an
+	// engine it could stand in for a mined block without any work.
+	bc.Chain[1].Origin = OriginEthereum
+	bc.Chain[1].Hash = strings.Repeat("0", 64)
+	if err := ValidateChain(bc, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateChain(bc, engine); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("imported block under proof of work: %v", err)
+	}
+}
+
+func TestAddBlockReturnsErrors(t *testing.T) {
+	bc := sealedChain(t, ProofOfWork{Difficulty: 4}, 2)
+	empty := Block{Index: 2}
+	if err := bc.AddBlock(empty); err == nil || !strings.Contains(err.Error(), "block 2") {
+		t.Fatalf("AddBlock without transactions: %v", err)
+	}
+	if err := bc.AddSealedBlock(empty, ProofOfWork{Difficulty: 4}); err == nil || !strings.Contains(err.Error(), "block 2") {
+		t.Fatalf("AddSealedBlock without transactions: %v", err)
+	}
+	if len(bc.Chain) != 2 {
+		t.Fatalf("failed appends changed the chain to %d blocks", len(bc.Chain))
+	}
+}
@@ -0,0 +1,243 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cbergoon/merkletree"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// EthereumImportConfig selects a range of Ethereum blocks from a file on
+// disk. Files ending in .json hold JSON-RPC eth_getBlockByNumber results
+// (with full transactions), anything else is read as a `geth export` RLP
+// stream. Either may be gzip compressed (.gz).
+type EthereumImportConfig struct {
+	Path string `json:"path"`
+
+	// First and Last are inclusive block numbers. Last == 0 imports up to
+	// the end of the file.
+	First uint64 `json:"first"`
+	Last  uint64 `json:"last"`
+}
+
+func (c EthereumImportConfig) contains(number uint64) bool {
+	return number >= c.First && (c.Last == 0 || number <= c.Last)
+}
+
+var weiPerEther = new(big.Float).SetInt(big.NewInt(1e18))
+
+// ImportEthereumBlocks reads the configured block range and converts it into
+// a Blockchain. Block hashes, parent hashes and timestamps are taken from
+// the Ethereum headers; each transaction becomes a Transaction with the
+// recovered sender, the recipient (empty for contract creations), the value
+// in ether, the call data as payload, its nonce and as fee the gas price
+// times the gas limit in ether. The gas actually used is only in the
+// receipts, so the fee is the most the sender could pay.
+func ImportEthereumBlocks(config EthereumImportConfig) (*Blockchain, error) {
+	f, err := os.Open(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ethereum block file: %w", err)
+	}
+	defer f.Close()
+
+	path := config.Path
+	var r io.Reader = bufio.NewReader(f)
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+		path = strings.TrimSuffix(path, ".gz")
+	}
+
+	var blocks []Block
+	if strings.HasSuffix(path, ".json") {
+		blocks, err = ReadJSONRPCDump(r, config)
+	} else {
+		blocks, err = ReadGethExport(r, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no blocks in range [%d, %d] in %s", config.First, config.Last, config.Path)
+	}
+	return &Blockchain{Chain: blocks}, nil
+}
+
+// ReadGethExport decodes the RLP block stream written by `geth export`.
+func ReadGethExport(r io.Reader, config EthereumImportConfig) ([]Block, error) {
+	stream := rlp.NewStream(r, 0)
+	var blocks []Block
+	for {
+		var block types.Block
+		if err := stream.Decode(&block); err != nil {
+			if errors.Is(err, io.EOF) {
+				return blocks, nil
+			}
+			return nil, fmt.Errorf("failed to decode exported block: %w", err)
+		}
+		number := block.NumberU64()
+		if !config.contains(number) {
+			if config.Last != 0 && number > config.Last {
+				return blocks, nil
+			}
+			continue
+		}
+		transactions := make([]merkletree.Content, len(block.Transactions()))
+		for i, tx := range block.Transactions() {
+			sender, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+			if err != nil {
+				return nil, fmt.Errorf("block %d: failed to recover sender of tx %s: %w", number, tx.Hash().Hex(), err)
+			}
+			transactions[i] = ethereumTransaction(sender, tx.To(), tx.Value(), tx.Data(), tx.Nonce(), tx.GasPrice(), tx.Gas())
+		}
+		imported, err := ethereumBlock(number, block.Hash(), block.ParentHash(), block.Time(), transactions)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, imported)
+	}
+}
+
+type rpcBlock struct {
+	Number       hexutil.Uint64   `json:"number"`
+	Hash         common.Hash      `json:"hash"`
+	ParentHash   common.Hash      `json:"parentHash"`
+	Timestamp    hexutil.Uint64   `json:"timestamp"`
+	Transactions []rpcTransaction `json:"transactions"`
+}
+
+type rpcTransaction struct {
+	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to"`
+	Value    *hexutil.Big    `json:"value"`
+	Input    hexutil.Bytes   `json:"input"`
+	Nonce    hexutil.Uint64  `json:"nonce"`
+	Gas      hexutil.Uint64  `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+}
+
+// ReadJSONRPCDump decodes saved eth_getBlockByNumber(n, true) results. The
+// input may be a JSON array, newline-delimited values or a mix of both, and
+// each value may be a bare block or a {"jsonrpc", "id", "result"} response.
+func ReadJSONRPCDump(r io.Reader, config EthereumImportConfig) ([]Block, error) {
+	decoder := json.NewDecoder(r)
+	var blocks []Block
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return blocks, nil
+			}
+			return nil, fmt.Errorf("failed to read json-rpc dump: %w", err)
+		}
+		values := []json.RawMessage{raw}
+		if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+			values = nil
+			if err := json.Unmarshal(raw, &values); err != nil {
+				return nil, fmt.Errorf("failed to read json-rpc dump: %w", err)
+			}
+		}
+		for _, value := range values {
+			block, ok, err := decodeRPCBlock(value, config)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+}
+
+func decodeRPCBlock(value json.RawMessage, config EthereumImportConfig) (Block, bool, error) {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(value, &envelope); err == nil {
+		if result, ok := envelope["result"]; ok {
+			value = result
+		}
+	}
+	if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+		// eth_getBlockByNumber answers null for blocks the node does not have.
+		return Block{}, false, errors.New("json-rpc dump holds a null block")
+	}
+	var block rpcBlock
+	if err := json.Unmarshal(value, &block); err != nil {
+		return Block{}, false, fmt.Errorf("failed to decode json-rpc block (was it fetched with full transactions?): %w", err)
+	}
+	if block.Hash == (common.Hash{}) {
+		return Block{}, false, fmt.Errorf("json-rpc block %d has no hash", uint64(block.Number))
+	}
+	if !config.contains(uint64(block.Number)) {
+		return Block{}, false, nil
+	}
+	transactions := make([]merkletree.Content, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		transactions[i] = ethereumTransaction(tx.From, tx.To, tx.Value.ToInt(), tx.Input, uint64(tx.Nonce), tx.GasPrice.ToInt(), uint64(tx.Gas))
+	}
+	imported, err := ethereumBlock(uint64(block.Number), block.Hash, block.ParentHash, uint64(block.Timestamp), transactions)
+	return imported, err == nil, err
+}
+
+func ethereumTransaction(from common.Address, to *common.Address, value *big.Int, data []byte, nonce uint64, gasPrice *big.Int, gas uint64) Transaction {
+	tx := Transaction{
+		Sender:  "0x" + hex.EncodeToString(from[:]),
+		Payload: data,
+		Nonce:   nonce,
+	}
+	if to != nil {
+		tx.Receiver = "0x" + hex.EncodeToString(to[:])
+	}
+	if value != nil {
+		tx.Amount = weiToEther(value)
+	}
+	if gasPrice != nil {
+		tx.Fee = weiToEther(new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gas)))
+	}
+	return tx
+}
+
+func weiToEther(wei *big.Int) float64 {
+	ether, _ := new(big.Float).Quo(new(big.Float).SetInt(wei), weiPerEther).Float64()
+	return ether
+}
+
+// ethereumBlock builds a Block that keeps the Ethereum hash linkage and
+// number. Blocks without transactions have no Merkle tree, so their
+// MerkleRoot stays empty.
+func ethereumBlock(number uint64, hash, parent common.Hash, timestamp uint64, transactions []merkletree.Content) (Block, error) {
+	block := Block{
+		Index:        int(number),
+		Timestamp:    time.Unix(int64(timestamp), 0).UTC().String(),
+		Transactions: transactions,
+		PrevHash:     hex.EncodeToString(parent[:]),
+		Hash:         hex.EncodeToString(hash[:]),
+		Proof:        true,
+		Origin:       OriginEthereum,
+	}
+	if len(transactions) > 0 {
+		t, err := merkletree.NewTree(transactions)
+		if err != nil {
+			return Block{}, fmt.Errorf("block %d: %w", number, err)
+		}
+		block.MerkleRoot = t.MerkleRoot()
+	}
+	return block, nil
+}
@@ -0,0 +1,116 @@
+package blockchain
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const rpcDump = `[
+{"jsonrpc": "2.0", "id": 1, "result": {"number": "0x10", "timestamp": "0x5",
+  "hash": "0x1111111111111111111111111111111111111111111111111111111111111111",
+  "parentHash": "0x0000000000000000000000000000000000000000000000000000000000000000",
+  "transactions": [{"from": "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "to": "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", "value": "0xde0b6b3a7640000", "input": "0x01",
+    "nonce": "0x7", "gas": "0x5208", "gasPrice": "0x3b9aca00"}]}},
+{"number": "0x11", "timestamp": "0x11",
+  "hash": "0x2222222222222222222222222222222222222222222222222222222222222222",
+  "parentHash": "0x1111111111111111111111111111111111111111111111111111111111111111",
+  "transactions": []}
+]`
+
+func TestReadJSONRPCDump(t *testing.T) {
+	blocks, err := ReadJSONRPCDump(strings.NewReader(rpcDump), EthereumImportConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Index != 16 || blocks[1].Index != 17 {
+		t.Fatalf("imported %+v", blocks)
+	}
+	tx := blocks[0].Transactions[0].(Transaction)
+	if tx.Amount != 1 || tx.Sender != "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa" || !bytes.Equal(tx.Payload, []byte{1}) {
+		t.Fatalf("imported transaction %+v", tx)
+	}
+	// 21000 gas at 1 gwei.
+	if tx.Nonce != 7 || tx.Fee != 0.000021 {
+		t.Fatalf("imported nonce %d and fee %v, want 7 and 0.000021", tx.Nonce, tx.Fee)
+	}
+
+	// Imported hashes cannot be recomputed, but the chain still validates
+	// and snapshots.
+	bc := &Blockchain{Chain: blocks}
+	if err := ValidateChain(bc, nil); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := bc.Snapshot(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	bc.Chain[1].PrevHash = strings.Repeat("3", 64)
+	if err := ValidateChain(bc, nil); err == nil {
+		t.Fatal("broken linkage between imported blocks was accepted")
+	}
+
+	only, err := ReadJSONRPCDump(strings.NewReader(rpcDump), EthereumImportConfig{First: 17})
+	if err != nil || len(only) != 1 || only[0].Index != 17 {
+		t.Fatalf("import from block 17: %d blocks, %v", len(only), err)
+	}
+}
+
+func TestReadJSONRPCDumpRejectsNullBlocks(t *testing.T) {
+	for _, dump := range []string{
+		`{"jsonrpc": "2.0", "id": 1, "result": null}`,
+		`null`,
+	} {
+		if _, err := ReadJSONRPCDump(strings.NewReader(dump), EthereumImportConfig{}); err == nil {
+			t.Fatalf("%s was imported", dump)
+		}
+	}
+}
+
+func TestReadGethExport(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	to := crypto.PubkeyToAddress(key.PublicKey)
+	tx, err := types.SignNewTx(key, signer, &types.LegacyTx{Nonce: 3, To: &to, Value: big.NewInt(2e18), Gas: 21000, GasPrice: big.NewInt(2e9)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var export bytes.Buffer
+	var parent types.Header
+	for number := int64(5); number < 8; number++ {
+		header := &types.Header{Number: big.NewInt(number), ParentHash: parent.Hash(), Time: uint64(number), Difficulty: big.NewInt(1)}
+		block := types.NewBlockWithHeader(header).WithBody([]*types.Transaction{tx}, nil)
+		if err := rlp.Encode(&export, block); err != nil {
+			t.Fatal(err)
+		}
+		parent = *header
+	}
+
+	blocks, err := ReadGethExport(&export, EthereumImportConfig{First: 6, Last: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Index != 6 || blocks[0].Origin != OriginEthereum {
+		t.Fatalf("imported %+v", blocks)
+	}
+	got := blocks[0].Transactions[0].(Transaction)
+	if got.Sender != strings.ToLower(to.Hex()) || got.Amount != 2 || got.Nonce != 3 || got.Fee != 0.000042 {
+		t.Fatalf("imported transaction %+v", got)
+	}
+	if err := ValidateChain(&Blockchain{Chain: blocks}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
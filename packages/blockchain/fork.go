@@ -0,0 +1,325 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+var (
+	ErrDuplicateBlock = errors.New("block already known")
+	ErrUnknownParent  = errors.New("unknown parent block")
+)
+
+// TreeNode is a block in a BlockTree together with its position in the tree.
+type TreeNode struct {
+	Block    Block
+	Parent   *TreeNode
+	Children []*TreeNode
+
+	// Height is the number of ancestors of the block, TotalWork the sum of
+	// the work of the block and all its ancestors.
+	Height    int
+	TotalWork *big.Int
+}
+
+// ForkChoice decides which tip of a BlockTree is the canonical head.
+type ForkChoice interface {
+	// Prefer reports whether candidate should replace head as the head of
+	// the chain.
+	Prefer(candidate, head *TreeNode) bool
+}
+
+// LongestChain picks the tip with the most blocks. Ties keep the tip that
+// was seen first.
+type LongestChain struct{}
+
+func (LongestChain) Prefer(candidate, head *TreeNode) bool {
+	return candidate.Height > head.Height
+}
+
+// HeaviestWork picks the tip with the most accumulated work. Ties keep the
+// tip that was seen first.
+type HeaviestWork struct{}
+
+func (HeaviestWork) Prefer(candidate, head *TreeNode) bool {
+	return candidate.TotalWork.Cmp(head.TotalWork) > 0
+}
+
+// BlockWork returns the work a block contributes to its branch: the expected
+// number of hashes, 2^Difficulty, for proof-of-work blocks and one unit for
+// every other block.
+func BlockWork(block Block) *big.Int {
+	if block.Difficulty == 0 {
+		return big.NewInt(1)
+	}
+	return new(big.Int).Lsh(big.NewInt(1), uint(block.Difficulty))
+}
+
+// ReorgEvent describes a change of head that is not a simple extension of
+// the previous head. Removed and Added are ordered from the common ancestor
+// towards the respective head.
+type ReorgEvent struct {
+	OldHead        Block
+	NewHead        Block
+	CommonAncestor Block
+	Removed        []Block
+	Added          []Block
+}
+
+// DefaultMaxOrphans is the number of blocks with unknown parents a
+// BlockTree holds back before it drops the oldest.
+const DefaultMaxOrphans = 256
+
+// BlockTree keeps every known block, including competing branches, and
+// tracks the head selected by its fork choice rule. Blocks are attached by
+// PrevHash; blocks whose parent is not known yet are kept until it arrives,
+// up to a limit past which the oldest are dropped.
+type BlockTree struct {
+	mu          sync.Mutex
+	forkChoice  ForkChoice
+	nodes       map[string]*TreeNode
+	genesis     *TreeNode
+	head        *TreeNode
+	subscribers []func(ReorgEvent)
+
+	// orphans holds the blocks waiting for a parent by parent hash,
+	// orphanParents their parent hashes by block hash, and orphanQueue
+	// their hashes in arrival order; entries of blocks connected since
+	// are skipped.
+	orphans       map[string][]Block
+	orphanParents map[string]string
+	orphanQueue   []string
+	maxOrphans    int
+}
+
+func NewBlockTree(genesis Block, forkChoice ForkChoice) *BlockTree {
+	root := &TreeNode{Block: genesis, TotalWork: BlockWork(genesis)}
+	return &BlockTree{
+		forkChoice:    forkChoice,
+		nodes:         map[string]*TreeNode{normalizeHash(genesis.Hash): root},
+		genesis:       root,
+		head:          root,
+		orphans:       make(map[string][]Block),
+		orphanParents: make(map[string]string),
+		maxOrphans:    DefaultMaxOrphans,
+	}
+}
+
+// SetMaxOrphans changes how many blocks with unknown parents are held back.
+// Values below one hold back nothing.
+func (t *BlockTree) SetMaxOrphans(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxOrphans = max(n, 0)
+	t.evictOrphans()
+}
+
+// Subscribe registers fn to be called for every reorg. Callbacks run after
+// the tree has been updated and may call back into it.
+func (t *BlockTree) Subscribe(fn func(ReorgEvent)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.subscribers = append(t.subscribers, fn)
+}
+
+// AddBlock inserts block under its parent. If the parent is unknown the
+// block is held back, ErrUnknownParent is returned, and the block is
+// connected as soon as its parent is added.
+func (t *BlockTree) AddBlock(block Block) error {
+	t.mu.Lock()
+	events, err := t.insert(block)
+	subscribers := t.subscribers
+	t.mu.Unlock()
+
+	for _, event := range events {
+		for _, fn := range subscribers {
+			fn(event)
+		}
+	}
+	return err
+}
+
+func (t *BlockTree) insert(block Block) ([]ReorgEvent, error) {
+	hash := normalizeHash(block.Hash)
+	if _, ok := t.nodes[hash]; ok {
+		return nil, fmt.Errorf("block %s: %w", block.Hash, ErrDuplicateBlock)
+	}
+	parent, ok := t.nodes[normalizeHash(block.PrevHash)]
+	if !ok {
+		t.addOrphan(block)
+		return nil, fmt.Errorf("block %s waits for parent %s: %w", block.Hash, block.PrevHash, ErrUnknownParent)
+	}
+
+	var events []ReorgEvent
+	pending := []*TreeNode{t.attach(parent, block)}
+	for len(pending) > 0 {
+		node := pending[0]
+		pending = pending[1:]
+		if t.forkChoice.Prefer(node, t.head) {
+			if event, reorg := t.setHead(node); reorg {
+				events = append(events, event)
+			}
+		}
+		hash := normalizeHash(node.Block.Hash)
+		for _, orphan := range t.orphans[hash] {
+			delete(t.orphanParents, normalizeHash(orphan.Hash))
+			if _, known := t.nodes[normalizeHash(orphan.Hash)]; !known {
+				pending = append(pending, t.attach(node, orphan))
+			}
+		}
+		delete(t.orphans, hash)
+	}
+	return events, nil
+}
+
+// addOrphan holds block back until its parent arrives, dropping the oldest
+// orphans if the pool is full.
+func (t *BlockTree) addOrphan(block Block) {
+	hash, parentHash := normalizeHash(block.Hash), normalizeHash(block.PrevHash)
+	if _, ok := t.orphanParents[hash]; ok {
+		return
+	}
+	t.orphans[parentHash] = append(t.orphans[parentHash], block)
+	t.orphanParents[hash] = parentHash
+	t.orphanQueue = append(t.orphanQueue, hash)
+	t.evictOrphans()
+}
+
+// evictOrphans drops the oldest orphans until at most maxOrphans are left.
+func (t *BlockTree) evictOrphans() {
+	for len(t.orphanParents) > t.maxOrphans {
+		hash := t.orphanQueue[0]
+		t.orphanQueue = t.orphanQueue[1:]
+		parentHash, ok := t.orphanParents[hash]
+		if !ok {
+			continue
+		}
+		delete(t.orphanParents, hash)
+		siblings := t.orphans[parentHash]
+		for i := range siblings {
+			if normalizeHash(siblings[i].Hash) == hash {
+				siblings = append(siblings[:i], siblings[i+1:]...)
+				break
+			}
+		}
+		if len(siblings) == 0 {
+			delete(t.orphans, parentHash)
+		} else {
+			t.orphans[parentHash] = siblings
+		}
+	}
+	// Entries of connected orphans pile up in the queue otherwise.
+	if len(t.orphanQueue) > 2*len(t.orphanParents)+DefaultMaxOrphans {
+		queue := t.orphanQueue[:0]
+		for _, hash := range t.orphanQueue {
+			if _, ok := t.orphanParents[hash]; ok {
+				queue = append(queue, hash)
+			}
+		}
+		t.orphanQueue = queue
+	}
+}
+
+func (t *BlockTree) attach(parent *TreeNode, block Block) *TreeNode {
+	node := &TreeNode{
+		Block:     block,
+		Parent:    parent,
+		Height:    parent.Height + 1,
+		TotalWork: new(big.Int).Add(parent.TotalWork, BlockWork(block)),
+	}
+	parent.Children = append(parent.Children, node)
+	t.nodes[normalizeHash(block.Hash)] = node
+	return node
+}
+
+// setHead moves the head to node and reports a reorg unless node extends
+// the old head.
+func (t *BlockTree) setHead(node *TreeNode) (ReorgEvent, bool) {
+	old := t.head
+	t.head = node
+
+	ancestor := commonAncestor(old, node)
+	if ancestor == old {
+		return ReorgEvent{}, false
+	}
+	return ReorgEvent{
+		OldHead:        old.Block,
+		NewHead:        node.Block,
+		CommonAncestor: ancestor.Block,
+		Removed:        branch(ancestor, old),
+		Added:          branch(ancestor, node),
+	}, true
+}
+
+func commonAncestor(a, b *TreeNode) *TreeNode {
+	for a.Height > b.Height {
+		a = a.Parent
+	}
+	for b.Height > a.Height {
+		b = b.Parent
+	}
+	for a != b {
+		a, b = a.Parent, b.Parent
+	}
+	return a
+}
+
+// branch returns the blocks after ancestor up to and including tip.
+func branch(ancestor, tip *TreeNode) []Block {
+	blocks := make([]Block, tip.Height-ancestor.Height)
+	for n := tip; n != ancestor; n = n.Parent {
+		blocks[n.Height-ancestor.Height-1] = n.Block
+	}
+	return blocks
+}
+
+func (t *BlockTree) Head() Block {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.head.Block
+}
+
+// Tips returns the blocks without children, i.e. the heads of all branches.
+func (t *BlockTree) Tips() []Block {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var tips []Block
+	for _, node := range t.nodes {
+		if len(node.Children) == 0 {
+			tips = append(tips, node.Block)
+		}
+	}
+	return tips
+}
+
+// GetNode returns the tree node of the block with the given hash.
+func (t *BlockTree) GetNode(hash string) (*TreeNode, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node, ok := t.nodes[normalizeHash(hash)]
+	if !ok {
+		return nil, fmt.Errorf("block %s: %w", hash, ErrBlockNotFound)
+	}
+	return node, nil
+}
+
+// CanonicalChain returns the chain from genesis to the current head.
+func (t *BlockTree) CanonicalChain() *Blockchain {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &Blockchain{Chain: append([]Block{t.genesis.Block}, branch(t.genesis, t.head)...)}
+}
+
+// ChainTo returns the chain from genesis to the block with the given hash,
+// which does not have to be on the canonical branch.
+func (t *BlockTree) ChainTo(hash string) (*Blockchain, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node, ok := t.nodes[normalizeHash(hash)]
+	if !ok {
+		return nil, fmt.Errorf("block %s: %w", hash, ErrBlockNotFound)
+	}
+	return &Blockchain{Chain: append([]Block{t.genesis.Block}, branch(t.genesis, node)...)}, nil
+}
@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// treeBlock returns a block named hash on top of parent; BlockTree only
+// looks at the linkage and the difficulty.
+func treeBlock(parent Block, hash string, difficulty uint64) Block {
+	return Block{Index: parent.Index + 1, PrevHash: parent.Hash, Hash: hash, Difficulty: difficulty}
+}
+
+func TestBlockTreeLongestChainReorg(t *testing.T) {
+	genesis := Block{Hash: "g"}
+	tree := NewBlockTree(genesis, LongestChain{})
+	var events []ReorgEvent
+	tree.Subscribe(func(e ReorgEvent) { events = append(events, e) })
+
+	a1 := treeBlock(genesis, "a1", 0)
+	b1 := treeBlock(genesis, "b1", 0)
+	b2 := treeBlock(b1, "b2", 0)
+	for _, block := range []Block{a1, b1} {
+		if err := tree.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tree.Head().Hash != "a1" {
+		t.Fatalf("head %s, want the first block seen", tree.Head().Hash)
+	}
+	if err := tree.AddBlock(b2); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Head().Hash != "b2" || len(events) != 1 {
+		t.Fatalf("head %s after %d reorgs", tree.Head().Hash, len(events))
+	}
+	e := events[0]
+	if e.CommonAncestor.Hash != "g" || len(e.Removed) != 1 || e.Removed[0].Hash != "a1" || len(e.Added) != 2 || e.Added[1].Hash != "b2" {
+		t.Fatalf("reorg %+v", e)
+	}
+	if chain := tree.CanonicalChain(); len(chain.Chain) != 3 || chain.Chain[1].Hash != "b1" {
+		t.Fatalf("canonical chain %+v", chain.Chain)
+	}
+	if err := tree.AddBlock(a1); !errors.Is(err, ErrDuplicateBlock) {
+		t.Fatalf("duplicate block: %v", err)
+	}
+}
+
+func TestBlockTreeHeaviestWork(t *testing.T) {
+	genesis := Block{Hash: "g"}
+	tree := NewBlockTree(genesis, HeaviestWork{})
+	light1 := treeBlock(genesis, "l1", 1)
+	light2 := treeBlock(light1, "l2", 1)
+	heavy := treeBlock(genesis, "h1", 4)
+	for _, block := range []Block{light1, light2, heavy} {
+		if err := tree.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tree.Head().Hash != "h1" {
+		t.Fatalf("head %s, want the heavier single block", tree.Head().Hash)
+	}
+}
+
+func TestBlockTreeConnectsOrphans(t *testing.T) {
+	genesis := Block{Hash: "g"}
+	tree := NewBlockTree(genesis, LongestChain{})
+	b1 := treeBlock(genesis, "b1", 0)
+	b2 := treeBlock(b1, "b2", 0)
+	b3 := treeBlock(b2, "b3", 0)
+	for _, block := range []Block{b3, b2} {
+		if err := tree.AddBlock(block); !errors.Is(err, ErrUnknownParent) {
+			t.Fatalf("block %s: %v", block.Hash, err)
+		}
+	}
+	if err := tree.AddBlock(b1); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Head().Hash != "b3" || len(tree.orphanParents) != 0 {
+		t.Fatalf("head %s with %d orphans left", tree.Head().Hash, len(tree.orphanParents))
+	}
+}
+
+func TestBlockTreeCapsOrphans(t *testing.T) {
+	genesis := Block{Hash: "g"}
+	tree := NewBlockTree(genesis, LongestChain{})
+	tree.SetMaxOrphans(3)
+
+	// Five blocks on parents that never arrive, then one whose parent does.
+	for i := 0; i < 5; i++ {
+		parent := Block{Index: 10, Hash: fmt.Sprintf("missing%d", i)}
+		if err := tree.AddBlock(treeBlock(parent, fmt.Sprintf("o%d", i), 0)); !errors.Is(err, ErrUnknownParent) {
+			t.Fatal(err)
+		}
+	}
+	if len(tree.orphanParents) != 3 {
+		t.Fatalf("%d orphans held, want 3", len(tree.orphanParents))
+	}
+	for _, dropped := range []string{"o0", "o1"} {
+		if _, ok := tree.orphanParents[dropped]; ok {
+			t.Fatalf("oldest orphan %s was kept", dropped)
+		}
+	}
+
+	b1 := treeBlock(genesis, "b1", 0)
+	b2 := treeBlock(b1, "b2", 0)
+	// b2 waits in the full pool and pushes out o2.
+	tree.AddBlock(b2)
+	if err := tree.AddBlock(b1); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Head().Hash != "b2" || len(tree.orphanParents) != 2 {
+		t.Fatalf("head %s with %d orphans", tree.Head().Hash, len(tree.orphanParents))
+	}
+	tree.SetMaxOrphans(0)
+	if len(tree.orphanParents) != 0 || len(tree.orphans) != 0 {
+		t.Fatalf("%d orphans left after disabling the pool", len(tree.orphanParents))
+	}
+}
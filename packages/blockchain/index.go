@@ -0,0 +1,172 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// TxLocation identifies a transaction by the position of its block in the
+// chain and its position inside the block.
+type TxLocation struct {
+	BlockIndex int    `json:"blockIndex"`
+	BlockHash  string `json:"blockHash"`
+	TxIndex    int    `json:"txIndex"`
+}
+
+type LocatedTransaction struct {
+	Transaction Transaction `json:"transaction"`
+	Location    TxLocation  `json:"location"`
+}
+
+// chainIndex maps block hashes, transaction hashes and addresses to
+// positions in Chain. It is built lazily and extended when blocks are
+// appended, so chains assembled without AddBlock are indexed too. It is
+// keyed on the hash of the last block it covers: if that block is no
+// longer at the same position, the chain changed under it and it is
+// rebuilt. Blockchain.indexMu guards it.
+type chainIndex struct {
+	indexed   int
+	tip       string
+	blocks    map[string]int
+	numbers   map[int]int
+	txs       map[string]TxLocation
+	addresses map[string][]TxLocation
+}
+
+func normalizeHash(hash string) string {
+	return strings.ToLower(strings.TrimPrefix(hash, "0x"))
+}
+
+func normalizeAddress(address string) string {
+	return "0x" + strings.ToLower(strings.TrimPrefix(address, "0x"))
+}
+
+// stale reports whether the chain no longer starts with the blocks idx
+// covers: it shrank, or its tail was replaced.
+func (idx *chainIndex) stale(chain []Block) bool {
+	if idx.indexed > len(chain) {
+		return true
+	}
+	return idx.indexed > 0 && chain[idx.indexed-1].Hash != idx.tip
+}
+
+// ensureIndex brings bc.index up to date with Chain. The caller holds
+// bc.indexMu.
+func (bc *Blockchain) ensureIndex() (*chainIndex, error) {
+	if bc.index == nil || bc.index.stale(bc.Chain) {
+		bc.index = &chainIndex{
+			blocks:    make(map[string]int),
+			numbers:   make(map[int]int),
+			txs:       make(map[string]TxLocation),
+			addresses: make(map[string][]TxLocation),
+		}
+	}
+	idx := bc.index
+	for ; idx.indexed < len(bc.Chain); idx.indexed++ {
+		block := &bc.Chain[idx.indexed]
+		idx.blocks[normalizeHash(block.Hash)] = idx.indexed
+		idx.numbers[block.Index] = idx.indexed
+		for i, content := range block.Transactions {
+			tx, ok := content.(Transaction)
+			if !ok {
+				continue
+			}
+			hash, err := tx.CalculateHash()
+			if err != nil {
+				return nil, fmt.Errorf("block %d: failed to hash transaction %d: %w", block.Index, i, err)
+			}
+			loc := TxLocation{BlockIndex: idx.indexed, BlockHash: block.Hash, TxIndex: i}
+			// Synthetic chains repeat transactions; the first occurrence wins.
+			if _, ok := idx.txs[hex.EncodeToString(hash)]; !ok {
+				idx.txs[hex.EncodeToString(hash)] = loc
+			}
+			idx.addresses[normalizeAddress(tx.Sender)] = append(idx.addresses[normalizeAddress(tx.Sender)], loc)
+			if tx.Receiver != "" && !strings.EqualFold(tx.Receiver, tx.Sender) {
+				idx.addresses[normalizeAddress(tx.Receiver)] = append(idx.addresses[normalizeAddress(tx.Receiver)], loc)
+			}
+		}
+		idx.tip = block.Hash
+	}
+	return idx, nil
+}
+
+// BlockPosition returns the position in Chain of the block with the given
+// hex hash.
+func (bc *Blockchain) BlockPosition(hash string) (int, error) {
+	bc.indexMu.Lock()
+	defer bc.indexMu.Unlock()
+	idx, err := bc.ensureIndex()
+	if err != nil {
+		return 0, err
+	}
+	i, ok := idx.blocks[normalizeHash(hash)]
+	if !ok {
+		return 0, fmt.Errorf("block %s: %w", hash, ErrBlockNotFound)
+	}
+	return i, nil
+}
+
+// BlockPositionByNumber returns the position in Chain of the block with the
+// given Index: the same number in synthetic chains, the source chain's
+// block number in imported ones.
+func (bc *Blockchain) BlockPositionByNumber(number int) (int, error) {
+	bc.indexMu.Lock()
+	defer bc.indexMu.Unlock()
+	idx, err := bc.ensureIndex()
+	if err != nil {
+		return 0, err
+	}
+	i, ok := idx.numbers[number]
+	if !ok {
+		return 0, fmt.Errorf("block number %d: %w", number, ErrBlockNotFound)
+	}
+	return i, nil
+}
+
+func (bc *Blockchain) GetBlockByHash(hash string) (Block, error) {
+	i, err := bc.BlockPosition(hash)
+	if err != nil {
+		return Block{}, err
+	}
+	return verifyBlock(bc.Chain[i])
+}
+
+// GetTransactionByHash looks up a transaction by the hex encoding of its
+// CalculateHash.
+func (bc *Blockchain) GetTransactionByHash(hash string) (Transaction, TxLocation, error) {
+	bc.indexMu.Lock()
+	defer bc.indexMu.Unlock()
+	idx, err := bc.ensureIndex()
+	if err != nil {
+		return Transaction{}, TxLocation{}, err
+	}
+	loc, ok := idx.txs[normalizeHash(hash)]
+	if !ok {
+		return Transaction{}, TxLocation{}, fmt.Errorf("transaction %s: %w", hash, ErrTransactionNotFound)
+	}
+	return bc.Chain[loc.BlockIndex].Transactions[loc.TxIndex].(Transaction), loc, nil
+}
+
+// GetTransactionsByAddress returns every transaction sent or received by
+// address, in chain order.
+func (bc *Blockchain) GetTransactionsByAddress(address string) ([]LocatedTransaction, error) {
+	bc.indexMu.Lock()
+	defer bc.indexMu.Unlock()
+	idx, err := bc.ensureIndex()
+	if err != nil {
+		return nil, err
+	}
+	locs := idx.addresses[normalizeAddress(address)]
+	txs := make([]LocatedTransaction, len(locs))
+	for i, loc := range locs {
+		txs[i] = LocatedTransaction{
+			Transaction: bc.Chain[loc.BlockIndex].Transactions[loc.TxIndex].(Transaction),
+			Location:    loc,
+		}
+	}
+	return txs, nil
+}
@@ -0,0 +1,78 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestIndexLookups(t *testing.T) {
+	bc := testBlockchain(t, 4)
+	block, err := bc.GetBlockByHash("0x" + bc.Chain[2].Hash)
+	if err != nil || block.Index != 2 {
+		t.Fatalf("GetBlockByHash = block %d, %v", block.Index, err)
+	}
+	if _, err := bc.GetBlockByHash("00"); !errors.Is(err, ErrBlockNotFound) {
+		t.Fatalf("unknown hash: %v", err)
+	}
+
+	tx := bc.Chain[3].Transactions[1].(Transaction)
+	hash, err := tx.CalculateHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, loc, err := bc.GetTransactionByHash(hex.EncodeToString(hash))
+	if err != nil || got.Sender != tx.Sender || loc.BlockIndex != 3 || loc.TxIndex != 1 {
+		t.Fatalf("GetTransactionByHash = %+v at %+v, %v", got, loc, err)
+	}
+	txs, err := bc.GetTransactionsByAddress(tx.Sender)
+	if err != nil || len(txs) == 0 {
+		t.Fatalf("GetTransactionsByAddress = %d transactions, %v", len(txs), err)
+	}
+}
+
+func TestIndexFollowsReplacedTail(t *testing.T) {
+	bc := testBlockchain(t, 4)
+	old := bc.Chain[3].Hash
+	if _, err := bc.BlockPosition(old); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reorg of the same length: the last block is replaced.
+	replacement := testBlockchain(t, 4).Chain[3]
+	bc.Chain[3] = replacement
+	if _, err := bc.BlockPosition(old); !errors.Is(err, ErrBlockNotFound) {
+		t.Fatalf("replaced block is still indexed: %v", err)
+	}
+	if i, err := bc.BlockPosition(replacement.Hash); err != nil || i != 3 {
+		t.Fatalf("BlockPosition(replacement) = %d, %v", i, err)
+	}
+
+	// Appending keeps the index and extends it.
+	next, err := CreateBlock(4, GenerateTransactionsForBlock(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddBlock(next); err != nil {
+		t.Fatal(err)
+	}
+	if i, err := bc.BlockPositionByNumber(4); err != nil || i != 4 {
+		t.Fatalf("BlockPositionByNumber(4) = %d, %v", i, err)
+	}
+}
+
+func TestIndexConcurrentLookups(t *testing.T) {
+	bc := testBlockchain(t, 8)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			if i, err := bc.BlockPosition(bc.Chain[g].Hash); err != nil || i != g {
+				t.Errorf("BlockPosition(block %d) = %d, %v", g, i, err)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,312 @@
+package blockchain
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/cbergoon/merkletree"
+)
+
+var (
+	ErrKnownTransaction = errors.New("transaction already in mempool")
+	ErrNonceTooLow      = errors.New("nonce too low")
+	ErrUnderpriced      = errors.New("fee too low")
+	ErrMempoolFull      = errors.New("mempool full")
+	ErrNoTransactions   = errors.New("no transactions fit in block")
+)
+
+// Mempool holds pending transactions per sender, ordered by nonce. A sender's
+// transactions become executable in nonce order starting at the sender's
+// account nonce, which advances as transactions are included in blocks.
+//
+// When the pool is full, a new transaction evicts the cheapest transaction
+// that is last in another sender's queue, so queues never get gaps; if no
+// such transaction is cheaper than the new one, the new one is rejected.
+// The new transaction's own sender is left alone: evicting its last
+// transaction would leave a gap behind the new one if it has a lower nonce.
+type Mempool struct {
+	mu       sync.Mutex
+	capacity int
+	size     int
+	queues   map[string][]Transaction
+	nonces   map[string]uint64
+	known    map[string]struct{}
+}
+
+func NewMempool(capacity int) *Mempool {
+	return &Mempool{
+		capacity: capacity,
+		queues:   make(map[string][]Transaction),
+		nonces:   make(map[string]uint64),
+		known:    make(map[string]struct{}),
+	}
+}
+
+func transactionKey(tx Transaction) (string, error) {
+	hash, err := tx.CalculateHash()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash), nil
+}
+
+// Add inserts tx. A transaction with the same sender and nonce as a pending
+// one replaces it if it pays a higher fee.
+func (m *Mempool) Add(tx Transaction) error {
+	key, err := transactionKey(tx)
+	if err != nil {
+		return err
+	}
+	sender := normalizeAddress(tx.Sender)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.known[key]; ok {
+		return fmt.Errorf("transaction %s: %w", key, ErrKnownTransaction)
+	}
+	if tx.Nonce < m.nonces[sender] {
+		return fmt.Errorf("transaction %s has nonce %d, account is at %d: %w", key, tx.Nonce, m.nonces[sender], ErrNonceTooLow)
+	}
+
+	queue := m.queues[sender]
+	i := sort.Search(len(queue), func(i int) bool { return queue[i].Nonce >= tx.Nonce })
+	if i < len(queue) && queue[i].Nonce == tx.Nonce {
+		if tx.Fee <= queue[i].Fee {
+			return fmt.Errorf("replacement for nonce %d must pay more than %f: %w", tx.Nonce, queue[i].Fee, ErrUnderpriced)
+		}
+		old, _ := transactionKey(queue[i])
+		delete(m.known, old)
+		queue[i] = tx
+		m.known[key] = struct{}{}
+		return nil
+	}
+
+	if m.capacity > 0 && m.size >= m.capacity {
+		if err := m.evictCheaperThan(tx.Fee, sender); err != nil {
+			return err
+		}
+	}
+	queue = append(queue, Transaction{})
+	copy(queue[i+1:], queue[i:])
+	queue[i] = tx
+	m.queues[sender] = queue
+	m.known[key] = struct{}{}
+	m.size++
+	return nil
+}
+
+// evictCheaperThan drops the cheapest last transaction of a sender other
+// than exclude if it pays less than fee.
+func (m *Mempool) evictCheaperThan(fee float64, exclude string) error {
+	victim := ""
+	for sender, queue := range m.queues {
+		if sender == exclude {
+			continue
+		}
+		last := queue[len(queue)-1]
+		if last.Fee < fee && (victim == "" || last.Fee < m.queues[victim][len(m.queues[victim])-1].Fee) {
+			victim = sender
+		}
+	}
+	if victim == "" {
+		return fmt.Errorf("no pending transaction pays less than %f: %w", fee, ErrMempoolFull)
+	}
+	m.dropLast(victim)
+	return nil
+}
+
+func (m *Mempool) dropLast(sender string) {
+	queue := m.queues[sender]
+	key, _ := transactionKey(queue[len(queue)-1])
+	delete(m.known, key)
+	m.size--
+	if len(queue) == 1 {
+		delete(m.queues, sender)
+	} else {
+		m.queues[sender] = queue[:len(queue)-1]
+	}
+}
+
+func (m *Mempool) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.size
+}
+
+// Pending returns the executable transactions of every sender: the run of
+// consecutive nonces starting at the sender's account nonce.
+func (m *Mempool) Pending() map[string][]Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pending := make(map[string][]Transaction)
+	for sender, queue := range m.queues {
+		if ready := m.executable(sender, queue); len(ready) > 0 {
+			pending[sender] = append([]Transaction(nil), ready...)
+		}
+	}
+	return pending
+}
+
+func (m *Mempool) executable(sender string, queue []Transaction) []Transaction {
+	next := m.nonces[sender]
+	n := 0
+	for n < len(queue) && queue[n].Nonce == next {
+		n++
+		next++
+	}
+	return queue[:n]
+}
+
+// Remove drops the transactions of an included block from the pool and
+// advances the account nonces of their senders.
+func (m *Mempool) Remove(included []merkletree.Content) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, content := range included {
+		tx, ok := content.(Transaction)
+		if !ok {
+			continue
+		}
+		sender := normalizeAddress(tx.Sender)
+		if tx.Nonce+1 > m.nonces[sender] {
+			m.nonces[sender] = tx.Nonce + 1
+		}
+		queue := m.queues[sender]
+		n := 0
+		for n < len(queue) && queue[n].Nonce < m.nonces[sender] {
+			key, _ := transactionKey(queue[n])
+			delete(m.known, key)
+			n++
+		}
+		m.size -= n
+		if n == len(queue) {
+			delete(m.queues, sender)
+		} else {
+			m.queues[sender] = queue[n:]
+		}
+	}
+}
+
+// candidates is a max-heap of sender queues ordered by the fee of their next
+// transaction.
+type candidates [][]Transaction
+
+func (c candidates) Len() int           { return len(c) }
+func (c candidates) Less(i, j int) bool { return c[i][0].Fee > c[j][0].Fee }
+func (c candidates) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c *candidates) Push(x any)        { *c = append(*c, x.([]Transaction)) }
+func (c *candidates) Pop() any {
+	old := *c
+	x := old[len(old)-1]
+	*c = old[:len(old)-1]
+	return x
+}
+
+// IntrinsicGas is the gas charged for a transaction: a flat 21000 plus 16 per
+// payload byte, as for Ethereum call data.
+func IntrinsicGas(tx Transaction) uint64 {
+	return 21000 + 16*uint64(len(tx.Payload))
+}
+
+func transactionSize(tx Transaction) int {
+	var buf bytes.Buffer
+	var content merkletree.Content = tx
+	if err := gob.NewEncoder(&buf).Encode(&content); err != nil {
+		return 0
+	}
+	return buf.Len()
+}
+
+// BlockBuilder fills blocks from a mempool, highest fee first while keeping
+// each sender's nonce order. A zero limit is not enforced.
+type BlockBuilder struct {
+	Mempool         *Mempool
+	MaxBlockSize    int
+	GasLimit        uint64
+	MaxTransactions int
+}
+
+// Build selects transactions for a block at index. The size limit is
+// checked against CalculateBlockSize of the assembled block.
+func (b *BlockBuilder) Build(index int) (Block, error) {
+	pending := b.Mempool.Pending()
+	senders := make([]string, 0, len(pending))
+	for sender := range pending {
+		senders = append(senders, sender)
+	}
+	// Map iteration order is random; sort so equal fees resolve the same way
+	// on every run.
+	sort.Strings(senders)
+	h := make(candidates, 0, len(senders))
+	for _, sender := range senders {
+		h = append(h, pending[sender])
+	}
+	heap.Init(&h)
+
+	base, err := CalculateBlockSize(Block{Index: index})
+	if err != nil {
+		return Block{}, err
+	}
+	size, gas := base, uint64(0)
+	var selected []merkletree.Content
+	for h.Len() > 0 && (b.MaxTransactions == 0 || len(selected) < b.MaxTransactions) {
+		queue := heap.Pop(&h).([]Transaction)
+		tx := queue[0]
+		txSize, txGas := transactionSize(tx), IntrinsicGas(tx)
+		if (b.MaxBlockSize > 0 && size+txSize > b.MaxBlockSize) || (b.GasLimit > 0 && gas+txGas > b.GasLimit) {
+			// The sender's later nonces cannot go in without this one.
+			continue
+		}
+		selected = append(selected, tx)
+		size += txSize
+		gas += txGas
+		if len(queue) > 1 {
+			heap.Push(&h, queue[1:])
+		}
+	}
+
+	for len(selected) > 0 {
+		block, err := CreateBlock(index, selected)
+		if err != nil {
+			return Block{}, err
+		}
+		if b.MaxBlockSize == 0 {
+			return block, nil
+		}
+		blockSize, err := CalculateBlockSize(block)
+		if err != nil {
+			return Block{}, err
+		}
+		if blockSize <= b.MaxBlockSize {
+			return block, nil
+		}
+		// The last selected transaction has the highest nonce of its
+		// sender, so dropping it leaves no gap.
+		selected = selected[:len(selected)-1]
+	}
+	return Block{}, ErrNoTransactions
+}
+
+// Produce builds the next block for bc, appends it (sealed with engine if
+// one is given) and removes its transactions from the mempool.
+func (b *BlockBuilder) Produce(bc *Blockchain, engine Engine) (Block, error) {
+	block, err := b.Build(len(bc.Chain))
+	if err != nil {
+		return Block{}, err
+	}
+	if engine != nil {
+		if err := bc.AddSealedBlock(block, engine); err != nil {
+			return Block{}, err
+		}
+	} else if err := bc.AddBlock(block); err != nil {
+		return Block{}, err
+	}
+	b.Mempool.Remove(block.Transactions)
+	return bc.Chain[len(bc.Chain)-1], nil
+}
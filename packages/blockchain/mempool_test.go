@@ -0,0 +1,100 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cbergoon/merkletree"
+)
+
+func mempoolTx(sender string, nonce uint64, fee float64) Transaction {
+	return Transaction{Sender: sender, Receiver: "0xbob", Amount: 1, Nonce: nonce, Fee: fee}
+}
+
+func TestMempoolEviction(t *testing.T) {
+	m := NewMempool(3)
+	for _, tx := range []Transaction{
+		mempoolTx("0xa", 0, 1),
+		mempoolTx("0xa", 2, 1),
+		mempoolTx("0xb", 0, 2),
+	} {
+		if err := m.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Sender a's cheap last transaction must not make room for its own
+	// nonce 1, and b's pays more than the new one.
+	if err := m.Add(mempoolTx("0xa", 1, 1.5)); !errors.Is(err, ErrMempoolFull) {
+		t.Fatalf("full pool: %v", err)
+	}
+	// Another sender's expensive transaction evicts a's last one.
+	if err := m.Add(mempoolTx("0xc", 0, 5)); err != nil {
+		t.Fatal(err)
+	}
+	pending := m.Pending()
+	if m.Len() != 3 || len(pending["0xa"]) != 1 || len(pending["0xb"]) != 1 || len(pending["0xc"]) != 1 {
+		t.Fatalf("%d pending after eviction: %v", m.Len(), pending)
+	}
+	if err := m.Add(mempoolTx("0xd", 0, 0.5)); !errors.Is(err, ErrMempoolFull) {
+		t.Fatalf("cheaper than everything pending: %v", err)
+	}
+}
+
+func TestMempoolNonces(t *testing.T) {
+	m := NewMempool(0)
+	for _, tx := range []Transaction{mempoolTx("0xa", 1, 1), mempoolTx("0xa", 0, 1), mempoolTx("0xa", 3, 1)} {
+		if err := m.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ready := m.Pending()["0xa"]; len(ready) != 2 || ready[1].Nonce != 1 {
+		t.Fatalf("executable %v, want nonces 0 and 1", ready)
+	}
+	if err := m.Add(mempoolTx("0xa", 1, 1)); !errors.Is(err, ErrKnownTransaction) {
+		t.Fatalf("known transaction: %v", err)
+	}
+	if err := m.Add(mempoolTx("0xa", 1, 0.5)); !errors.Is(err, ErrUnderpriced) {
+		t.Fatalf("cheaper replacement: %v", err)
+	}
+	if err := m.Add(mempoolTx("0xa", 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Remove([]merkletree.Content{mempoolTx("0xa", 0, 1)})
+	if err := m.Add(mempoolTx("0xa", 0, 9)); !errors.Is(err, ErrNonceTooLow) {
+		t.Fatalf("included nonce: %v", err)
+	}
+}
+
+func TestBlockBuilderProduce(t *testing.T) {
+	m := NewMempool(0)
+	for _, tx := range []Transaction{mempoolTx("0xa", 0, 1), mempoolTx("0xa", 1, 9), mempoolTx("0xb", 0, 5)} {
+		if err := m.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	builder := &BlockBuilder{Mempool: m, MaxTransactions: 2}
+	bc := testBlockchain(t, 1)
+
+	// A failing engine leaves the chain and the pool as they were.
+	if _, err := builder.Produce(bc, ProofOfWork{}); err == nil {
+		t.Fatal("sealing with an invalid engine succeeded")
+	}
+	if len(bc.Chain) != 1 || m.Len() != 3 {
+		t.Fatalf("failed production left %d blocks and %d transactions", len(bc.Chain), m.Len())
+	}
+
+	block, err := builder.Produce(bc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b's fee 5 goes first; a's nonce 1 cannot go before its nonce 0.
+	first, second := block.Transactions[0].(Transaction), block.Transactions[1].(Transaction)
+	if first.Sender != "0xb" || second.Sender != "0xa" || second.Nonce != 0 {
+		t.Fatalf("block holds %+v, %+v", first, second)
+	}
+	if m.Len() != 1 || ValidateChain(bc, nil) != nil {
+		t.Fatalf("%d transactions left in the pool", m.Len())
+	}
+}
@@ -0,0 +1,308 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+const snapshotVersion = 1
+
+// AccountState is the state of one address after applying a chain prefix.
+// Synthetic chains never fund their accounts, so balances are net flows and
+// may be negative.
+type AccountState struct {
+	Balance float64 `json:"balance"`
+	Nonce   uint64  `json:"nonce"`
+}
+
+// State maps normalized addresses to their account state.
+type State map[string]AccountState
+
+// Apply applies the transactions of block to s: the sender pays amount and
+// fee, the receiver gets the amount and the sender's nonce moves past the
+// transaction's.
+func (s State) Apply(block Block) {
+	for _, content := range block.Transactions {
+		tx, ok := content.(Transaction)
+		if !ok {
+			continue
+		}
+		sender := normalizeAddress(tx.Sender)
+		from := s[sender]
+		from.Balance -= tx.Amount + tx.Fee
+		if tx.Nonce >= from.Nonce {
+			from.Nonce = tx.Nonce + 1
+		}
+		s[sender] = from
+		if tx.Receiver != "" {
+			receiver := normalizeAddress(tx.Receiver)
+			to := s[receiver]
+			to.Balance += tx.Amount
+			s[receiver] = to
+		}
+	}
+}
+
+func (s State) clone() State {
+	c := make(State, len(s))
+	for address, account := range s {
+		c[address] = account
+	}
+	return c
+}
+
+// Root hashes the accounts in address order, so equal states have equal
+// roots regardless of map order.
+func (s State) Root() []byte {
+	addresses := make([]string, 0, len(s))
+	for address := range s {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	h := sha256.New()
+	for _, address := range addresses {
+		fmt.Fprintf(h, "%s|%f|%d\n", address, s[address].Balance, s[address].Nonce)
+	}
+	return h.Sum(nil)
+}
+
+// BlockHeader is a block without its transactions. It still carries the
+// Merkle root, so the block hash can be recomputed from the header alone.
+type BlockHeader struct {
+	Index      int
+	Timestamp  string
+	PrevHash   string
+	Hash       string
+	MerkleRoot []byte
+	Difficulty uint64
+	Nonce      uint64
+	Signer     string
+	Signature  []byte
+	Origin     string
+}
+
+func (b Block) Header() BlockHeader {
+	return BlockHeader{
+		Index:      b.Index,
+		Timestamp:  b.Timestamp,
+		PrevHash:   b.PrevHash,
+		Hash:       b.Hash,
+		MerkleRoot: b.MerkleRoot,
+		Difficulty: b.Difficulty,
+		Nonce:      b.Nonce,
+		Signer:     b.Signer,
+		Signature:  b.Signature,
+		Origin:     b.Origin,
+	}
+}
+
+// block returns the header as a pruned block.
+func (h BlockHeader) block() Block {
+	return Block{
+		Index:      h.Index,
+		Timestamp:  h.Timestamp,
+		PrevHash:   h.PrevHash,
+		Hash:       h.Hash,
+		MerkleRoot: h.MerkleRoot,
+		Proof:      true,
+		Difficulty: h.Difficulty,
+		Nonce:      h.Nonce,
+		Signer:     h.Signer,
+		Signature:  h.Signature,
+		Origin:     h.Origin,
+	}
+}
+
+// Pruned reports whether the block's transactions have been dropped: it
+// commits to transactions through its Merkle root but carries none.
+func (b Block) Pruned() bool {
+	return len(b.Transactions) == 0 && len(b.MerkleRoot) > 0
+}
+
+// Snapshot is the state of a chain at Height (the position in Chain of the
+// last applied block) together with the headers of blocks 0..Height. A node
+// that imports it can check the header chain and continue with the blocks
+// after Height without ever downloading the older bodies.
+//
+// No block commits to the state: StateRoot only protects State against
+// corruption. A node must get the snapshot, or at least its StateRoot, from
+// a source it trusts.
+type Snapshot struct {
+	Version   int
+	Height    int
+	Headers   []BlockHeader
+	State     State
+	StateRoot []byte
+}
+
+// chainBase is the state after applying the blocks up to position height,
+// whose hash was hash when it was recorded.
+type chainBase struct {
+	height int
+	hash   string
+	state  State
+}
+
+// stateAt returns the state after applying blocks 0..height. It starts from
+// the chain's base state if that lies at or below height, so only the
+// bodies after the base must still be present.
+func (bc *Blockchain) stateAt(height int) (State, error) {
+	state, start := make(State), 0
+	if b := bc.base; b != nil && b.height <= height && bc.Chain[b.height].Hash == b.hash {
+		state, start = b.state.clone(), b.height+1
+	}
+	for i := start; i <= height; i++ {
+		block := bc.Chain[i]
+		if block.Pruned() {
+			return nil, fmt.Errorf("block %d has been pruned, its state cannot be recomputed", block.Index)
+		}
+		state.Apply(block)
+	}
+	return state, nil
+}
+
+// Snapshot exports the state after applying blocks 0..height. Every body
+// after the chain's base state (from Prune or Snapshot.Blockchain) up to
+// height must still be present.
+func (bc *Blockchain) Snapshot(height int) (*Snapshot, error) {
+	if height < 0 || height >= len(bc.Chain) {
+		return nil, fmt.Errorf("snapshot height %d out of range: %w", height, ErrBlockNotFound)
+	}
+	state, err := bc.stateAt(height)
+	if err != nil {
+		return nil, err
+	}
+	headers := make([]BlockHeader, height+1)
+	for i := 0; i <= height; i++ {
+		headers[i] = bc.Chain[i].Header()
+	}
+	return &Snapshot{
+		Version:   snapshotVersion,
+		Height:    height,
+		Headers:   headers,
+		State:     state,
+		StateRoot: state.Root(),
+	}, nil
+}
+
+// Prune drops the transactions of every block before height, keeping their
+// headers, and returns the number of bytes freed as measured by
+// CalculateBlockSize. Pruned blocks still validate in ValidateChain, since
+// their hashes only depend on the Merkle root. The state after the pruned
+// blocks is kept as the chain's base state, so snapshots at height - 1 or
+// later can still be taken.
+func (bc *Blockchain) Prune(height int) (int, error) {
+	if height < 0 || height > len(bc.Chain) {
+		return 0, fmt.Errorf("prune height %d out of range: %w", height, ErrBlockNotFound)
+	}
+	if height > 0 && (bc.base == nil || bc.base.height < height-1) {
+		// If bodies before height are gone already and no base state
+		// covers them, there is no state to keep.
+		if state, err := bc.stateAt(height - 1); err == nil {
+			bc.base = &chainBase{height: height - 1, hash: bc.Chain[height-1].Hash, state: state}
+		}
+	}
+	freed := 0
+	for i := 0; i < height; i++ {
+		if len(bc.Chain[i].Transactions) == 0 {
+			continue
+		}
+		before, err := CalculateBlockSize(bc.Chain[i])
+		if err != nil {
+			return freed, err
+		}
+		bc.Chain[i].Transactions = nil
+		after, err := CalculateBlockSize(bc.Chain[i])
+		if err != nil {
+			return freed, err
+		}
+		freed += before - after
+	}
+	// The index points into the dropped bodies.
+	bc.indexMu.Lock()
+	bc.index = nil
+	bc.indexMu.Unlock()
+	return freed, nil
+}
+
+// Verify checks the version, the header chain linkage and hashes, and that
+// StateRoot matches State. It is an integrity check only: since no header
+// commits to the state, a snapshot with a made-up State and a matching
+// StateRoot passes it.
+func (s *Snapshot) Verify() error {
+	if s.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d: %w", s.Version, ErrInvalidSnapshot)
+	}
+	if len(s.Headers) != s.Height+1 {
+		return fmt.Errorf("snapshot at height %d has %d headers: %w", s.Height, len(s.Headers), ErrInvalidSnapshot)
+	}
+	for i, header := range s.Headers {
+		if i > 0 && header.PrevHash != s.Headers[i-1].Hash {
+			return fmt.Errorf("header %d: previous hash does not match header %d: %w", header.Index, s.Headers[i-1].Index, ErrInvalidSnapshot)
+		}
+		if !hashMatches(header.block()) {
+			return fmt.Errorf("header %d: hash mismatch: %w", header.Index, ErrInvalidSnapshot)
+		}
+	}
+	if !bytes.Equal(s.State.Root(), s.StateRoot) {
+		return fmt.Errorf("state root mismatch: %w", ErrInvalidSnapshot)
+	}
+	return nil
+}
+
+// Blockchain verifies the snapshot and returns a chain of pruned blocks up to
+// Height, ready for the following blocks to be appended. The snapshot's
+// state is its base state, so it can take snapshots of its own.
+func (s *Snapshot) Blockchain() (*Blockchain, error) {
+	if err := s.Verify(); err != nil {
+		return nil, err
+	}
+	bc := &Blockchain{Chain: make([]Block, len(s.Headers))}
+	for i, header := range s.Headers {
+		bc.Chain[i] = header.block()
+	}
+	bc.base = &chainBase{height: s.Height, hash: s.Headers[s.Height].Hash, state: s.State.clone()}
+	return bc, nil
+}
+
+// WriteTo gob encodes the snapshot to w.
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		return 0, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	return buf.WriteTo(w)
+}
+
+// ReadSnapshot decodes and verifies a snapshot written by WriteTo.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	var s Snapshot
+	if err := gob.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	if err := s.Verify(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ImportSnapshot reads a snapshot and returns the pruned chain it describes
+// together with its state.
+func ImportSnapshot(r io.Reader) (*Blockchain, State, error) {
+	var s Snapshot
+	if err := gob.NewDecoder(r).Decode(&s); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	bc, err := s.Blockchain()
+	if err != nil {
+		return nil, nil, err
+	}
+	return bc, s.State, nil
+}
@@ -0,0 +1,102 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	bc := testBlockchain(t, 5)
+	snapshot, err := bc.Snapshot(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := snapshot.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	imported, state, err := ImportSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Chain) != 3 || !bytes.Equal(state.Root(), snapshot.StateRoot) {
+		t.Fatalf("imported %d blocks with state root %x", len(imported.Chain), state.Root())
+	}
+
+	// The imported chain continues with the original blocks and takes
+	// snapshots of its own, equal to the original chain's.
+	imported.Chain = append(imported.Chain, bc.Chain[3:]...)
+	if err := ValidateChain(imported, nil); err != nil {
+		t.Fatal(err)
+	}
+	want, err := bc.Snapshot(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := imported.Snapshot(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.StateRoot, want.StateRoot) {
+		t.Fatal("snapshot of the imported chain differs from the original's")
+	}
+	if _, err := imported.Snapshot(1); err == nil {
+		t.Fatal("snapshot below the imported height succeeded")
+	}
+}
+
+func TestSnapshotAfterPrune(t *testing.T) {
+	bc := testBlockchain(t, 5)
+	want, err := bc.Snapshot(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	freed, err := bc.Prune(3)
+	if err != nil || freed <= 0 {
+		t.Fatalf("Prune(3) freed %d bytes, %v", freed, err)
+	}
+	if !bc.Chain[0].Pruned() || bc.Chain[3].Pruned() {
+		t.Fatal("Prune(3) dropped the wrong bodies")
+	}
+	if err := ValidateChain(bc, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Only a prefix can be pruned; a body dropped later is missing.
+	stripped := &Blockchain{Chain: append([]Block(nil), bc.Chain...)}
+	stripped.Chain[4].Transactions = nil
+	if err := ValidateChain(stripped, nil); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("body dropped after unpruned blocks: %v", err)
+	}
+	for _, height := range []int{2, 4} {
+		got, err := bc.Snapshot(height)
+		if err != nil {
+			t.Fatalf("snapshot at %d: %v", height, err)
+		}
+		if height == 4 && !bytes.Equal(got.StateRoot, want.StateRoot) {
+			t.Fatal("snapshot after pruning differs")
+		}
+	}
+	if _, err := bc.Snapshot(1); err == nil {
+		t.Fatal("snapshot inside the pruned blocks succeeded")
+	}
+}
+
+func TestSnapshotVerifyRejectsCorruption(t *testing.T) {
+	bc := testBlockchain(t, 3)
+	for name, corrupt := range map[string]func(s *Snapshot){
+		"state":   func(s *Snapshot) { s.State["0xmallory"] = AccountState{Balance: 1e9} },
+		"header":  func(s *Snapshot) { s.Headers[1].Timestamp = "yesterday" },
+		"linkage": func(s *Snapshot) { s.Headers[2].PrevHash = s.Headers[0].Hash },
+		"height":  func(s *Snapshot) { s.Height = 5 },
+	} {
+		snapshot, err := bc.Snapshot(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		corrupt(snapshot)
+		if err := snapshot.Verify(); !errors.Is(err, ErrInvalidSnapshot) {
+			t.Fatalf("corrupted %s: %v", name, err)
+		}
+	}
+}
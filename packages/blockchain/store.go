@@ -0,0 +1,406 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// BlockStore is a durable, append-only store of blocks on the local disk.
+//
+// Blocks are gob encoded and written to numbered segment files as
+// [length][crc32][payload] records. Every record gets a fixed-size entry in
+// the index file which points at its segment and offset and carries the
+// block hash, so lookups by position or hash do not scan the segments. A
+// record is fsynced before its index entry is written; on open, a torn index
+// entry is dropped, records that made it to the segment but not the index are
+// re-indexed and a torn record at the tail of the last segment is truncated.
+type BlockStore struct {
+	dir            string
+	maxSegmentSize int64
+
+	mu      sync.RWMutex
+	index   *os.File
+	segment *os.File
+	segNum  uint32
+	segSize int64
+	entries []indexEntry
+	byHash  map[string]int
+}
+
+const (
+	recordHeaderSize = 8
+	indexEntrySize   = 4 + 8 + 4 + 32
+	indexFileName    = "index"
+	maxRecordSize    = 256 << 20
+
+	DefaultMaxSegmentSize = 64 << 20
+)
+
+var ErrBlockNotFound = errors.New("block not found")
+
+type indexEntry struct {
+	Segment uint32
+	Offset  uint64
+	Length  uint32
+	Hash    [32]byte
+}
+
+func init() {
+	gob.Register(Transaction{})
+}
+
+// OpenBlockStore opens the store in dir, creating the directory if needed,
+// and recovers from an interrupted append.
+func OpenBlockStore(dir string) (*BlockStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create block store directory: %w", err)
+	}
+	index, err := os.OpenFile(filepath.Join(dir, indexFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open block store index: %w", err)
+	}
+	s := &BlockStore{
+		dir:            dir,
+		maxSegmentSize: DefaultMaxSegmentSize,
+		index:          index,
+		byHash:         make(map[string]int),
+	}
+	if err := s.load(); err != nil {
+		index.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetMaxSegmentSize sets the size after which appends roll over to a new
+// segment file.
+func (s *BlockStore) SetMaxSegmentSize(size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxSegmentSize = size
+}
+
+func (s *BlockStore) segmentPath(n uint32) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%08d.seg", n))
+}
+
+func (s *BlockStore) load() error {
+	info, err := s.index.Stat()
+	if err != nil {
+		return err
+	}
+	// Drop a torn trailing index entry.
+	size := info.Size() - info.Size()%indexEntrySize
+	if size != info.Size() {
+		if err := s.index.Truncate(size); err != nil {
+			return fmt.Errorf("failed to truncate block store index: %w", err)
+		}
+	}
+	data := make([]byte, size)
+	if _, err := s.index.ReadAt(data, 0); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read block store index: %w", err)
+	}
+	for off := 0; off < len(data); off += indexEntrySize {
+		var e indexEntry
+		e.Segment = binary.BigEndian.Uint32(data[off:])
+		e.Offset = binary.BigEndian.Uint64(data[off+4:])
+		e.Length = binary.BigEndian.Uint32(data[off+12:])
+		copy(e.Hash[:], data[off+16:off+indexEntrySize])
+		s.byHash[string(e.Hash[:])] = len(s.entries)
+		s.entries = append(s.entries, e)
+	}
+	if _, err := s.index.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+
+	var tailOffset int64
+	if n := len(s.entries); n > 0 {
+		last := s.entries[n-1]
+		s.segNum = last.Segment
+		tailOffset = int64(last.Offset) + recordHeaderSize + int64(last.Length)
+	}
+	s.segment, err = os.OpenFile(s.segmentPath(s.segNum), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open block store segment: %w", err)
+	}
+	if err := s.recoverTail(tailOffset); err != nil {
+		return err
+	}
+	// An append may have rolled over to a new segment before its index
+	// entry was written.
+	for {
+		next, err := os.OpenFile(s.segmentPath(s.segNum+1), os.O_RDWR, 0o644)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open block store segment: %w", err)
+		}
+		s.segment.Close()
+		s.segment = next
+		s.segNum++
+		if err := s.recoverTail(0); err != nil {
+			return err
+		}
+	}
+}
+
+// recoverTail re-indexes complete records written after the last index entry
+// and truncates whatever follows them.
+func (s *BlockStore) recoverTail(offset int64) error {
+	info, err := s.segment.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < offset {
+		return fmt.Errorf("block store segment %d is shorter than its index", s.segNum)
+	}
+	for {
+		payload, err := s.readRecord(s.segment, offset)
+		if err != nil {
+			break
+		}
+		block, err := decodeStoredBlock(payload)
+		if err != nil {
+			break
+		}
+		hash, err := hashKey(block.Hash)
+		if err != nil {
+			break
+		}
+		if err := s.writeIndexEntry(indexEntry{Segment: s.segNum, Offset: uint64(offset), Length: uint32(len(payload)), Hash: hash}); err != nil {
+			return err
+		}
+		offset += recordHeaderSize + int64(len(payload))
+	}
+	if offset != info.Size() {
+		if err := s.segment.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to truncate block store segment: %w", err)
+		}
+	}
+	s.segSize = offset
+	_, err = s.segment.Seek(offset, io.SeekStart)
+	return err
+}
+
+func (s *BlockStore) readRecord(f *os.File, offset int64) ([]byte, error) {
+	var header [recordHeaderSize]byte
+	if _, err := f.ReadAt(header[:], offset); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length > maxRecordSize {
+		return nil, fmt.Errorf("block store record at offset %d is corrupt", offset)
+	}
+	payload := make([]byte, length)
+	if _, err := f.ReadAt(payload, offset+recordHeaderSize); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, fmt.Errorf("block store record at offset %d is corrupt", offset)
+	}
+	return payload, nil
+}
+
+func (s *BlockStore) writeIndexEntry(e indexEntry) error {
+	var buf [indexEntrySize]byte
+	binary.BigEndian.PutUint32(buf[0:], e.Segment)
+	binary.BigEndian.PutUint64(buf[4:], e.Offset)
+	binary.BigEndian.PutUint32(buf[12:], e.Length)
+	copy(buf[16:], e.Hash[:])
+	if _, err := s.index.Write(buf[:]); err != nil {
+		return fmt.Errorf("failed to write block store index: %w", err)
+	}
+	if err := s.index.Sync(); err != nil {
+		return fmt.Errorf("failed to sync block store index: %w", err)
+	}
+	s.byHash[string(e.Hash[:])] = len(s.entries)
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+// hashKey converts a hex block hash, with or without a 0x prefix, into the
+// fixed-size key kept in the index.
+func hashKey(hash string) ([32]byte, error) {
+	var key [32]byte
+	raw, err := hex.DecodeString(strings.TrimPrefix(hash, "0x"))
+	if err != nil || len(raw) != len(key) {
+		return key, fmt.Errorf("invalid block hash %q", hash)
+	}
+	copy(key[:], raw)
+	return key, nil
+}
+
+func encodeStoredBlock(block Block) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(block); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeStoredBlock(data []byte) (Block, error) {
+	var block Block
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&block)
+	return block, err
+}
+
+// Append durably adds block to the end of the store.
+func (s *BlockStore) Append(block Block) error {
+	hash, err := hashKey(block.Hash)
+	if err != nil {
+		return err
+	}
+	payload, err := encodeStoredBlock(block)
+	if err != nil {
+		return fmt.Errorf("failed to encode block %d: %w", block.Index, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byHash[string(hash[:])]; ok {
+		return fmt.Errorf("block %s is already stored", block.Hash)
+	}
+	if s.segSize > 0 && s.segSize+recordHeaderSize+int64(len(payload)) > s.maxSegmentSize {
+		if err := s.rollSegment(); err != nil {
+			return err
+		}
+	}
+
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+	if _, err := s.segment.Write(record); err != nil {
+		return fmt.Errorf("failed to write block %d: %w", block.Index, err)
+	}
+	if err := s.segment.Sync(); err != nil {
+		return fmt.Errorf("failed to sync block %d: %w", block.Index, err)
+	}
+	offset := s.segSize
+	s.segSize += int64(len(record))
+	return s.writeIndexEntry(indexEntry{Segment: s.segNum, Offset: uint64(offset), Length: uint32(len(payload)), Hash: hash})
+}
+
+func (s *BlockStore) rollSegment() error {
+	if err := s.segment.Close(); err != nil {
+		return err
+	}
+	segment, err := os.OpenFile(s.segmentPath(s.segNum+1), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create block store segment: %w", err)
+	}
+	s.segNum++
+	s.segment = segment
+	s.segSize = 0
+	return nil
+}
+
+// Len returns the number of stored blocks.
+func (s *BlockStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.entries)
+}
+
+func (s *BlockStore) read(e indexEntry) (Block, error) {
+	f := s.segment
+	if e.Segment != s.segNum {
+		var err error
+		f, err = os.Open(s.segmentPath(e.Segment))
+		if err != nil {
+			return Block{}, fmt.Errorf("failed to open block store segment: %w", err)
+		}
+		defer f.Close()
+	}
+	payload, err := s.readRecord(f, int64(e.Offset))
+	if err != nil {
+		return Block{}, err
+	}
+	return decodeStoredBlock(payload)
+}
+
+// GetBlockByIndex returns the block at position index, counting appends from
+// zero.
+func (s *BlockStore) GetBlockByIndex(index int) (Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if index < 0 || index >= len(s.entries) {
+		return Block{}, fmt.Errorf("block index %d: %w", index, ErrBlockNotFound)
+	}
+	return s.read(s.entries[index])
+}
+
+// GetBlockByHash returns the block with the given hex hash.
+func (s *BlockStore) GetBlockByHash(hash string) (Block, error) {
+	key, err := hashKey(hash)
+	if err != nil {
+		return Block{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, ok := s.byHash[string(key[:])]
+	if !ok {
+		return Block{}, fmt.Errorf("block %s: %w", hash, ErrBlockNotFound)
+	}
+	return s.read(s.entries[i])
+}
+
+// Range calls fn for every block in [start, end) in order and stops at the
+// first error.
+func (s *BlockStore) Range(start, end int, fn func(Block) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if start < 0 || end > len(s.entries) || start > end {
+		return fmt.Errorf("block range [%d, %d) out of bounds for %d blocks", start, end, len(s.entries))
+	}
+	for i := start; i < end; i++ {
+		block, err := s.read(s.entries[i])
+		if err != nil {
+			return err
+		}
+		if err := fn(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Blockchain loads every stored block into an in-memory Blockchain.
+func (s *BlockStore) Blockchain() (*Blockchain, error) {
+	bc := &Blockchain{Chain: make([]Block, 0, s.Len())}
+	err := s.Range(0, s.Len(), func(block Block) error {
+		bc.Chain = append(bc.Chain, block)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// Save appends the blocks of bc beyond the ones the store already holds.
+func (bc *Blockchain) Save(s *BlockStore) error {
+	for _, block := range bc.Chain[min(s.Len(), len(bc.Chain)):] {
+		if err := s.Append(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BlockStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.segment.Close(), s.index.Close())
+}
@@ -0,0 +1,178 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/cbergoon/merkletree"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// WorkloadConfig describes a synthetic transaction workload. Senders are
+// drawn from a pool of accounts with Zipfian popularity (a few hot accounts
+// send most transactions), receivers uniformly from the same pool.
+type WorkloadConfig struct {
+	Accounts int   `json:"accounts"`
+	Seed     int64 `json:"seed"`
+
+	// ZipfS (> 1) and ZipfV (>= 1) are the parameters of rand.NewZipf. A
+	// larger ZipfS concentrates activity on fewer senders.
+	ZipfS float64 `json:"zipfS"`
+	ZipfV float64 `json:"zipfV"`
+
+	MinTransactionsPerBlock int `json:"minTransactionsPerBlock"`
+	MaxTransactionsPerBlock int `json:"maxTransactionsPerBlock"`
+	MinPayloadSize          int `json:"minPayloadSize"`
+	MaxPayloadSize          int `json:"maxPayloadSize"`
+	MaxAmount               int `json:"maxAmount"`
+
+	// StartTime is the Unix time of the first block; later blocks follow
+	// every BlockInterval seconds on average. Zero selects
+	// DefaultStartTime and DefaultBlockInterval.
+	StartTime     int64 `json:"startTime,omitempty"`
+	BlockInterval int   `json:"blockInterval,omitempty"`
+}
+
+const (
+	// DefaultStartTime is 2024-01-01T00:00:00Z.
+	DefaultStartTime     = 1704067200
+	DefaultBlockInterval = 12
+)
+
+// DefaultWorkloadConfig returns a workload of 1000 accounts with a moderately
+// skewed sender distribution and 50-150 transactions per block.
+func DefaultWorkloadConfig(seed int64) WorkloadConfig {
+	return WorkloadConfig{
+		Accounts:                1000,
+		Seed:                    seed,
+		ZipfS:                   1.2,
+		ZipfV:                   1,
+		MinTransactionsPerBlock: 50,
+		MaxTransactionsPerBlock: 150,
+		MinPayloadSize:          0,
+		MaxPayloadSize:          256,
+		MaxAmount:               100,
+	}
+}
+
+func (c WorkloadConfig) validate() error {
+	switch {
+	case c.Accounts < 2:
+		return fmt.Errorf("workload needs at least 2 accounts, got %d", c.Accounts)
+	case c.ZipfS <= 1 || c.ZipfV < 1:
+		return fmt.Errorf("invalid zipf parameters s=%v v=%v: need s > 1 and v >= 1", c.ZipfS, c.ZipfV)
+	case c.MinTransactionsPerBlock < 1 || c.MaxTransactionsPerBlock < c.MinTransactionsPerBlock:
+		return fmt.Errorf("invalid transactions per block range [%d, %d]", c.MinTransactionsPerBlock, c.MaxTransactionsPerBlock)
+	case c.MinPayloadSize < 0 || c.MaxPayloadSize < c.MinPayloadSize:
+		return fmt.Errorf("invalid payload size range [%d, %d]", c.MinPayloadSize, c.MaxPayloadSize)
+	case c.MaxAmount < 1:
+		return fmt.Errorf("max amount must be positive, got %d", c.MaxAmount)
+	case c.BlockInterval < 0:
+		return fmt.Errorf("block interval must not be negative, got %d", c.BlockInterval)
+	}
+	return nil
+}
+
+// WorkloadGenerator produces transactions for consecutive blocks. Two
+// generators built from the same config produce the same transactions and
+// block timestamps, and so chains with the same hashes.
+type WorkloadGenerator struct {
+	config   WorkloadConfig
+	random   *rand.Rand
+	zipf     *rand.Zipf
+	accounts []string
+
+	// clock is the timestamp of the next block.
+	clock    time.Time
+	interval int
+}
+
+func NewWorkloadGenerator(config WorkloadConfig) (*WorkloadGenerator, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	random := rand.New(rand.NewSource(config.Seed))
+	start, interval := config.StartTime, config.BlockInterval
+	if start == 0 {
+		start = DefaultStartTime
+	}
+	if interval == 0 {
+		interval = DefaultBlockInterval
+	}
+	return &WorkloadGenerator{
+		config:   config,
+		random:   random,
+		zipf:     rand.NewZipf(random, config.ZipfS, config.ZipfV, uint64(config.Accounts-1)),
+		accounts: deriveAccounts(config.Seed, config.Accounts),
+		clock:    time.Unix(start, 0).UTC(),
+		interval: interval,
+	}, nil
+}
+
+// deriveAccounts derives n addresses from the seed. Unlike
+// GenerateEthereumAddress they are reproducible, which keeps runs with the
+// same seed identical.
+func deriveAccounts(seed int64, n int) []string {
+	accounts := make([]string, n)
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(seed))
+	for i := range accounts {
+		binary.BigEndian.PutUint64(buf[8:], uint64(i))
+		hash := crypto.Keccak256(buf[:])
+		accounts[i] = "0x" + hex.EncodeToString(hash[len(hash)-20:])
+	}
+	return accounts
+}
+
+func (g *WorkloadGenerator) between(lo, hi int) int {
+	return lo + g.random.Intn(hi-lo+1)
+}
+
+// NextBlockTransactions returns the transactions of the next block.
+func (g *WorkloadGenerator) NextBlockTransactions() []merkletree.Content {
+	n := g.between(g.config.MinTransactionsPerBlock, g.config.MaxTransactionsPerBlock)
+	transactions := make([]merkletree.Content, n)
+	for i := range transactions {
+		sender := int(g.zipf.Uint64())
+		receiver := g.random.Intn(len(g.accounts) - 1)
+		if receiver >= sender {
+			receiver++
+		}
+		payload := make([]byte, g.between(g.config.MinPayloadSize, g.config.MaxPayloadSize))
+		g.random.Read(payload)
+		transactions[i] = Transaction{
+			Sender:   g.accounts[sender],
+			Receiver: g.accounts[receiver],
+			Amount:   float64(g.random.Intn(g.config.MaxAmount)),
+			Payload:  payload,
+		}
+	}
+	return transactions
+}
+
+// NextBlockTime returns the timestamp of the next block and advances the
+// clock by a random gap of up to twice the block interval.
+func (g *WorkloadGenerator) NextBlockTime() time.Time {
+	t := g.clock
+	g.clock = g.clock.Add(time.Duration(g.between(1, 2*g.interval-1)) * time.Second)
+	return t
+}
+
+// GenerateBlockchain builds a chain of numberOfBlocks blocks, each with its
+// own transactions.
+func (g *WorkloadGenerator) GenerateBlockchain(numberOfBlocks int) (*Blockchain, error) {
+	bc := &Blockchain{}
+	for i := 0; i < numberOfBlocks; i++ {
+		block, err := CreateBlockAt(i, g.NextBlockTransactions(), g.NextBlockTime())
+		if err != nil {
+			return nil, err
+		}
+		if err := bc.AddBlock(block); err != nil {
+			return nil, err
+		}
+	}
+	return bc, nil
+}
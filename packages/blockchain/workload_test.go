@@ -0,0 +1,53 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWorkloadGeneratorIsReproducible(t *testing.T) {
+	generate := func(seed int64) *Blockchain {
+		g, err := NewWorkloadGenerator(DefaultWorkloadConfig(seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bc, err := g.GenerateBlockchain(5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bc
+	}
+	a, b := generate(1), generate(1)
+	for i := range a.Chain {
+		if a.Chain[i].Hash != b.Chain[i].Hash {
+			t.Fatalf("block %d: hashes %s and %s differ for the same seed", i, a.Chain[i].Hash, b.Chain[i].Hash)
+		}
+		if i > 0 {
+			if bytes.Equal(a.Chain[i].MerkleRoot, a.Chain[i-1].MerkleRoot) {
+				t.Fatalf("blocks %d and %d share a Merkle root", i-1, i)
+			}
+			if a.Chain[i].Timestamp <= a.Chain[i-1].Timestamp {
+				t.Fatalf("block %d is not later than block %d", i, i-1)
+			}
+		}
+	}
+	if err := ValidateChain(a, nil); err != nil {
+		t.Fatal(err)
+	}
+	if generate(2).Chain[0].Hash == a.Chain[0].Hash {
+		t.Fatal("different seeds produced the same chain")
+	}
+}
+
+func TestWorkloadConfigValidation(t *testing.T) {
+	bad := DefaultWorkloadConfig(1)
+	bad.ZipfS = 1
+	if _, err := NewWorkloadGenerator(bad); err == nil {
+		t.Fatal("zipf s = 1 was accepted")
+	}
+	bad = DefaultWorkloadConfig(1)
+	bad.MaxTransactionsPerBlock = bad.MinTransactionsPerBlock - 1
+	if _, err := NewWorkloadGenerator(bad); err == nil {
+		t.Fatal("empty transaction range was accepted")
+	}
+}
@@ -0,0 +1,168 @@
+// Package commitment puts the ways the droplets of a run can be committed
+// to behind one interface, so that setup publishes and the decoder verifies
+// droplets the same way under KZG and under a Merkle tree.
+package commitment
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kzg "github.com/xm0onh/thesis/packages/kzg"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+	rs2d "github.com/xm0onh/thesis/packages/rs2d"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+// DropletCommitment is a vector commitment to the droplets of a run,
+// indexed by BlockCode.
+type DropletCommitment interface {
+	// Scheme is the utils.Commitment constant the setup record carries.
+	Scheme() string
+
+	// Commit commits to droplets, which must hold every BlockCode of the
+	// run exactly once, and returns the digest to publish.
+	Commit(droplets []lubyTransform.LTBlock) ([]byte, error)
+
+	// Open returns the proof of the droplet with BlockCode index. Commit
+	// must have been called first.
+	Open(index int) ([]byte, error)
+
+	// Verify checks proof of droplet against a published digest. Failures
+	// wrap utils.ErrProofInvalid.
+	Verify(digest []byte, droplet lubyTransform.LTBlock, proof []byte) error
+
+	// VerifyDroplets returns the droplets whose proofs verify against
+	// digest and the block codes of the ones rejected. Schemes that support
+	// range proofs check the droplets they cover with them.
+	VerifyDroplets(digest []byte, droplets []utils.StoredDroplet, ranges []utils.RangeProof) ([]lubyTransform.LTBlock, []int64)
+
+	// Setup returns the scheme's parameters for the setup record, without
+	// the digest and the proofs.
+	Setup() (*utils.CommitmentSetup, error)
+}
+
+// Blobs the material of a droplet commitment is uploaded to.
+const (
+	DropletProofsKey = "droplet-proofs.dat"
+	SRSKey           = "kzg-srs.dat"
+	G2PowersKey      = "kzg-g2-powers.dat"
+)
+
+// Publish commits to the droplets of a run, uploads one proof per droplet
+// and the scheme's parameters to blobs, and returns the material for the
+// setup record.
+func Publish(ctx context.Context, blobs utils.BlobStore, c DropletCommitment, encodedBlockIDs int, droplets []lubyTransform.LTBlock) (*utils.CommitmentSetup, error) {
+	startTime := time.Now()
+	digest, err := c.Commit(droplets)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Time to commit to droplets: ", time.Since(startTime))
+
+	startTime = time.Now()
+	proofs := make([][]byte, encodedBlockIDs)
+	proofBytes := 0
+	for i := range proofs {
+		if proofs[i], err = c.Open(i); err != nil {
+			return nil, fmt.Errorf("failed to open droplet %d: %w", i, err)
+		}
+		proofBytes += len(proofs[i])
+	}
+	fmt.Println("Time to open droplets: ", time.Since(startTime))
+	if encodedBlockIDs > 0 {
+		fmt.Printf("%s proofs: %d bytes per droplet, digest of %d bytes.\n", c.Scheme(), proofBytes/encodedBlockIDs, len(digest))
+	}
+
+	proofsBlob, err := utils.EncodeDropletProofs(proofs)
+	if err != nil {
+		return nil, err
+	}
+	if err := blobs.Put(ctx, DropletProofsKey, proofsBlob); err != nil {
+		return nil, fmt.Errorf("failed to upload droplet proofs: %w", err)
+	}
+	setup, err := c.Setup()
+	if err != nil {
+		return nil, err
+	}
+	setup.Digest = digest
+	setup.ProofsKey = DropletProofsKey
+	if len(setup.SRS) > 0 {
+		if setup.SRSBlob, err = utils.PutBlob(ctx, blobs, SRSKey, setup.SRS); err != nil {
+			return nil, err
+		}
+	}
+	if len(setup.G2Powers) > 0 {
+		if setup.G2PowersBlob, err = utils.PutBlob(ctx, blobs, G2PowersKey, setup.G2Powers); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Printf("%s parameters: %d bytes of SRS and %d bytes of G2 powers in the blob store.\n", c.Scheme(), len(setup.SRS), len(setup.G2Powers))
+	return setup, nil
+}
+
+// New returns the commitment selected by scheme for the droplets of a run.
+// The KZG schemes load their SRS from config, with a multi-opening key for
+// ranges of maxRange droplets; see LoadKZG.
+func New(scheme string, config kzg.SRSConfig, encodedBlockIDs, maxRange int, droplets []lubyTransform.LTBlock) (DropletCommitment, error) {
+	switch scheme {
+	case utils.CommitmentKZG, utils.CommitmentKZGData:
+		return LoadKZG(config, scheme, encodedBlockIDs, maxRange, droplets)
+	case utils.CommitmentMerkle:
+		return NewMerkle(encodedBlockIDs), nil
+	default:
+		return nil, fmt.Errorf("unknown droplet commitment %q", scheme)
+	}
+}
+
+// ForSetup returns the commitment a setup record was published with, ready
+// to verify droplets against setup.Digest. The KZG schemes check the
+// published SRS against the one config selects.
+func ForSetup(config kzg.SRSConfig, setup *utils.CommitmentSetup, encodedBlockIDs int) (DropletCommitment, error) {
+	switch setup.Scheme {
+	case utils.CommitmentKZG, utils.CommitmentKZGData:
+		return KZGForSetup(config, setup, encodedBlockIDs)
+	case utils.CommitmentMerkle:
+		return NewMerkle(encodedBlockIDs), nil
+	default:
+		return nil, fmt.Errorf("unknown droplet commitment %q: %w", setup.Scheme, utils.ErrInvalidSetup)
+	}
+}
+
+// Committer returns the commit step of utils.RunSetup. Two-dimensional runs
+// commit to the rows and columns of their extended matrix; the others
+// commit to their droplets with the scheme the start signal names, and
+// responders attach the published proofs to the droplets they store or
+// prove their whole range against a KZG multi-opening key.
+func Committer(config kzg.SRSConfig) utils.CommitFunc {
+	return func(ctx context.Context, blobs utils.BlobStore, event utils.StartSignal, param utils.SetupParameters, record *utils.SetupRecord) error {
+		coding, err := utils.CodingByName(event.Coding)
+		if err != nil {
+			return err
+		}
+		if coding == utils.CodingRS2D {
+			if event.Commitment != "" || param.Encryption != utils.EncryptionNone {
+				return fmt.Errorf("%s coding commits to its own cells and does not encrypt them", coding)
+			}
+			record.Matrix, err = rs2d.Publish(ctx, blobs, config, param.Message)
+			if err != nil {
+				return fmt.Errorf("failed to commit to the matrix: %w", err)
+			}
+			return nil
+		}
+		scheme, err := utils.CommitmentScheme(event.Commitment)
+		if err != nil {
+			return err
+		}
+		droplets := utils.GenerateDroplet(param)
+		c, err := New(scheme, config, param.EncodedBlockIDs, event.ResponderRange, droplets)
+		if err != nil {
+			return err
+		}
+		record.Commitment, err = Publish(ctx, blobs, c, param.EncodedBlockIDs, droplets)
+		if err != nil {
+			return fmt.Errorf("failed to commit to droplets: %w", err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,212 @@
+package commitment
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+
+	kzg "github.com/xm0onh/thesis/packages/kzg"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+var insecureConfig = kzg.SRSConfig{Insecure: true}
+
+var schemes = []string{utils.CommitmentKZG, utils.CommitmentKZGData, utils.CommitmentMerkle}
+
+func testDroplets(n, size int) []lubyTransform.LTBlock {
+	random := rand.New(rand.NewSource(int64(n)))
+	droplets := make([]lubyTransform.LTBlock, n)
+	for i := range droplets {
+		droplets[i].BlockCode = int64(i)
+		droplets[i].Data = make([]byte, size)
+		random.Read(droplets[i].Data)
+	}
+	return droplets
+}
+
+// publish commits to droplets under scheme and returns the commitment a
+// verifier rebuilds from the setup record with the published proofs.
+func publish(t *testing.T, scheme string, droplets []lubyTransform.LTBlock) (DropletCommitment, *utils.CommitmentSetup, []utils.StoredDroplet) {
+	t.Helper()
+	ctx := context.Background()
+	c, err := New(scheme, insecureConfig, len(droplets), 0, droplets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := utils.NewMemoryBlobStore()
+	setup, err := Publish(ctx, blobs, c, len(droplets), droplets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if setup.Scheme != scheme {
+		t.Fatalf("setup records scheme %q, want %q", setup.Scheme, scheme)
+	}
+	proofs, err := utils.SetupRecord{EncodedBlockIDs: len(droplets), Commitment: setup}.LoadDropletProofs(ctx, blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := make([]utils.StoredDroplet, len(droplets))
+	for i := range droplets {
+		stored[i] = utils.StoredDroplet{LTBlock: droplets[i], Proof: proofs[i]}
+	}
+	verifier, err := ForSetup(insecureConfig, setup, len(droplets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifier, setup, stored
+}
+
+func TestCommitments(t *testing.T) {
+	for _, scheme := range schemes {
+		t.Run(scheme, func(t *testing.T) {
+			droplets := testDroplets(13, 90)
+			c, setup, stored := publish(t, scheme, droplets)
+			for _, droplet := range stored {
+				if err := c.Verify(setup.Digest, droplet.LTBlock, droplet.Proof); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			altered := stored[2].LTBlock
+			altered.Data = append([]byte(nil), altered.Data...)
+			altered.Data[0] ^= 1
+			moved := stored[5].LTBlock
+			moved.BlockCode = 6
+			for name, bad := range map[string]utils.StoredDroplet{
+				"altered data":     {LTBlock: altered, Proof: stored[2].Proof},
+				"another proof":    {LTBlock: stored[4].LTBlock, Proof: stored[3].Proof},
+				"moved droplet":    {LTBlock: moved, Proof: stored[5].Proof},
+				"missing proof":    {LTBlock: stored[7].LTBlock},
+				"truncated proof":  {LTBlock: stored[8].LTBlock, Proof: stored[8].Proof[:len(stored[8].Proof)-1]},
+				"unknown droplet":  {LTBlock: lubyTransform.LTBlock{BlockCode: 99, Data: stored[0].Data}, Proof: stored[0].Proof},
+				"negative droplet": {LTBlock: lubyTransform.LTBlock{BlockCode: -1, Data: stored[0].Data}, Proof: stored[0].Proof},
+			} {
+				if err := c.Verify(setup.Digest, bad.LTBlock, bad.Proof); !errors.Is(err, utils.ErrProofInvalid) {
+					t.Errorf("%s: got %v, want ErrProofInvalid", name, err)
+				}
+			}
+			if err := c.Verify(setup.Digest[:len(setup.Digest)-1], stored[0].LTBlock, stored[0].Proof); err == nil {
+				t.Error("verified against a truncated digest")
+			}
+		})
+	}
+}
+
+func TestVerifyDroplets(t *testing.T) {
+	for _, scheme := range schemes {
+		t.Run(scheme, func(t *testing.T) {
+			droplets := testDroplets(16, 64)
+			c, setup, stored := publish(t, scheme, droplets)
+			stored[1].Data = append([]byte(nil), stored[1].Data...)
+			stored[1].Data[3] ^= 0x40
+			stored[10].Proof = stored[11].Proof
+
+			verified, rejected := c.VerifyDroplets(setup.Digest, stored, nil)
+			if len(verified) != len(droplets)-2 {
+				t.Fatalf("%d droplets verified, want %d", len(verified), len(droplets)-2)
+			}
+			sort.Slice(rejected, func(i, j int) bool { return rejected[i] < rejected[j] })
+			if len(rejected) != 2 || rejected[0] != 1 || rejected[1] != 10 {
+				t.Fatalf("rejected %v, want droplets 1 and 10", rejected)
+			}
+		})
+	}
+}
+
+func TestResponderRangeSizesTheKey(t *testing.T) {
+	droplets := testDroplets(128, 64)
+	c, err := New(utils.CommitmentKZG, insecureConfig, len(droplets), 100, droplets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup, err := Publish(context.Background(), utils.NewMemoryBlobStore(), c, len(droplets), droplets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := kzg.MaxRangeForSetup(setup); got != 100 {
+		t.Fatalf("key covers ranges of %d droplets, want 100", got)
+	}
+	prover, err := kzg.NewDropletProverForSetup(setup, len(droplets), droplets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := prover.OpenRange(10, 110)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := make([]utils.StoredDroplet, 100)
+	for i := range stored {
+		stored[i].LTBlock = droplets[10+i]
+	}
+	verifier, err := ForSetup(insecureConfig, setup, len(droplets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, rejected := verifier.VerifyDroplets(setup.Digest, stored, []utils.RangeProof{{Start: 10, End: 110, Proof: proof}})
+	if len(verified) != 100 || len(rejected) != 0 {
+		t.Fatalf("one proof for 100 droplets: %d verified, %d rejected", len(verified), len(rejected))
+	}
+}
+
+func TestDataCommitmentNeedsMultiOpeningKey(t *testing.T) {
+	droplets := testDroplets(16, 200)
+	layout, err := kzg.NewDropletLayout(utils.CommitmentKZGData, len(droplets), kzg.MaxDropletSize(droplets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srs, err := kzg.InsecureSetup(layout.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewKZG(srs, nil, layout, len(droplets)); err == nil {
+		t.Fatalf("droplets of %d chunks committed without a multi-opening key", layout.Chunks())
+	}
+}
+
+func TestMerkleTreeSizes(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 8, 9} {
+		droplets := testDroplets(n, 20)
+		c := NewMerkle(n)
+		root, err := c.Commit(droplets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range droplets {
+			proof, err := c.Open(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(proof) != NewMerkle(n).depth()*sha256.Size {
+				t.Fatalf("%d droplets: proof of %d bytes", n, len(proof))
+			}
+			if err := NewMerkle(n).Verify(root, droplets[i], proof); err != nil {
+				t.Fatalf("%d droplets: %v", n, err)
+			}
+		}
+	}
+
+	c := NewMerkle(4)
+	if _, err := c.Open(0); err == nil {
+		t.Fatal("opened before committing")
+	}
+	droplets := testDroplets(4, 20)
+	if _, err := c.Commit(droplets[:3]); err == nil {
+		t.Fatal("committed to too few droplets")
+	}
+	if _, err := c.Commit(append(droplets[:3:3], droplets[0])); err == nil {
+		t.Fatal("committed to a repeated droplet")
+	}
+}
+
+func TestForSetupRejectsUnknownScheme(t *testing.T) {
+	if _, err := ForSetup(insecureConfig, &utils.CommitmentSetup{Scheme: "sha1"}, 4); !errors.Is(err, utils.ErrInvalidSetup) {
+		t.Fatalf("unknown scheme: %v", err)
+	}
+	if _, err := New("sha1", insecureConfig, 4, 0, testDroplets(4, 8)); err == nil {
+		t.Fatal("created an unknown scheme")
+	}
+}
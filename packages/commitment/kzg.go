@@ -0,0 +1,142 @@
+package commitment
+
+import (
+	"errors"
+	"fmt"
+
+	kzg "github.com/xm0onh/thesis/packages/kzg"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+// KZG commits to the droplets with one KZG polynomial commitment laid out
+// by a kzg.DropletLayout. Proofs are a single compressed G1 point.
+type KZG struct {
+	srs             *kzg.SRS
+	multi           *kzg.MultiVerifyingKey
+	layout          *kzg.DropletLayout
+	encodedBlockIDs int
+
+	// prover is set by Commit.
+	prover *kzg.DropletProver
+}
+
+// NewKZG commits with srs under layout. multi is published with the
+// commitment unless it is nil; utils.CommitmentKZGData needs it to verify
+// the chunks of a droplet together.
+func NewKZG(srs *kzg.SRS, multi *kzg.MultiVerifyingKey, layout *kzg.DropletLayout, encodedBlockIDs int) (*KZG, error) {
+	if layout.Chunks() > 1 && (multi == nil || multi.MaxPoints() < layout.Chunks()) {
+		return nil, fmt.Errorf("droplets of %d chunks need a multi-opening key for %d points", layout.Chunks(), layout.Chunks())
+	}
+	return &KZG{srs: srs, multi: multi, layout: layout, encodedBlockIDs: encodedBlockIDs}, nil
+}
+
+// LoadKZG loads an SRS and a multi-opening key from config, sized for
+// droplets under scheme, one of the KZG schemes. The key covers ranges of
+// maxRange droplets, or kzg.DefaultMaxRangePoints points if maxRange is 0.
+// Without enough powers in G2 responders fall back to a proof per droplet;
+// data commitments need them to prove the chunks of a droplet together.
+func LoadKZG(config kzg.SRSConfig, scheme string, encodedBlockIDs, maxRange int, droplets []lubyTransform.LTBlock) (*KZG, error) {
+	layout, err := kzg.NewDropletLayout(scheme, encodedBlockIDs, kzg.MaxDropletSize(droplets))
+	if err != nil {
+		return nil, err
+	}
+	srs, err := kzg.LoadSRS(config, layout.Size())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SRS: %w", err)
+	}
+	points := max(kzg.DefaultMaxRangePoints, layout.Chunks())
+	if maxRange > 0 {
+		points = maxRange * layout.Chunks()
+	}
+	multi, err := kzg.LoadMultiOpeningKey(config, srs, points)
+	if err != nil {
+		if layout.Chunks() > 1 {
+			return nil, fmt.Errorf("droplets of %d chunks need a multi-opening key: %w", layout.Chunks(), err)
+		}
+		fmt.Printf("No multi-opening key, responders will prove every droplet: %v\n", err)
+	} else if multi.MaxPoints() < points {
+		fmt.Printf("The SRS source has powers in G2 for ranges of %d droplets, responders will prove larger ranges in parts\n", multi.MaxPoints()/layout.Chunks())
+	}
+	return NewKZG(srs, multi, layout, encodedBlockIDs)
+}
+
+// KZGForSetup reads the parameters of a published KZG commitment and checks
+// its SRS against the source selected by config.
+func KZGForSetup(config kzg.SRSConfig, setup *utils.CommitmentSetup, encodedBlockIDs int) (*KZG, error) {
+	srs, multi, layout, err := kzg.ParametersForSetup(config, setup, encodedBlockIDs)
+	if err != nil {
+		return nil, err
+	}
+	return &KZG{srs: srs, multi: multi, layout: layout, encodedBlockIDs: encodedBlockIDs}, nil
+}
+
+func (c *KZG) Scheme() string {
+	return c.layout.Scheme
+}
+
+func (c *KZG) Commit(droplets []lubyTransform.LTBlock) ([]byte, error) {
+	prover, err := kzg.NewDropletProver(c.srs, c.layout, c.encodedBlockIDs, droplets)
+	if err != nil {
+		return nil, err
+	}
+	c.prover = prover
+	return kzg.MarshalDigest(prover.Digest), nil
+}
+
+func (c *KZG) Open(index int) ([]byte, error) {
+	if c.prover == nil {
+		return nil, errors.New("droplets are not committed")
+	}
+	return c.prover.OpenDroplet(int64(index))
+}
+
+func (c *KZG) verifier(digest []byte) (*kzg.DropletVerifier, error) {
+	d, err := kzg.UnmarshalDigest(digest)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, utils.ErrProofInvalid)
+	}
+	return kzg.NewDropletVerifier(d, c.srs.Vk, c.layout, c.multi), nil
+}
+
+func (c *KZG) Verify(digest []byte, droplet lubyTransform.LTBlock, proof []byte) error {
+	verifier, err := c.verifier(digest)
+	if err != nil {
+		return err
+	}
+	return verifier.Verify(droplet, proof)
+}
+
+// VerifyDroplets checks range proofs first and batches the remaining
+// single-point proofs; see kzg.DropletVerifier.VerifyDroplets.
+func (c *KZG) VerifyDroplets(digest []byte, droplets []utils.StoredDroplet, ranges []utils.RangeProof) ([]lubyTransform.LTBlock, []int64) {
+	verifier, err := c.verifier(digest)
+	if err != nil {
+		return rejectAll(droplets)
+	}
+	return verifier.VerifyDroplets(droplets, ranges)
+}
+
+func (c *KZG) Setup() (*utils.CommitmentSetup, error) {
+	serializedSRS, err := kzg.MarshalSRS(c.srs)
+	if err != nil {
+		return nil, err
+	}
+	setup := &utils.CommitmentSetup{
+		SRS:         serializedSRS,
+		Scheme:      c.layout.Scheme,
+		DropletSize: c.layout.DropletSize,
+	}
+	if c.multi != nil {
+		setup.G2Powers = kzg.MarshalG2Powers(c.multi.G2)
+	}
+	return setup, nil
+}
+
+func rejectAll(droplets []utils.StoredDroplet) ([]lubyTransform.LTBlock, []int64) {
+	rejected := make([]int64, len(droplets))
+	for i, droplet := range droplets {
+		rejected[i] = droplet.BlockCode
+	}
+	return nil, rejected
+}
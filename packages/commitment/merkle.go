@@ -0,0 +1,144 @@
+package commitment
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/bits"
+
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+// Merkle commits to the droplets with a binary SHA-256 Merkle tree whose
+// leaf i is the hash of the droplet with BlockCode i. The leaves are padded
+// with zero hashes to a power of two. Leaves and inner nodes are hashed
+// with different prefixes, so an inner node cannot pass for a droplet. A
+// proof is the sibling hashes from the leaf up to the root.
+type Merkle struct {
+	encodedBlockIDs int
+
+	// levels are set by Commit, leaves first and the root last.
+	levels [][][sha256.Size]byte
+}
+
+func NewMerkle(encodedBlockIDs int) *Merkle {
+	return &Merkle{encodedBlockIDs: encodedBlockIDs}
+}
+
+func merkleLeaf(data []byte) [sha256.Size]byte {
+	return sha256.Sum256(append([]byte{0}, data...))
+}
+
+func merkleNode(left, right [sha256.Size]byte) [sha256.Size]byte {
+	var buf [1 + 2*sha256.Size]byte
+	buf[0] = 1
+	copy(buf[1:], left[:])
+	copy(buf[1+sha256.Size:], right[:])
+	return sha256.Sum256(buf[:])
+}
+
+// depth is the number of levels above the leaves.
+func (c *Merkle) depth() int {
+	if c.encodedBlockIDs <= 1 {
+		return 0
+	}
+	return bits.Len(uint(c.encodedBlockIDs - 1))
+}
+
+func (c *Merkle) Scheme() string {
+	return utils.CommitmentMerkle
+}
+
+func (c *Merkle) Commit(droplets []lubyTransform.LTBlock) ([]byte, error) {
+	if c.encodedBlockIDs <= 0 {
+		return nil, errors.New("no droplets to commit to")
+	}
+	leaves := make([][sha256.Size]byte, 1<<c.depth())
+	seen := make([]bool, c.encodedBlockIDs)
+	for _, droplet := range droplets {
+		if droplet.BlockCode < 0 || droplet.BlockCode >= int64(c.encodedBlockIDs) || seen[droplet.BlockCode] {
+			return nil, fmt.Errorf("unexpected droplet with block code %d", droplet.BlockCode)
+		}
+		seen[droplet.BlockCode] = true
+		leaves[droplet.BlockCode] = merkleLeaf(droplet.Data)
+	}
+	if len(droplets) != c.encodedBlockIDs {
+		return nil, fmt.Errorf("%d droplets for %d block codes", len(droplets), c.encodedBlockIDs)
+	}
+	levels := [][][sha256.Size]byte{leaves}
+	for level := leaves; len(level) > 1; {
+		next := make([][sha256.Size]byte, len(level)/2)
+		for i := range next {
+			next[i] = merkleNode(level[2*i], level[2*i+1])
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	c.levels = levels
+	root := levels[len(levels)-1][0]
+	return root[:], nil
+}
+
+func (c *Merkle) Open(index int) ([]byte, error) {
+	if c.levels == nil {
+		return nil, errors.New("droplets are not committed")
+	}
+	if index < 0 || index >= c.encodedBlockIDs {
+		return nil, fmt.Errorf("block code %d outside %d droplets", index, c.encodedBlockIDs)
+	}
+	proof := make([]byte, 0, c.depth()*sha256.Size)
+	for _, level := range c.levels[:len(c.levels)-1] {
+		sibling := level[index^1]
+		proof = append(proof, sibling[:]...)
+		index /= 2
+	}
+	return proof, nil
+}
+
+func (c *Merkle) Verify(digest []byte, droplet lubyTransform.LTBlock, proof []byte) error {
+	if len(digest) != sha256.Size {
+		return fmt.Errorf("Merkle root of %d bytes: %w", len(digest), utils.ErrProofInvalid)
+	}
+	if droplet.BlockCode < 0 || droplet.BlockCode >= int64(c.encodedBlockIDs) {
+		return fmt.Errorf("block code %d outside %d droplets: %w", droplet.BlockCode, c.encodedBlockIDs, utils.ErrProofInvalid)
+	}
+	if len(proof) != c.depth()*sha256.Size {
+		return fmt.Errorf("droplet %d: Merkle proof of %d bytes, want %d: %w", droplet.BlockCode, len(proof), c.depth()*sha256.Size, utils.ErrProofInvalid)
+	}
+	node := merkleLeaf(droplet.Data)
+	index := droplet.BlockCode
+	for i := 0; i < len(proof); i += sha256.Size {
+		var sibling [sha256.Size]byte
+		copy(sibling[:], proof[i:])
+		if index%2 == 0 {
+			node = merkleNode(node, sibling)
+		} else {
+			node = merkleNode(sibling, node)
+		}
+		index /= 2
+	}
+	if string(node[:]) != string(digest) {
+		return fmt.Errorf("droplet %d: Merkle path does not lead to the root: %w", droplet.BlockCode, utils.ErrProofInvalid)
+	}
+	return nil
+}
+
+// VerifyDroplets checks every droplet's path on its own; Merkle proofs
+// have no range form, so ranges are ignored.
+func (c *Merkle) VerifyDroplets(digest []byte, droplets []utils.StoredDroplet, ranges []utils.RangeProof) ([]lubyTransform.LTBlock, []int64) {
+	verified := make([]lubyTransform.LTBlock, 0, len(droplets))
+	var rejected []int64
+	for _, droplet := range droplets {
+		if c.Verify(digest, droplet.LTBlock, droplet.Proof) == nil {
+			verified = append(verified, droplet.LTBlock)
+		} else {
+			rejected = append(rejected, droplet.BlockCode)
+		}
+	}
+	return verified, rejected
+}
+
+func (c *Merkle) Setup() (*utils.CommitmentSetup, error) {
+	return &utils.CommitmentSetup{Scheme: utils.CommitmentMerkle}, nil
+}
@@ -0,0 +1,231 @@
+// Package fraud proves that a setup published droplets that are not the
+// code blocks of any message. Droplet i of a run is the XOR of the source
+// blocks PickIndices(i), so whenever the relations of a set of droplets
+// cancel, every source block appearing an even number of times, the data
+// of those droplets must XOR to zero as well. A set whose data does not is
+// a proof of fraud: it is checked with the droplets' openings under the
+// published droplet commitment and the codec parameters alone, without
+// the message.
+package fraud
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+
+	commitment "github.com/xm0onh/thesis/packages/commitment"
+	kzg "github.com/xm0onh/thesis/packages/kzg"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+// ProofKey is the blob a decoder publishes a fraud proof under.
+const ProofKey = "fraud-proof.dat"
+
+// ErrInconsistentDroplets means the committed droplets are not a valid
+// encoding of any message; a Proof names one droplet that shows it.
+var ErrInconsistentDroplets = errors.New("droplets are not a consistent encoding of any message")
+
+// Proof shows that the droplet with BlockCode Droplet contradicts other
+// committed droplets. Droplets holds it first, followed by the droplets
+// whose relations cancel its own, each with its opening proof.
+type Proof struct {
+	Droplet  int64
+	Droplets []utils.StoredDroplet
+}
+
+// Size is the number of bytes of droplet data and openings in the proof.
+func (p *Proof) Size() int {
+	size := 0
+	for _, droplet := range p.Droplets {
+		size += len(droplet.Data) + len(droplet.Proof)
+	}
+	return size
+}
+
+// MarshalProof encodes a proof for publishing.
+func MarshalProof(p *Proof) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(p); err != nil {
+		return nil, fmt.Errorf("failed to encode fraud proof: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalProof decodes a proof written by MarshalProof.
+func UnmarshalProof(data []byte) (*Proof, error) {
+	var p Proof
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&p); err != nil {
+		return nil, fmt.Errorf("failed to decode fraud proof: %v: %w", err, utils.ErrProofInvalid)
+	}
+	return &p, nil
+}
+
+// row is a reduced relation: the source blocks it XORs, and the positions
+// of the droplets whose relations sum to it.
+type row struct {
+	indices []int
+	members []int
+}
+
+// Find checks every droplet against the source blocks decoded from them
+// and returns a proof against the droplet that disagrees with them using
+// the fewest other droplets, or nil if all agree. The relations of the
+// droplets that agree are reduced by Gaussian elimination over GF(2);
+// since the sources were decoded from the droplets, the relation of a
+// droplet that disagrees reduces to nothing, and the droplets used on the
+// way are the ones it contradicts. Droplets are returned as given, so their
+// proofs must be filled in before the proof is published.
+func Find(codec lubyTransform.Codec, sources [][]byte, droplets []utils.StoredDroplet) (*Proof, error) {
+	droplets = append([]utils.StoredDroplet{}, droplets...)
+	sort.Slice(droplets, func(i, j int) bool { return droplets[i].BlockCode < droplets[j].BlockCode })
+
+	relations := make([][]int, len(droplets))
+	var inconsistent []int
+	basis := make(map[int]row)
+	for i, droplet := range droplets {
+		relations[i] = codec.PickIndices(droplet.BlockCode)
+		if isZero(xorData(droplet.Data, combine(sources, relations[i]))) {
+			reduce(basis, row{indices: relations[i], members: []int{i}}, true)
+		} else {
+			inconsistent = append(inconsistent, i)
+		}
+	}
+	if len(inconsistent) == 0 {
+		return nil, nil
+	}
+
+	var best *Proof
+	for _, i := range inconsistent {
+		r := reduce(basis, row{indices: relations[i]}, false)
+		if len(r.indices) > 0 {
+			// The other droplets do not determine this one's sources.
+			continue
+		}
+		proof := &Proof{Droplet: droplets[i].BlockCode, Droplets: []utils.StoredDroplet{droplets[i]}}
+		for _, j := range r.members {
+			proof.Droplets = append(proof.Droplets, droplets[j])
+		}
+		if best == nil || len(proof.Droplets) < len(best.Droplets) {
+			best = proof
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%d droplets disagree with the source blocks, but none is determined by the others", len(inconsistent))
+	}
+	return best, nil
+}
+
+// reduce eliminates the leading source blocks of r against basis and, if
+// insert is set and something is left, adds the rest to basis.
+func reduce(basis map[int]row, r row, insert bool) row {
+	for len(r.indices) > 0 {
+		pivot, ok := basis[r.indices[0]]
+		if !ok {
+			break
+		}
+		r.indices = symmetricDifference(r.indices, pivot.indices)
+		r.members = symmetricDifference(r.members, pivot.members)
+	}
+	if insert && len(r.indices) > 0 {
+		basis[r.indices[0]] = r
+	}
+	return r
+}
+
+// Verify checks proof against the droplet commitment of setup. It returns
+// nil if every droplet opens under the commitment, their relations cancel
+// and their data does not, which no honest encoding can produce. Any other
+// outcome wraps utils.ErrProofInvalid. A KZG commitment is checked against
+// the SRS config selects.
+func Verify(config kzg.SRSConfig, setup utils.SetupRecord, proof *Proof) error {
+	if setup.Commitment == nil {
+		return errors.New("setup publishes no droplet commitment")
+	}
+	c, err := commitment.ForSetup(config, setup.Commitment, setup.EncodedBlockIDs)
+	if err != nil {
+		return err
+	}
+	if len(proof.Droplets) == 0 || proof.Droplets[0].BlockCode != proof.Droplet {
+		return fmt.Errorf("fraud proof does not start with droplet %d: %w", proof.Droplet, utils.ErrProofInvalid)
+	}
+
+	codec := utils.NewCodec(setup.Parameters())
+	seen := make(map[int64]bool)
+	var indices []int
+	var data []byte
+	for _, droplet := range proof.Droplets {
+		if droplet.BlockCode < 0 || droplet.BlockCode >= int64(setup.EncodedBlockIDs) || seen[droplet.BlockCode] {
+			return fmt.Errorf("fraud proof repeats or invents droplet %d: %w", droplet.BlockCode, utils.ErrProofInvalid)
+		}
+		seen[droplet.BlockCode] = true
+		if err := c.Verify(setup.Commitment.Digest, droplet.LTBlock, droplet.Proof); err != nil {
+			return fmt.Errorf("droplet %d of the fraud proof: %w", droplet.BlockCode, err)
+		}
+		indices = symmetricDifference(indices, codec.PickIndices(droplet.BlockCode))
+		data = xorData(data, droplet.Data)
+	}
+	if len(indices) > 0 {
+		return fmt.Errorf("relations of the fraud proof leave source blocks %v: %w", indices, utils.ErrProofInvalid)
+	}
+	if isZero(data) {
+		return fmt.Errorf("droplets of the fraud proof are consistent: %w", utils.ErrProofInvalid)
+	}
+	return nil
+}
+
+// combine XORs the source blocks at indices.
+func combine(sources [][]byte, indices []int) []byte {
+	var out []byte
+	for _, i := range indices {
+		if i < len(sources) {
+			out = xorData(out, sources[i])
+		}
+	}
+	return out
+}
+
+// xorData XORs a and b, reading the shorter one as padded with zeros.
+func xorData(a, b []byte) []byte {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	out := append([]byte{}, a...)
+	for i := range b {
+		out[i] ^= b[i]
+	}
+	return out
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// symmetricDifference returns the sorted elements in exactly one of the
+// sorted slices a and b.
+func symmetricDifference(a, b []int) []int {
+	out := make([]int, 0, len(a)+len(b))
+	var i, j int
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case a[i] < b[j]:
+			out = append(out, a[i])
+			i++
+		default:
+			out = append(out, b[j])
+			j++
+		}
+	}
+	out = append(out, a[i:]...)
+	return append(out, b[j:]...)
+}
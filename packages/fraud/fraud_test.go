@@ -0,0 +1,176 @@
+package fraud
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+
+	commitment "github.com/xm0onh/thesis/packages/commitment"
+	kzg "github.com/xm0onh/thesis/packages/kzg"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+var insecureConfig = kzg.SRSConfig{Insecure: true}
+
+// corruptDroplet is the droplet a dishonest setup alters in testRun.
+const corruptDroplet = 17
+
+// testRun encodes a message into 40 droplets of 10 source blocks and
+// commits to them under scheme; if dishonest, droplet corruptDroplet is
+// altered before the commitment. It returns the setup record, the codec
+// and source blocks of the message, and the committed droplets with their
+// proofs.
+func testRun(t *testing.T, scheme string, dishonest bool) (utils.SetupRecord, lubyTransform.Codec, [][]byte, []utils.StoredDroplet) {
+	t.Helper()
+	message := make([]byte, 500)
+	rand.New(rand.NewSource(5)).Read(message)
+	param := utils.SetupParameters{
+		SetupID:         "fraud-test",
+		DegreeCDF:       lubyTransform.SolitonDistribution(10),
+		RandomSeed:      7,
+		SourceBlocks:    10,
+		EncodedBlockIDs: 40,
+		MessageSize:     len(message),
+	}
+	codec := utils.NewCodec(param)
+	codes := make([]int64, param.EncodedBlockIDs)
+	for i := range codes {
+		codes[i] = int64(i)
+	}
+	droplets := lubyTransform.EncodeLTBlocks(message, codes, codec)
+	if dishonest {
+		droplets[corruptDroplet].Data = append([]byte(nil), droplets[corruptDroplet].Data...)
+		droplets[corruptDroplet].Data[0] ^= 1
+	}
+
+	ctx := context.Background()
+	c, err := commitment.New(scheme, insecureConfig, len(droplets), 0, droplets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := utils.NewMemoryBlobStore()
+	setup := utils.NewSetupRecord(param, "message")
+	if setup.Commitment, err = commitment.Publish(ctx, blobs, c, len(droplets), droplets); err != nil {
+		t.Fatal(err)
+	}
+	proofs, err := setup.LoadDropletProofs(ctx, blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := make([]utils.StoredDroplet, len(droplets))
+	for i := range droplets {
+		stored[i] = utils.StoredDroplet{LTBlock: droplets[i], Proof: proofs[i]}
+	}
+	return setup, codec, lubyTransform.SplitMessage(message, codec), stored
+}
+
+func TestFindHonestEncoding(t *testing.T) {
+	_, codec, sources, droplets := testRun(t, utils.CommitmentMerkle, false)
+	proof, err := Find(codec, sources, droplets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof != nil {
+		t.Fatalf("fraud proof against droplet %d of an honest encoding", proof.Droplet)
+	}
+}
+
+func TestFindAndVerify(t *testing.T) {
+	for _, scheme := range []string{utils.CommitmentKZG, utils.CommitmentMerkle} {
+		t.Run(scheme, func(t *testing.T) {
+			setup, codec, sources, droplets := testRun(t, scheme, true)
+			proof, err := Find(codec, sources, droplets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if proof == nil {
+				t.Fatal("no fraud proof against a dishonest encoding")
+			}
+			if proof.Droplet != corruptDroplet || proof.Droplets[0].BlockCode != corruptDroplet {
+				t.Fatalf("fraud proof against droplet %d, want %d", proof.Droplet, corruptDroplet)
+			}
+			if len(proof.Droplets) < 2 || proof.Size() == 0 {
+				t.Fatalf("fraud proof of %d droplets and %d bytes", len(proof.Droplets), proof.Size())
+			}
+			if err := Verify(insecureConfig, setup, proof); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := MarshalProof(proof)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := UnmarshalProof(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Verify(insecureConfig, setup, decoded); err != nil {
+				t.Fatalf("decoded proof: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsBadProofs(t *testing.T) {
+	setup, codec, sources, droplets := testRun(t, utils.CommitmentKZG, true)
+	proof, err := Find(codec, sources, droplets)
+	if err != nil || proof == nil {
+		t.Fatalf("no fraud proof: %v", err)
+	}
+
+	for name, tamper := range map[string]func(p *Proof){
+		"no droplets":      func(p *Proof) { p.Droplets = nil },
+		"dropped droplet":  func(p *Proof) { p.Droplets = p.Droplets[:len(p.Droplets)-1] },
+		"repeated droplet": func(p *Proof) { p.Droplets = append(p.Droplets, p.Droplets[1]) },
+		"another lead":     func(p *Proof) { p.Droplet = p.Droplets[1].BlockCode },
+		"unknown droplet":  func(p *Proof) { p.Droplets[1].BlockCode = int64(setup.EncodedBlockIDs) },
+		"missing opening":  func(p *Proof) { p.Droplets[1].Proof = nil },
+		"altered data": func(p *Proof) {
+			p.Droplets[1].Data = append([]byte(nil), p.Droplets[1].Data...)
+			p.Droplets[1].Data[2] ^= 0x10
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tampered := &Proof{Droplet: proof.Droplet, Droplets: append([]utils.StoredDroplet(nil), proof.Droplets...)}
+			tamper(tampered)
+			if err := Verify(insecureConfig, setup, tampered); !errors.Is(err, utils.ErrProofInvalid) {
+				t.Fatalf("got %v, want ErrProofInvalid", err)
+			}
+		})
+	}
+
+	// The same droplets of an honest encoding, opened under its own
+	// commitment, are consistent and prove nothing.
+	honestSetup, _, _, honestDroplets := testRun(t, utils.CommitmentKZG, false)
+	honest := &Proof{Droplet: proof.Droplet}
+	for _, droplet := range proof.Droplets {
+		honest.Droplets = append(honest.Droplets, honestDroplets[droplet.BlockCode])
+	}
+	if err := Verify(insecureConfig, honestSetup, honest); !errors.Is(err, utils.ErrProofInvalid) {
+		t.Fatalf("proof from an honest encoding: %v", err)
+	}
+	// Nor does the fraud proof against a setup that did not commit to it.
+	if err := Verify(insecureConfig, honestSetup, proof); !errors.Is(err, utils.ErrProofInvalid) {
+		t.Fatalf("proof against another setup: %v", err)
+	}
+}
+
+func TestVerifyNeedsTrustedCommitment(t *testing.T) {
+	setup, codec, sources, droplets := testRun(t, utils.CommitmentKZG, true)
+	proof, err := Find(codec, sources, droplets)
+	if err != nil || proof == nil {
+		t.Fatalf("no fraud proof: %v", err)
+	}
+	if err := Verify(kzg.SRSConfig{}, setup, proof); !errors.Is(err, kzg.ErrNoSRS) {
+		t.Fatalf("KZG setup without a trusted SRS: %v", err)
+	}
+	setup.Commitment = nil
+	if err := Verify(insecureConfig, setup, proof); err == nil {
+		t.Fatal("verified against a setup without a commitment")
+	}
+	if _, err := UnmarshalProof([]byte("not a proof")); !errors.Is(err, utils.ErrProofInvalid) {
+		t.Fatalf("malformed proof: %v", err)
+	}
+}
@@ -0,0 +1,377 @@
+package kzg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+var (
+	ErrInvalidCeremony = errors.New("invalid ceremony transcript")
+	ErrNoSRS           = errors.New("no SRS source configured")
+	ErrUntrustedSRS    = errors.New("published SRS does not match the trusted one")
+)
+
+// SRSConfig selects where the SRS comes from. A ceremony transcript is the
+// only safe source; Insecure must be set explicitly to fall back to
+// InsecureSetup.
+type SRSConfig struct {
+	// Ceremony is the path of a ceremony transcript: a snarkjs .ptau file,
+	// or a file in the JSON layout of the Ethereum KZG ceremony with BN254
+	// points.
+	Ceremony string
+
+	// Cache, if set, is where the validated SRS is kept in its compact
+	// binary form. It is rebuilt whenever the transcript changes, and
+	// checked against the transcript whenever it is read.
+	Cache string
+
+	// Insecure selects InsecureSetup when no ceremony is given. Anyone can
+	// forge proofs against it; it is meant for tests and local runs.
+	Insecure bool
+}
+
+// LoadSRS returns an SRS for polynomials of up to size coefficients from
+// the source selected by c.
+func LoadSRS(c SRSConfig, size uint64) (*SRS, error) {
+	if c.Ceremony != "" {
+		return LoadCeremony(c.Ceremony, c.Cache, size)
+	}
+	if c.Insecure {
+		fmt.Println("WARNING: using the insecure KZG test setup; proofs can be forged")
+		return InsecureSetup(size)
+	}
+	return nil, fmt.Errorf("set a ceremony transcript or select the insecure test setup: %w", ErrNoSRS)
+}
+
+// LoadMultiOpeningKey returns the key for multi-openings at up to maxPoints
+// points against srs, which must come from the same source c. A ceremony
+// may publish fewer powers in G2, which lowers the bound; one with only the
+// two that single openings need cannot support multi-openings at all.
+func LoadMultiOpeningKey(c SRSConfig, srs *SRS, maxPoints int) (*MultiVerifyingKey, error) {
+	var g2Powers []bn254.G2Affine
+	switch {
+	case c.Ceremony != "":
+		var err error
+		if g2Powers, err = loadCeremonyG2Powers(c.Ceremony, srs, maxPoints+1); err != nil {
+			return nil, err
+		}
+	case c.Insecure:
+		g2Powers = insecureG2Powers(maxPoints + 1)
+	default:
+		return nil, fmt.Errorf("set a ceremony transcript or select the insecure test setup: %w", ErrNoSRS)
+	}
+	if len(srs.Pk.G1) < len(g2Powers)-1 {
+		g2Powers = g2Powers[:len(srs.Pk.G1)+1]
+	}
+	return NewMultiVerifyingKey(srs, g2Powers)
+}
+
+// TrustedSRS decodes the SRS a setup record published and checks it
+// against the same number of powers from the source selected by c.
+// Verifiers must not take that SRS on trust: whoever chose it may know its
+// secret and forge proofs.
+func TrustedSRS(c SRSConfig, published []byte) (*SRS, error) {
+	srs, err := UnmarshalSRS(published)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, utils.ErrInvalidSetup)
+	}
+	trusted, err := LoadSRS(c, uint64(len(srs.Pk.G1)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SRS: %w", err)
+	}
+	if !sameSRS(srs, trusted) {
+		return nil, ErrUntrustedSRS
+	}
+	return trusted, nil
+}
+
+func sameSRS(a, b *SRS) bool {
+	if len(a.Pk.G1) != len(b.Pk.G1) || !a.Vk.G1.Equal(&b.Vk.G1) ||
+		!a.Vk.G2[0].Equal(&b.Vk.G2[0]) || !a.Vk.G2[1].Equal(&b.Vk.G2[1]) {
+		return false
+	}
+	for i := range a.Pk.G1 {
+		if !a.Pk.G1[i].Equal(&b.Pk.G1[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadCeremonyG2Powers reads up to n powers in G2 from the sub-ceremony
+// that srs was taken from and validates them.
+func loadCeremonyG2Powers(path string, srs *SRS, n int) ([]bn254.G2Affine, error) {
+	g1Powers, g2Powers, err := readCeremonyPowers(path, len(srs.Pk.G1), 2, n)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if !g1Powers[1].Equal(&srs.Pk.G1[1]) {
+		return nil, fmt.Errorf("%s: no transcript matches the SRS: %w", path, ErrInvalidCeremony)
+	}
+	if len(g2Powers) < 3 {
+		return nil, fmt.Errorf("%s: the transcript has only %d G2 powers, multi-openings need 3 or more: %w", path, len(g2Powers), ErrInvalidCeremony)
+	}
+	if err := VerifyG2Powers(srs, g2Powers); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return g2Powers, nil
+}
+
+// ceremonyTranscript is the layout of the Ethereum KZG ceremony output: one
+// or more sub-ceremonies, each with its powers of tau as hex points.
+type ceremonyTranscript struct {
+	Transcripts []struct {
+		NumG1Powers int `json:"numG1Powers"`
+		NumG2Powers int `json:"numG2Powers"`
+		PowersOfTau struct {
+			G1Powers []string `json:"G1Powers"`
+			G2Powers []string `json:"G2Powers"`
+		} `json:"powersOfTau"`
+	} `json:"transcripts"`
+}
+
+// cacheMagic starts a cached SRS; it is followed by the SHA-256 of the
+// transcript, the SRS size and the SRS itself.
+var cacheMagic = []byte("thesis-srs-v1\n")
+
+// LoadCeremony reads the first size powers of tau from the ceremony file at
+// path and validates them. The file is a snarkjs .ptau file, such as those
+// of the Perpetual Powers of Tau ceremony, if its name ends in .ptau, and a
+// JSON transcript otherwise. With cache set, a cached SRS of the same file
+// and size is used instead if it still validates against the file, and a
+// fresh one is written after validation.
+func LoadCeremony(path, cache string, size uint64) (*SRS, error) {
+	var transcriptHash [32]byte
+	if cache != "" {
+		var err error
+		if transcriptHash, err = hashFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read ceremony transcript: %w", err)
+		}
+		if srs, err := readCachedSRS(cache, transcriptHash, size); err == nil && checkCachedSRS(path, srs) == nil {
+			return srs, nil
+		}
+	}
+
+	g1Powers, g2Powers, err := readCeremonyPowers(path, int(size), int(size), 2)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var srs SRS
+	srs.Pk.G1 = g1Powers
+	srs.Vk.G1 = g1Powers[0]
+	copy(srs.Vk.G2[:], g2Powers)
+	if err := VerifySRS(&srs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if cache != "" {
+		if err := writeCachedSRS(cache, transcriptHash, size, &srs); err != nil {
+			return nil, fmt.Errorf("failed to cache SRS: %w", err)
+		}
+	}
+	return &srs, nil
+}
+
+// checkCachedSRS validates an SRS read from the cache as if it came from
+// the file at path: VerifySRS shows it holds the powers of one secret, and
+// its first powers in both groups must be the file's. Anyone who can write
+// the cache can otherwise substitute an SRS whose secret they know.
+func checkCachedSRS(path string, srs *SRS) error {
+	if err := VerifySRS(srs); err != nil {
+		return err
+	}
+	g1Powers, g2Powers, err := readCeremonyPowers(path, len(srs.Pk.G1), 2, 2)
+	if err != nil {
+		return err
+	}
+	if !g1Powers[1].Equal(&srs.Pk.G1[1]) || !g2Powers[1].Equal(&srs.Vk.G2[1]) {
+		return fmt.Errorf("cached SRS is not the transcript's: %w", ErrInvalidCeremony)
+	}
+	return nil
+}
+
+func hashFile(path string) ([32]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return [32]byte{}, err
+	}
+	return [32]byte(h.Sum(nil)), nil
+}
+
+// readCeremonyPowers reads the first g1 powers of tau in G1 and up to g2 in
+// G2 from the ceremony file at path. A JSON transcript can hold several
+// sub-ceremonies; the smallest one with at least minG1 powers in G1 and two
+// in G2 is used.
+func readCeremonyPowers(path string, minG1, g1, g2 int) ([]bn254.G1Affine, []bn254.G2Affine, error) {
+	if strings.EqualFold(filepath.Ext(path), ".ptau") {
+		return readPtau(path, minG1, g1, g2)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read ceremony transcript: %w", err)
+	}
+	var transcript ceremonyTranscript
+	if err := json.Unmarshal(data, &transcript); err != nil {
+		return nil, nil, fmt.Errorf("%v: %w", err, ErrInvalidCeremony)
+	}
+	// Use the smallest sub-ceremony that is large enough.
+	chosen := -1
+	for i, t := range transcript.Transcripts {
+		n := len(t.PowersOfTau.G1Powers)
+		if t.NumG1Powers != 0 && t.NumG1Powers != n {
+			return nil, nil, fmt.Errorf("transcript %d lists %d of %d G1 powers: %w", i, n, t.NumG1Powers, ErrInvalidCeremony)
+		}
+		if n >= minG1 && len(t.PowersOfTau.G2Powers) >= 2 &&
+			(chosen < 0 || n < len(transcript.Transcripts[chosen].PowersOfTau.G1Powers)) {
+			chosen = i
+		}
+	}
+	if chosen < 0 {
+		return nil, nil, fmt.Errorf("no transcript has %d G1 powers and 2 G2 powers: %w", minG1, ErrInvalidCeremony)
+	}
+	powers := transcript.Transcripts[chosen].PowersOfTau
+
+	g1Powers := make([]bn254.G1Affine, g1)
+	for i := range g1Powers {
+		b, err := decodePoint(powers.G1Powers[i], bn254.SizeOfG1AffineCompressed, bn254.SizeOfG1AffineUncompressed, 48)
+		if err != nil {
+			return nil, nil, fmt.Errorf("G1 power %d: %w", i, err)
+		}
+		if _, err := g1Powers[i].SetBytes(b); err != nil {
+			return nil, nil, fmt.Errorf("G1 power %d: %v: %w", i, err, ErrInvalidCeremony)
+		}
+	}
+	g2Powers := make([]bn254.G2Affine, min(g2, len(powers.G2Powers)))
+	for i := range g2Powers {
+		b, err := decodePoint(powers.G2Powers[i], bn254.SizeOfG2AffineCompressed, bn254.SizeOfG2AffineUncompressed, 96)
+		if err != nil {
+			return nil, nil, fmt.Errorf("G2 power %d: %w", i, err)
+		}
+		if _, err := g2Powers[i].SetBytes(b); err != nil {
+			return nil, nil, fmt.Errorf("G2 power %d: %v: %w", i, err, ErrInvalidCeremony)
+		}
+	}
+	return g1Powers, g2Powers, nil
+}
+
+// decodePoint decodes a hex point of one of the BN254 sizes. blsSize is the
+// compressed size of the same group on BLS12-381, which the Ethereum
+// ceremony uses and this package cannot.
+func decodePoint(s string, compressed, uncompressed, blsSize int) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrInvalidCeremony)
+	}
+	switch len(b) {
+	case compressed, uncompressed:
+		return b, nil
+	case blsSize:
+		return nil, fmt.Errorf("%d-byte point looks like BLS12-381, commitments here are on BN254: %w", len(b), ErrInvalidCeremony)
+	default:
+		return nil, fmt.Errorf("%d-byte point: %w", len(b), ErrInvalidCeremony)
+	}
+}
+
+// VerifySRS checks that srs holds consecutive powers of one secret: both
+// first elements are the generators, the secret is not trivial, and
+// e(τⁱ⁺¹G₁, G₂) = e(τⁱG₁, τG₂) for every i. The pairing equations are
+// checked together over a random linear combination.
+func VerifySRS(srs *SRS) error {
+	_, _, g1, g2 := bn254.Generators()
+	g1s := srs.Pk.G1
+	if len(g1s) < 2 {
+		return fmt.Errorf("SRS has %d G1 powers: %w", len(g1s), ErrInvalidCeremony)
+	}
+	if !g1s[0].Equal(&g1) || !srs.Vk.G1.Equal(&g1) || !srs.Vk.G2[0].Equal(&g2) {
+		return fmt.Errorf("SRS does not start at the generators: %w", ErrInvalidCeremony)
+	}
+	if g1s[1].IsInfinity() || g1s[1].Equal(&g1) {
+		return fmt.Errorf("SRS secret is trivial: %w", ErrInvalidCeremony)
+	}
+
+	n := len(g1s) - 1
+	r := make([]fr.Element, n)
+	for i := range r {
+		if _, err := r[i].SetRandom(); err != nil {
+			return err
+		}
+	}
+	var lower, upper bn254.G1Affine
+	if _, err := lower.MultiExp(g1s[:n], r, ecc.MultiExpConfig{}); err != nil {
+		return err
+	}
+	if _, err := upper.MultiExp(g1s[1:], r, ecc.MultiExpConfig{}); err != nil {
+		return err
+	}
+	// e(Σrᵢτⁱ⁺¹G₁, G₂) · e(-ΣrᵢτⁱG₁, τG₂) = 1
+	lower.Neg(&lower)
+	ok, err := bn254.PairingCheck([]bn254.G1Affine{upper, lower}, []bn254.G2Affine{srs.Vk.G2[0], srs.Vk.G2[1]})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("SRS powers are not consecutive powers of one secret: %w", ErrInvalidCeremony)
+	}
+	return nil
+}
+
+func readCachedSRS(path string, transcriptHash [32]byte, size uint64) (*SRS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	header := len(cacheMagic) + len(transcriptHash) + 8
+	if len(data) < header || !bytes.HasPrefix(data, cacheMagic) ||
+		!bytes.Equal(data[len(cacheMagic):len(cacheMagic)+len(transcriptHash)], transcriptHash[:]) ||
+		binary.BigEndian.Uint64(data[header-8:header]) != size {
+		return nil, errors.New("stale SRS cache")
+	}
+	return UnmarshalSRS(data[header:])
+}
+
+func writeCachedSRS(path string, transcriptHash [32]byte, size uint64, srs *SRS) error {
+	serialized, err := MarshalSRS(srs)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.Write(cacheMagic)
+	buf.Write(transcriptHash[:])
+	binary.Write(&buf, binary.BigEndian, size)
+	buf.Write(serialized)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
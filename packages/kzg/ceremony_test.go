@@ -0,0 +1,308 @@
+package kzg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+// ceremonySecret stands in for the unknown secret of a real ceremony.
+var ceremonySecret = big.NewInt(1234567)
+
+type testPowers struct {
+	G1 []string `json:"G1Powers"`
+	G2 []string `json:"G2Powers"`
+}
+
+// testPowersOfTau returns g1 and g2 powers of secret as hex points.
+func testPowersOfTau(t *testing.T, secret *big.Int, g1, g2 int) testPowers {
+	t.Helper()
+	srs, err := Setup(uint64(g1), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var powers testPowers
+	for i := range srs.Pk.G1 {
+		b := srs.Pk.G1[i].Bytes()
+		powers.G1 = append(powers.G1, "0x"+hex.EncodeToString(b[:]))
+	}
+	_, _, _, gen := bn254.Generators()
+	power := big.NewInt(1)
+	for i := 0; i < g2; i++ {
+		var p bn254.G2Affine
+		p.ScalarMultiplication(&gen, power)
+		b := p.Bytes()
+		powers.G2 = append(powers.G2, "0x"+hex.EncodeToString(b[:]))
+		power = new(big.Int).Mul(power, secret)
+	}
+	return powers
+}
+
+// writeTranscript writes a ceremony transcript with one sub-ceremony per
+// entry of powers and returns its path.
+func writeTranscript(t *testing.T, powers ...testPowers) string {
+	t.Helper()
+	type transcript struct {
+		NumG1Powers int        `json:"numG1Powers"`
+		NumG2Powers int        `json:"numG2Powers"`
+		PowersOfTau testPowers `json:"powersOfTau"`
+	}
+	var out struct {
+		Transcripts []transcript `json:"transcripts"`
+	}
+	for _, p := range powers {
+		out.Transcripts = append(out.Transcripts, transcript{len(p.G1), len(p.G2), p})
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "transcript.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCeremony(t *testing.T) {
+	path := writeTranscript(t, testPowersOfTau(t, ceremonySecret, 16, 4), testPowersOfTau(t, big.NewInt(99), 64, 2))
+	want, err := Setup(8, ceremonySecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := filepath.Join(t.TempDir(), "srs.bin")
+	srs, err := LoadCeremony(path, cache, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameSRS(srs, want) {
+		t.Fatal("SRS is not the first powers of the smallest sub-ceremony that fits")
+	}
+	if _, err := os.Stat(cache); err != nil {
+		t.Fatalf("SRS was not cached: %v", err)
+	}
+	cached, err := LoadCeremony(path, cache, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameSRS(cached, want) {
+		t.Fatal("cached SRS differs")
+	}
+
+	// Only the second sub-ceremony is large enough.
+	large, err := LoadCeremony(path, cache, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := Setup(32, big.NewInt(99)); !sameSRS(large, want) {
+		t.Fatal("a stale cache was used for a larger SRS")
+	}
+
+	multi, err := LoadMultiOpeningKey(SRSConfig{Ceremony: path}, srs, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if multi.MaxPoints() != 3 {
+		t.Fatalf("multi-opening key for %d points from 4 G2 powers", multi.MaxPoints())
+	}
+}
+
+func TestLoadCeremonyRejects(t *testing.T) {
+	tampered := testPowersOfTau(t, ceremonySecret, 8, 2)
+	tampered.G1[5] = testPowersOfTau(t, ceremonySecret, 8, 2).G1[6]
+	bls := testPowersOfTau(t, ceremonySecret, 8, 2)
+	bls.G1[0] = "0x" + strings.Repeat("ab", 48)
+	trivial := testPowersOfTau(t, big.NewInt(1), 8, 2)
+
+	for name, transcript := range map[string]string{
+		"not consecutive powers": writeTranscript(t, tampered),
+		"BLS12-381 points":       writeTranscript(t, bls),
+		"trivial secret":         writeTranscript(t, trivial),
+		"too few powers":         writeTranscript(t, testPowersOfTau(t, ceremonySecret, 4, 2)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadCeremony(transcript, "", 8); !errors.Is(err, ErrInvalidCeremony) {
+				t.Fatalf("got %v, want ErrInvalidCeremony", err)
+			}
+		})
+	}
+
+	path := writeTranscript(t, testPowersOfTau(t, ceremonySecret, 8, 2))
+	srs, err := LoadCeremony(path, "", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadMultiOpeningKey(SRSConfig{Ceremony: path}, srs, 8); !errors.Is(err, ErrInvalidCeremony) {
+		t.Fatalf("multi-opening key from 2 G2 powers: %v", err)
+	}
+}
+
+func TestTrustedSRS(t *testing.T) {
+	path := writeTranscript(t, testPowersOfTau(t, ceremonySecret, 16, 2))
+	ceremony := SRSConfig{Ceremony: path}
+	srs, err := LoadSRS(ceremony, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, err := MarshalSRS(srs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insecure, err := InsecureSetup(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := MarshalSRS(insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := TrustedSRS(ceremony, published); err != nil {
+		t.Fatalf("ceremony SRS: %v", err)
+	}
+	if _, err := TrustedSRS(ceremony, forged); !errors.Is(err, ErrUntrustedSRS) {
+		t.Fatalf("insecure SRS under a ceremony: %v", err)
+	}
+	if _, err := TrustedSRS(SRSConfig{Insecure: true}, published); !errors.Is(err, ErrUntrustedSRS) {
+		t.Fatalf("ceremony SRS in insecure mode: %v", err)
+	}
+	if _, err := TrustedSRS(SRSConfig{Insecure: true}, forged); err != nil {
+		t.Fatalf("insecure SRS in insecure mode: %v", err)
+	}
+	if _, err := TrustedSRS(SRSConfig{}, published); !errors.Is(err, ErrNoSRS) {
+		t.Fatalf("no SRS source: %v", err)
+	}
+	if _, err := TrustedSRS(ceremony, published[:len(published)/2]); !errors.Is(err, utils.ErrInvalidSetup) {
+		t.Fatalf("truncated SRS: %v", err)
+	}
+}
+
+// writePtau writes the powers of secret as a .ptau file of the given
+// power, with only the sections the loader reads, and returns its path.
+func writePtau(t *testing.T, secret *big.Int, power int) string {
+	t.Helper()
+	numG1, numG2 := 1<<(power+1)-1, 1<<power
+	srs, err := Setup(uint64(numG1), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	element := func(out []byte, e fp.Element) []byte {
+		for _, limb := range e {
+			out = binary.LittleEndian.AppendUint64(out, limb)
+		}
+		return out
+	}
+	header := binary.LittleEndian.AppendUint32(nil, ptauFieldSize)
+	header = append(header, reversed(fp.Modulus().FillBytes(make([]byte, ptauFieldSize)))...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(power))
+	header = binary.LittleEndian.AppendUint32(header, uint32(power))
+	var g1 []byte
+	for _, p := range srs.Pk.G1 {
+		g1 = element(element(g1, p.X), p.Y)
+	}
+	var g2 []byte
+	_, _, _, gen := bn254.Generators()
+	exponent := big.NewInt(1)
+	for i := 0; i < numG2; i++ {
+		var p bn254.G2Affine
+		p.ScalarMultiplication(&gen, exponent)
+		g2 = element(element(element(element(g2, p.X.A0), p.X.A1), p.Y.A0), p.Y.A1)
+		exponent = new(big.Int).Mul(exponent, secret)
+	}
+
+	file := []byte(ptauMagic)
+	file = binary.LittleEndian.AppendUint32(file, 1)
+	file = binary.LittleEndian.AppendUint32(file, 3)
+	for i, section := range [][]byte{header, g1, g2} {
+		file = binary.LittleEndian.AppendUint32(file, uint32(i+1))
+		file = binary.LittleEndian.AppendUint64(file, uint64(len(section)))
+		file = append(file, section...)
+	}
+	path := filepath.Join(t.TempDir(), "ceremony.ptau")
+	if err := os.WriteFile(path, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPtau(t *testing.T) {
+	path := writePtau(t, ceremonySecret, 4)
+	srs, err := LoadCeremony(path, "", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := Setup(16, ceremonySecret); !sameSRS(srs, want) {
+		t.Fatal("SRS is not the first powers of the ptau file")
+	}
+	multi, err := LoadMultiOpeningKey(SRSConfig{Ceremony: path}, srs, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if multi.MaxPoints() != 8 {
+		t.Fatalf("multi-opening key for %d points from 16 G2 powers", multi.MaxPoints())
+	}
+	if _, err := LoadCeremony(path, "", 32); !errors.Is(err, ErrInvalidCeremony) {
+		t.Fatalf("32 powers from a file of 31: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first G1 power starts after the file header, the header section
+	// and the G1 section's own header.
+	firstG1 := 12 + 12 + 4 + ptauFieldSize + 8 + 12
+	for name, corrupt := range map[string]func(b []byte){
+		"not a ptau file": func(b []byte) { copy(b, "zkey") },
+		"other curve":     func(b []byte) { b[12+12+4]++ },
+		"unreduced point": func(b []byte) { copy(b[firstG1:], bytes.Repeat([]byte{0xff}, ptauFieldSize)) },
+		"point off curve": func(b []byte) { b[firstG1+2*ptauFieldSize+1]++ },
+	} {
+		t.Run(name, func(t *testing.T) {
+			b := bytes.Clone(data)
+			corrupt(b)
+			corrupted := filepath.Join(t.TempDir(), "corrupt.ptau")
+			if err := os.WriteFile(corrupted, b, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadCeremony(corrupted, "", 8); !errors.Is(err, ErrInvalidCeremony) {
+				t.Fatalf("got %v, want ErrInvalidCeremony", err)
+			}
+		})
+	}
+}
+
+func TestLoadCeremonyRevalidatesCache(t *testing.T) {
+	path := writeTranscript(t, testPowersOfTau(t, ceremonySecret, 8, 2))
+	hash, err := hashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := InsecureSetup(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := filepath.Join(t.TempDir(), "srs.bin")
+	if err := writeCachedSRS(cache, hash, 8, forged); err != nil {
+		t.Fatal(err)
+	}
+	srs, err := LoadCeremony(path, cache, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := Setup(8, ceremonySecret); !sameSRS(srs, want) {
+		t.Fatal("a forged SRS was taken from the cache")
+	}
+}
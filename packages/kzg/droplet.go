@@ -0,0 +1,515 @@
+package kzg
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+// The droplets of a run are committed as one polynomial p, laid out by a
+// DropletLayout. Under utils.CommitmentKZG p(ω^i) is the hash of the
+// droplet with BlockCode i; under utils.CommitmentKZGData p takes the droplet's
+// 31-byte chunks on a coset, so single chunks can be opened without the
+// rest of the droplet. Each droplet then carries an opening proof of its
+// values, so a decoder can check every droplet it downloads against the
+// published digest. When the setup also publishes a multi-opening key, a
+// responder instead proves the whole range of droplets it stored with one
+// multi-opening.
+
+// DropletDomain returns the evaluation domain for encodedBlockIDs droplets,
+// the smallest power of two that holds them.
+func DropletDomain(encodedBlockIDs int) *fft.Domain {
+	return fft.NewDomain(uint64(encodedBlockIDs))
+}
+
+// DropletHash is the field element a droplet's data is committed as under
+// utils.CommitmentKZG.
+func DropletHash(data []byte) fr.Element {
+	hash := sha256.Sum256(data)
+	var e fr.Element
+	e.SetBytes(hash[:])
+	return e
+}
+
+// DropletProver holds the polynomial through the committed values of the
+// droplets of a run.
+type DropletProver struct {
+	Digest Digest
+
+	layout       *DropletLayout
+	coefficients []fr.Element
+	srs          *SRS
+}
+
+// NewDropletProver interpolates the droplet values over the layout's domain
+// and commits to the result. droplets must hold every BlockCode below
+// encodedBlockIDs exactly once.
+func NewDropletProver(srs *SRS, layout *DropletLayout, encodedBlockIDs int, droplets []lubyTransform.LTBlock) (*DropletProver, error) {
+	if uint64(len(srs.Pk.G1)) < layout.Size() {
+		return nil, fmt.Errorf("SRS of size %d cannot commit to %d values", len(srs.Pk.G1), layout.Size())
+	}
+	values := make([]fr.Element, layout.Size())
+	seen := make([]bool, encodedBlockIDs)
+	for _, droplet := range droplets {
+		if droplet.BlockCode < 0 || droplet.BlockCode >= int64(encodedBlockIDs) || seen[droplet.BlockCode] {
+			return nil, fmt.Errorf("unexpected droplet with block code %d", droplet.BlockCode)
+		}
+		seen[droplet.BlockCode] = true
+		dropletValues, err := layout.Values(droplet.Data)
+		if err != nil {
+			return nil, fmt.Errorf("droplet %d: %w", droplet.BlockCode, err)
+		}
+		for j := range dropletValues {
+			index, err := layout.index(droplet.BlockCode, j)
+			if err != nil {
+				return nil, err
+			}
+			values[index] = dropletValues[j]
+		}
+	}
+	if len(droplets) != encodedBlockIDs {
+		return nil, fmt.Errorf("%d droplets for %d block codes", len(droplets), encodedBlockIDs)
+	}
+
+	// Evaluations to coefficients; DIF leaves them in bit-reversed order.
+	layout.domain.FFTInverse(values, fft.DIF)
+	fft.BitReverse(values)
+
+	digest, err := Commit(values, srs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit to droplets: %w", err)
+	}
+	return &DropletProver{Digest: digest, layout: layout, coefficients: values, srs: srs}, nil
+}
+
+// NewDropletProverForSetup rebuilds the prover of a published commitment
+// from the droplets, which must be the ones the setup committed to. It uses
+// the published SRS as is: proofs under an untrusted SRS fail at verifiers
+// that check it.
+func NewDropletProverForSetup(setup *utils.CommitmentSetup, encodedBlockIDs int, droplets []lubyTransform.LTBlock) (*DropletProver, error) {
+	srs, err := UnmarshalSRS(setup.SRS)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, utils.ErrInvalidSetup)
+	}
+	_, layout, err := parametersForSRS(srs, setup, encodedBlockIDs)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := UnmarshalDigest(setup.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, utils.ErrInvalidSetup)
+	}
+	prover, err := NewDropletProver(srs, layout, encodedBlockIDs, droplets)
+	if err != nil {
+		return nil, err
+	}
+	if !prover.Digest.Equal(&digest) {
+		return nil, fmt.Errorf("droplets do not match the published commitment: %w", utils.ErrInvalidSetup)
+	}
+	return prover, nil
+}
+
+// OpenChunk proves value j of the droplet with blockCode: its hash under
+// utils.CommitmentKZG, where j must be 0, or its j-th chunk under
+// utils.CommitmentKZGData.
+func (p *DropletProver) OpenChunk(blockCode int64, j int) (OpeningProof, error) {
+	point, err := p.layout.point(blockCode, j)
+	if err != nil {
+		return OpeningProof{}, err
+	}
+	return Open(p.coefficients, point, p.srs)
+}
+
+// OpenDroplet proves every committed value of the droplet with blockCode
+// and returns the serialized proof.
+func (p *DropletProver) OpenDroplet(blockCode int64) ([]byte, error) {
+	if p.layout.Chunks() == 1 {
+		proof, err := p.OpenChunk(blockCode, 0)
+		if err != nil {
+			return nil, err
+		}
+		return MarshalDropletProof(proof), nil
+	}
+	return p.OpenRange(int(blockCode), int(blockCode)+1)
+}
+
+// OpenAll proves every droplet and returns the serialized proofs indexed by
+// BlockCode.
+func (p *DropletProver) OpenAll(encodedBlockIDs int) ([][]byte, error) {
+	proofs := make([][]byte, encodedBlockIDs)
+	for i := range proofs {
+		proof, err := p.OpenDroplet(int64(i))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open droplet %d: %w", i, err)
+		}
+		proofs[i] = proof
+	}
+	return proofs, nil
+}
+
+// OpenRange proves the committed values of the droplets with BlockCodes in
+// [start, end) with one multi-opening and returns the serialized proof.
+func (p *DropletProver) OpenRange(start, end int) ([]byte, error) {
+	points, err := p.layout.points(int64(start), int64(end))
+	if err != nil {
+		return nil, err
+	}
+	proof, err := OpenMulti(p.coefficients, points, p.srs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open droplets [%d, %d): %w", start, end, err)
+	}
+	h := proof.H.Bytes()
+	return h[:], nil
+}
+
+// MarshalDropletProof keeps only the compressed quotient commitment; the
+// claimed value is the hash of the droplet the proof travels with.
+func MarshalDropletProof(proof OpeningProof) []byte {
+	h := proof.H.Bytes()
+	return h[:]
+}
+
+// ParametersForSetup reads the parts of a published droplet commitment that
+// do not depend on the droplets: the SRS, checked against the source
+// selected by config, the multi-opening key, nil unless the setup publishes
+// G2 powers, and the layout.
+func ParametersForSetup(config SRSConfig, setup *utils.CommitmentSetup, encodedBlockIDs int) (*SRS, *MultiVerifyingKey, *DropletLayout, error) {
+	srs, err := TrustedSRS(config, setup.SRS)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	multi, layout, err := parametersForSRS(srs, setup, encodedBlockIDs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return srs, multi, layout, nil
+}
+
+func parametersForSRS(srs *SRS, setup *utils.CommitmentSetup, encodedBlockIDs int) (*MultiVerifyingKey, *DropletLayout, error) {
+	layout, err := DropletLayoutForSetup(setup, encodedBlockIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	var multi *MultiVerifyingKey
+	if len(setup.G2Powers) > 0 {
+		g2Powers, err := UnmarshalG2Powers(setup.G2Powers)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v: %w", err, utils.ErrInvalidSetup)
+		}
+		// The powers beyond the two the SRS carries are only published
+		// here; a forged one would let range proofs be forged too.
+		if err := VerifyG2Powers(srs, g2Powers); err != nil {
+			return nil, nil, fmt.Errorf("%v: %w", err, utils.ErrInvalidSetup)
+		}
+		if multi, err = NewMultiVerifyingKey(srs, g2Powers); err != nil {
+			return nil, nil, fmt.Errorf("%v: %w", err, utils.ErrInvalidSetup)
+		}
+	}
+	if layout.Chunks() > 1 && (multi == nil || multi.MaxPoints() < layout.Chunks()) {
+		return nil, nil, fmt.Errorf("droplets of %d chunks without a multi-opening key for them: %w", layout.Chunks(), utils.ErrInvalidSetup)
+	}
+	return multi, layout, nil
+}
+
+// DropletVerifierForSetup reads the commitment material of a setup record
+// and checks its SRS against the source selected by config.
+func DropletVerifierForSetup(config SRSConfig, setup *utils.CommitmentSetup, encodedBlockIDs int) (*DropletVerifier, error) {
+	srs, multi, layout, err := ParametersForSetup(config, setup, encodedBlockIDs)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := UnmarshalDigest(setup.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, utils.ErrInvalidSetup)
+	}
+	return NewDropletVerifier(digest, srs.Vk, layout, multi), nil
+}
+
+// MaxRangeForSetup returns the most droplets one range proof may cover
+// under setup, or 0 if the setup publishes no multi-opening key.
+func MaxRangeForSetup(setup *utils.CommitmentSetup) int {
+	if setup == nil || len(setup.G2Powers) == 0 {
+		return 0
+	}
+	chunks, err := chunksPerDroplet(setup.Scheme, setup.DropletSize)
+	if err != nil {
+		return 0
+	}
+	return (len(setup.G2Powers)/bn254.SizeOfG2AffineCompressed - 1) / chunks
+}
+
+// DropletVerifier checks droplets against a published digest.
+type DropletVerifier struct {
+	digest Digest
+	vk     VerifyingKey
+	layout *DropletLayout
+
+	// multi is nil unless the setup publishes a multi-opening key.
+	multi *MultiVerifyingKey
+}
+
+func NewDropletVerifier(digest Digest, vk VerifyingKey, layout *DropletLayout, multi *MultiVerifyingKey) *DropletVerifier {
+	return &DropletVerifier{digest: digest, vk: vk, layout: layout, multi: multi}
+}
+
+// Layout returns the layout the droplets are committed with.
+func (v *DropletVerifier) Layout() *DropletLayout {
+	return v.layout
+}
+
+// dropletOpening is a parsed droplet proof together with the point it is
+// checked at. index is the droplet's position in the verified slice.
+type dropletOpening struct {
+	index int
+	point fr.Element
+	proof OpeningProof
+}
+
+// opening parses a single-point droplet proof, which is what droplets carry
+// under utils.CommitmentKZG.
+func (v *DropletVerifier) opening(droplet lubyTransform.LTBlock, proof []byte) (OpeningProof, fr.Element, error) {
+	if v.layout.Chunks() != 1 {
+		return OpeningProof{}, fr.Element{}, fmt.Errorf("droplets of %d chunks have no single-point proofs: %w", v.layout.Chunks(), utils.ErrProofInvalid)
+	}
+	point, err := v.layout.point(droplet.BlockCode, 0)
+	if err != nil {
+		return OpeningProof{}, fr.Element{}, fmt.Errorf("%v: %w", err, utils.ErrProofInvalid)
+	}
+	var h bn254.G1Affine
+	if _, err := h.SetBytes(proof); err != nil {
+		return OpeningProof{}, fr.Element{}, fmt.Errorf("droplet %d: malformed proof: %v: %w", droplet.BlockCode, err, utils.ErrProofInvalid)
+	}
+	values, err := v.layout.Values(droplet.Data)
+	if err != nil {
+		return OpeningProof{}, fr.Element{}, fmt.Errorf("droplet %d: %v: %w", droplet.BlockCode, err, utils.ErrProofInvalid)
+	}
+	return OpeningProof{H: h, ClaimedValue: values[0]}, point, nil
+}
+
+// Verify checks that proof opens the digest to the committed values of
+// droplet. Any failure wraps utils.ErrProofInvalid.
+func (v *DropletVerifier) Verify(droplet lubyTransform.LTBlock, proof []byte) error {
+	if v.layout.Chunks() > 1 {
+		return v.VerifyRange(utils.RangeProof{Start: int(droplet.BlockCode), End: int(droplet.BlockCode) + 1, Proof: proof}, []lubyTransform.LTBlock{droplet})
+	}
+	opening, point, err := v.opening(droplet, proof)
+	if err != nil {
+		return err
+	}
+	if err := Verify(v.digest, opening, point, v.vk); err != nil {
+		return fmt.Errorf("droplet %d: %w", droplet.BlockCode, err)
+	}
+	return nil
+}
+
+// VerifyChunk checks an opening of value j of the droplet with blockCode,
+// as created by DropletProver.OpenChunk. The value is proof.ClaimedValue;
+// compare it with DropletLayout.Chunk of the data it should match. Any
+// failure wraps utils.ErrProofInvalid.
+func (v *DropletVerifier) VerifyChunk(blockCode int64, j int, proof OpeningProof) error {
+	point, err := v.layout.point(blockCode, j)
+	if err != nil {
+		return fmt.Errorf("%v: %w", err, utils.ErrProofInvalid)
+	}
+	if err := Verify(v.digest, proof, point, v.vk); err != nil {
+		return fmt.Errorf("droplet %d chunk %d: %w", blockCode, j, err)
+	}
+	return nil
+}
+
+// verifyBatch checks the openings with a single multi-pairing over a random
+// linear combination of them. A failure says only that at least one opening
+// is wrong.
+func (v *DropletVerifier) verifyBatch(openings []dropletOpening) error {
+	digests := make([]Digest, len(openings))
+	proofs := make([]OpeningProof, len(openings))
+	points := make([]fr.Element, len(openings))
+	for i, o := range openings {
+		digests[i] = v.digest
+		proofs[i] = o.proof
+		points[i] = o.point
+	}
+	if err := VerifyOpenings(digests, proofs, points, v.vk); err != nil {
+		return fmt.Errorf("batch of %d droplets: %w", len(openings), err)
+	}
+	return nil
+}
+
+// VerifyBatch checks every droplet's proof with one multi-pairing. It
+// fails if any proof is missing, malformed or wrong, without saying which;
+// VerifyDroplets finds the offending droplets. Droplets committed in
+// several chunks are checked one by one.
+func (v *DropletVerifier) VerifyBatch(droplets []utils.StoredDroplet) error {
+	if len(droplets) == 0 {
+		return nil
+	}
+	if v.layout.Chunks() > 1 {
+		for _, droplet := range droplets {
+			if err := v.Verify(droplet.LTBlock, droplet.Proof); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	openings := make([]dropletOpening, len(droplets))
+	for i, droplet := range droplets {
+		proof, point, err := v.opening(droplet.LTBlock, droplet.Proof)
+		if err != nil {
+			return err
+		}
+		openings[i] = dropletOpening{index: i, point: point, proof: proof}
+	}
+	return v.verifyBatch(openings)
+}
+
+// VerifyRange checks a range proof against the droplets with BlockCodes in
+// [proof.Start, proof.End), given in BlockCode order. Any failure wraps
+// utils.ErrProofInvalid.
+func (v *DropletVerifier) VerifyRange(proof utils.RangeProof, droplets []lubyTransform.LTBlock) error {
+	if v.multi == nil {
+		return fmt.Errorf("range [%d, %d): setup publishes no multi-opening key: %w", proof.Start, proof.End, utils.ErrProofInvalid)
+	}
+	points, err := v.layout.points(int64(proof.Start), int64(proof.End))
+	if err != nil {
+		return fmt.Errorf("%v: %w", err, utils.ErrProofInvalid)
+	}
+	if len(droplets) != proof.End-proof.Start {
+		return fmt.Errorf("range [%d, %d): %d droplets: %w", proof.Start, proof.End, len(droplets), utils.ErrProofInvalid)
+	}
+	opening := MultiOpeningProof{ClaimedValues: make([]fr.Element, 0, len(points))}
+	for i, droplet := range droplets {
+		if droplet.BlockCode != int64(proof.Start+i) {
+			return fmt.Errorf("range [%d, %d): droplet %d out of place: %w", proof.Start, proof.End, droplet.BlockCode, utils.ErrProofInvalid)
+		}
+		values, err := v.layout.Values(droplet.Data)
+		if err != nil {
+			return fmt.Errorf("range [%d, %d): droplet %d: %v: %w", proof.Start, proof.End, droplet.BlockCode, err, utils.ErrProofInvalid)
+		}
+		opening.ClaimedValues = append(opening.ClaimedValues, values...)
+	}
+	if _, err := opening.H.SetBytes(proof.Proof); err != nil {
+		return fmt.Errorf("range [%d, %d): malformed proof: %v: %w", proof.Start, proof.End, err, utils.ErrProofInvalid)
+	}
+	if err := VerifyMulti(v.digest, opening, points, v.multi); err != nil {
+		return fmt.Errorf("range [%d, %d): %w", proof.Start, proof.End, err)
+	}
+	return nil
+}
+
+// rangeMembers returns the indexes in droplets of the range's droplets, or
+// false if the range cannot be checked: it is too large for the key or some
+// of its droplets are missing or stored twice.
+func (v *DropletVerifier) rangeMembers(r utils.RangeProof, byCode map[int64]int) ([]int, bool) {
+	if v.multi == nil || r.Start < 0 || r.End <= r.Start || (r.End-r.Start)*v.layout.Chunks() > v.multi.MaxPoints() {
+		return nil, false
+	}
+	members := make([]int, 0, r.End-r.Start)
+	for code := int64(r.Start); code < int64(r.End); code++ {
+		i, ok := byCode[code]
+		if !ok || i < 0 {
+			return nil, false
+		}
+		members = append(members, i)
+	}
+	return members, true
+}
+
+// VerifyDroplets returns the droplets whose proofs verify and the block
+// codes of the ones rejected. Droplets covered by a range proof are checked
+// with one pairing per range; a range that fails costs all of its droplets.
+// The remaining droplets are checked by their own proofs. Single-point
+// proofs are first checked as one batch; if that fails the batch is
+// bisected, so k bad droplets among n cost about k·log(n) batch checks
+// instead of n pairings. Like authentication failures, a bad proof only
+// costs the droplets it covers; the fountain code makes up for them from
+// the others.
+func (v *DropletVerifier) VerifyDroplets(droplets []utils.StoredDroplet, ranges []utils.RangeProof) ([]lubyTransform.LTBlock, []int64) {
+	// byCode maps a BlockCode to its droplet, or to -1 if several droplets
+	// claim it and no range can vouch for either.
+	byCode := make(map[int64]int, len(droplets))
+	for i, droplet := range droplets {
+		if _, ok := byCode[droplet.BlockCode]; ok {
+			byCode[droplet.BlockCode] = -1
+		} else {
+			byCode[droplet.BlockCode] = i
+		}
+	}
+	valid := make([]bool, len(droplets))
+	for _, r := range ranges {
+		members, ok := v.rangeMembers(r, byCode)
+		if !ok {
+			continue
+		}
+		blocks := make([]lubyTransform.LTBlock, len(members))
+		for j, i := range members {
+			blocks[j] = droplets[i].LTBlock
+		}
+		if v.VerifyRange(r, blocks) == nil {
+			for _, i := range members {
+				valid[i] = true
+			}
+		}
+	}
+
+	var rejected []int64
+	openings := make([]dropletOpening, 0, len(droplets))
+	for i, droplet := range droplets {
+		if valid[i] {
+			continue
+		}
+		if v.layout.Chunks() > 1 {
+			// Multi-point proofs are checked one droplet at a time.
+			if v.Verify(droplet.LTBlock, droplet.Proof) == nil {
+				valid[i] = true
+			} else {
+				rejected = append(rejected, droplet.BlockCode)
+			}
+			continue
+		}
+		proof, point, err := v.opening(droplet.LTBlock, droplet.Proof)
+		if err != nil {
+			rejected = append(rejected, droplet.BlockCode)
+			continue
+		}
+		openings = append(openings, dropletOpening{index: i, point: point, proof: proof})
+	}
+	v.bisect(openings, valid)
+
+	verified := make([]lubyTransform.LTBlock, 0, len(droplets))
+	for _, o := range openings {
+		if !valid[o.index] {
+			rejected = append(rejected, droplets[o.index].BlockCode)
+		}
+	}
+	for i, droplet := range droplets {
+		if valid[i] {
+			verified = append(verified, droplet.LTBlock)
+		}
+	}
+	return verified, rejected
+}
+
+// bisect marks the openings that verify in valid, splitting failed batches
+// in half until the bad openings are isolated.
+func (v *DropletVerifier) bisect(openings []dropletOpening, valid []bool) {
+	if len(openings) == 0 {
+		return
+	}
+	if v.verifyBatch(openings) == nil {
+		for _, o := range openings {
+			valid[o.index] = true
+		}
+		return
+	}
+	if len(openings) == 1 {
+		return
+	}
+	mid := len(openings) / 2
+	v.bisect(openings[:mid], valid)
+	v.bisect(openings[mid:], valid)
+}
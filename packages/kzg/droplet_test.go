@@ -0,0 +1,122 @@
+package kzg
+
+import (
+	"errors"
+	"testing"
+
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+func storedDroplets(t *testing.T, prover *DropletProver, droplets []lubyTransform.LTBlock) []utils.StoredDroplet {
+	t.Helper()
+	proofs, err := prover.OpenAll(len(droplets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := make([]utils.StoredDroplet, len(droplets))
+	for i := range droplets {
+		stored[i] = utils.StoredDroplet{LTBlock: droplets[i], Proof: proofs[i]}
+	}
+	return stored
+}
+
+func TestDropletProofs(t *testing.T) {
+	for _, scheme := range []string{utils.CommitmentKZG, utils.CommitmentKZGData} {
+		t.Run(scheme, func(t *testing.T) {
+			droplets := testDroplets(12, 100)
+			prover, setup := testDropletSetup(t, scheme, droplets)
+			verifier, err := DropletVerifierForSetup(insecureConfig, setup, len(droplets))
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored := storedDroplets(t, prover, droplets)
+			for _, droplet := range stored {
+				if err := verifier.Verify(droplet.LTBlock, droplet.Proof); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := verifier.VerifyBatch(stored); err != nil {
+				t.Fatal(err)
+			}
+
+			// Droplet 3 with altered data, droplet 7 with the proof of
+			// droplet 8 and droplet 9 without a proof.
+			stored[3].Data = append([]byte(nil), stored[3].Data...)
+			stored[3].Data[10] ^= 0x80
+			stored[7].Proof = stored[8].Proof
+			stored[9].Proof = nil
+			for _, i := range []int{3, 7, 9} {
+				if err := verifier.Verify(stored[i].LTBlock, stored[i].Proof); !errors.Is(err, utils.ErrProofInvalid) {
+					t.Fatalf("droplet %d: got %v, want ErrProofInvalid", i, err)
+				}
+			}
+			if err := verifier.VerifyBatch(stored); !errors.Is(err, utils.ErrProofInvalid) {
+				t.Fatalf("batch with bad droplets: %v", err)
+			}
+			verified, rejected := verifier.VerifyDroplets(stored, nil)
+			if len(verified) != len(droplets)-3 {
+				t.Fatalf("%d droplets verified, want %d", len(verified), len(droplets)-3)
+			}
+			if len(rejected) != 3 {
+				t.Fatalf("rejected %v, want droplets 3, 7 and 9", rejected)
+			}
+			for _, code := range rejected {
+				if code != 3 && code != 7 && code != 9 {
+					t.Fatalf("rejected %v, want droplets 3, 7 and 9", rejected)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyChunk(t *testing.T) {
+	droplets := testDroplets(8, 100)
+	prover, setup := testDropletSetup(t, utils.CommitmentKZGData, droplets)
+	verifier, err := DropletVerifierForSetup(insecureConfig, setup, len(droplets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := verifier.Layout()
+	if layout.Chunks() != 4 {
+		t.Fatalf("100-byte droplets in %d chunks, want 4", layout.Chunks())
+	}
+
+	proof, err := prover.OpenChunk(5, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.VerifyChunk(5, 2, proof); err != nil {
+		t.Fatal(err)
+	}
+	chunk, err := layout.Chunk(droplets[5].Data, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proof.ClaimedValue.Equal(&chunk) {
+		t.Fatal("opened chunk differs from the droplet's data")
+	}
+	if err := verifier.VerifyChunk(5, 1, proof); !errors.Is(err, utils.ErrProofInvalid) {
+		t.Fatalf("chunk opened at another position: %v", err)
+	}
+	if err := verifier.VerifyChunk(5, layout.Chunks(), proof); !errors.Is(err, utils.ErrProofInvalid) {
+		t.Fatalf("chunk past the droplet: %v", err)
+	}
+	proof.ClaimedValue.SetUint64(1)
+	if err := verifier.VerifyChunk(5, 2, proof); !errors.Is(err, utils.ErrProofInvalid) {
+		t.Fatalf("altered chunk: %v", err)
+	}
+}
+
+func TestDropletProverForSetup(t *testing.T) {
+	droplets := testDroplets(8, 40)
+	_, setup := testDropletSetup(t, utils.CommitmentKZG, droplets)
+	if _, err := NewDropletProverForSetup(setup, len(droplets), droplets); err != nil {
+		t.Fatal(err)
+	}
+	other := testDroplets(8, 40)
+	other[0].Data[0] ^= 1
+	if _, err := NewDropletProverForSetup(setup, len(other), append(other[:1:1], droplets[1:]...)); !errors.Is(err, utils.ErrInvalidSetup) {
+		t.Fatalf("droplets the setup did not commit to: %v", err)
+	}
+}
@@ -1,168 +1,154 @@
+// Package kzg implements KZG polynomial commitments on BN254 with
+// gnark-crypto, and the droplet commitment built on them. Setup, setupEC2
+// and the decoder reach it through packages/commitment; responders use the
+// droplet prover directly to publish range proofs.
 package kzg
 
 import (
 	"bytes"
-	"context"
+	"crypto/sha256"
 	"fmt"
-	"log"
 	"math/big"
-	"math/rand"
-	"time"
-
-	kzg "github.com/arnaucube/kzg-commitments-study"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go/aws"
-	gthCrypto "github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/crypto/bn256"
-	poly "github.com/georgercarder/polynomial"
-	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	gkzg "github.com/consensys/gnark-crypto/ecc/bn254/fr/kzg"
 	utils "github.com/xm0onh/thesis/packages/utils"
 )
 
-func UploadToS3(ctx context.Context, s3Client *s3.Client, bucket, key string, data []byte) error {
-	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-		Body:   bytes.NewReader(data),
-	})
+type (
+	SRS               = gkzg.SRS
+	ProvingKey        = gkzg.ProvingKey
+	VerifyingKey      = gkzg.VerifyingKey
+	Digest            = gkzg.Digest
+	OpeningProof      = gkzg.OpeningProof
+	BatchOpeningProof = gkzg.BatchOpeningProof
+)
 
-	return err
-}
+// Size of the canonical encodings, which use compressed points.
+const (
+	DigestSize       = bn254.SizeOfG1AffineCompressed
+	OpeningProofSize = bn254.SizeOfG1AffineCompressed + fr.Bytes
+)
 
-func CalculateKZGParam(ctx context.Context, bucket string, droplets []lubyTransform.LTBlock, s3Client *s3.Client) {
-	var allDropletsData []byte
-	for _, droplet := range droplets {
-		allDropletsData = append(allDropletsData, droplet.Data...)
-	}
-	seedHash := gthCrypto.Keccak256(allDropletsData)
-
-	// Seed is deprecated: As of Go 1.20 there is no reason to call Seed with a random value. Programs that call Seed with a known value to get a specific sequence of results should use New(NewSource(seed)) to obtain a local random generator.deprecateddefault
-	rand := rand.New(rand.NewSource(Slice2Int64(seedHash)))
-	rand.Seed(Slice2Int64(seedHash))
-	// Seed the PRNG
-
-	// Convert each droplet into a big.Int based on its data (simplified approach)
-	var roots []*big.Int
-	for _, droplet := range droplets {
-		// Here, we use the hash of the droplet data as a root. Adjust according to your actual data structure.
-		hash := gthCrypto.Keccak256(droplet.Data)
-		bHash := new(big.Int).SetBytes(hash)
-		roots = append(roots, bHash)
-	}
+// insecureSecret is the toxic waste of InsecureSetup.
+var insecureSecret = big.NewInt(42)
 
-	serializedRoots, err := SerializeRoots(roots)
+// Setup creates an SRS for polynomials of up to size coefficients from
+// secret. Anyone who knows secret can forge proofs.
+func Setup(size uint64, secret *big.Int) (*SRS, error) {
+	srs, err := gkzg.NewSRS(size, secret)
 	if err != nil {
-		log.Fatalf("Failed to serialize roots: %v", err)
+		return nil, fmt.Errorf("failed to create SRS of size %d: %w", size, err)
 	}
+	return srs, nil
+}
 
-	// Uplaod roots to S3
-	UploadToS3(ctx, s3Client, bucket, "kzg-roots.dat", serializedRoots)
-
-	p := poly.NewPolynomialWithRootsFromArray(roots)
-
-	// Generate TrustedSetup
-	ts, err := kzg.NewTrustedSetup(len(p.Coefficients))
-	if err != nil {
-		log.Fatalf("Failed to generate TrustedSetup: %v", err)
-	}
+// InsecureSetup is Setup with a fixed, public secret. It is only fit for
+// experiments.
+func InsecureSetup(size uint64) (*SRS, error) {
+	return Setup(size, insecureSecret)
+}
 
-	serializedTS, err := SerializeTrustedSetup(ts)
-	if err != nil {
-		log.Fatalf("Failed to serialize TrustedSetup: %v", err)
-	}
+// Commit commits to the polynomial with the given coefficients.
+func Commit(coefficients []fr.Element, srs *SRS) (Digest, error) {
+	return gkzg.Commit(coefficients, srs.Pk)
+}
 
-	// Upload TrustedSetup to S3
-	UploadToS3(ctx, s3Client, bucket, "trusted_setup.dat", serializedTS)
+// Open proves the evaluation of the polynomial at point.
+func Open(coefficients []fr.Element, point fr.Element, srs *SRS) (OpeningProof, error) {
+	return gkzg.Open(coefficients, point, srs.Pk)
+}
 
-	// Generate commitments
-	c := kzg.Commit(ts, p.Coefficients)
-	serializedCommitments, err := SerializeG1Point(c)
-	if err != nil {
-		log.Fatalf("Failed to serialize commitments: %v", err)
+// Verify checks that proof opens digest to proof.ClaimedValue at point.
+// Failures wrap utils.ErrProofInvalid.
+func Verify(digest Digest, proof OpeningProof, point fr.Element, vk VerifyingKey) error {
+	if err := gkzg.Verify(&digest, &proof, point, vk); err != nil {
+		return fmt.Errorf("%v: %w", err, utils.ErrProofInvalid)
 	}
+	return nil
+}
 
-	// Upload commitments to S3
-	UploadToS3(ctx, s3Client, bucket, "commitments.dat", serializedCommitments)
-
-	var proofs []*bn256.G1
-	for _, root := range roots {
-		z := root
-		y := big.NewInt(0)
+// BatchOpen proves the evaluations of several polynomials at one point with
+// a single quotient commitment. The folding challenge is derived with
+// SHA-256 from the digests and evaluations.
+func BatchOpen(polynomials [][]fr.Element, digests []Digest, point fr.Element, srs *SRS) (BatchOpeningProof, error) {
+	return gkzg.BatchOpenSinglePoint(polynomials, digests, point, sha256.New(), srs.Pk)
+}
 
-		proof, err := kzg.EvaluationProof(ts, p.Coefficients, z, y)
-		if err != nil {
-			log.Fatalf("Failed to generate evaluation proof: %v", err)
-		}
-		proofs = append(proofs, proof)
+// BatchVerify checks a proof created by BatchOpen. Failures wrap
+// utils.ErrProofInvalid.
+func BatchVerify(digests []Digest, proof BatchOpeningProof, point fr.Element, vk VerifyingKey) error {
+	if err := gkzg.BatchVerifySinglePoint(digests, &proof, point, sha256.New(), vk); err != nil {
+		return fmt.Errorf("%v: %w", err, utils.ErrProofInvalid)
 	}
+	return nil
+}
 
-	serializedProofs, err := SerializeG1Points(proofs)
-	if err != nil {
-		log.Fatalf("Failed to serialize proofs: %v", err)
+// VerifyOpenings checks openings at different points with one multi-pairing
+// over a random linear combination of them. Failures wrap
+// utils.ErrProofInvalid but do not say which opening is wrong.
+func VerifyOpenings(digests []Digest, proofs []OpeningProof, points []fr.Element, vk VerifyingKey) error {
+	if err := gkzg.BatchVerifyMultiPoints(digests, proofs, points, vk); err != nil {
+		return fmt.Errorf("%v: %w", err, utils.ErrProofInvalid)
 	}
-
-	// Upload proofs to S3
-	UploadToS3(ctx, s3Client, bucket, "proofs.dat", serializedProofs)
-
+	return nil
 }
 
-func Verification(ctx context.Context, bucketName string) {
-
-	startTime := time.Now()
-	// Download roots from S3
-	roots, err := utils.DownloadFromS3(ctx, bucketName, "kzg-roots.dat")
-	if err != nil {
-		log.Fatalf("Failed to download roots: %v", err)
+// MarshalSRS encodes srs with compressed points.
+func MarshalSRS(srs *SRS) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := srs.WriteTo(&buf); err != nil {
+		return nil, fmt.Errorf("failed to encode SRS: %w", err)
 	}
+	return buf.Bytes(), nil
+}
 
-	// Download TrustedSetup from S3
-	trustedSetup, err := utils.DownloadFromS3(ctx, bucketName, "trusted_setup.dat")
-	if err != nil {
-		log.Fatalf("Failed to download TrustedSetup: %v", err)
+// UnmarshalSRS decodes an SRS written by MarshalSRS, checking that every
+// point is on the curve and in the right subgroup.
+func UnmarshalSRS(data []byte) (*SRS, error) {
+	var srs SRS
+	if _, err := srs.ReadFrom(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to decode SRS: %w", err)
 	}
+	return &srs, nil
+}
 
-	// Download commitments from S3
-	commitments, err := utils.DownloadFromS3(ctx, bucketName, "commitments.dat")
-	if err != nil {
-		log.Fatalf("Failed to download commitments: %v", err)
-	}
+// MarshalDigest encodes a digest as a compressed point.
+func MarshalDigest(digest Digest) []byte {
+	b := digest.Bytes()
+	return b[:]
+}
 
-	// Download proofs from S3
-	proofs, err := utils.DownloadFromS3(ctx, bucketName, "proofs.dat")
-	if err != nil {
-		log.Fatalf("Failed to download proofs: %v", err)
+func UnmarshalDigest(data []byte) (Digest, error) {
+	var digest Digest
+	if len(data) != DigestSize {
+		return Digest{}, fmt.Errorf("digest has %d bytes, want %d", len(data), DigestSize)
 	}
-
-	// Deserialize roots
-	rootsInts, err := DeserializeRoots(roots)
-	if err != nil {
-		log.Fatalf("Failed to deserialize roots: %v", err)
+	if _, err := digest.SetBytes(data); err != nil {
+		return Digest{}, fmt.Errorf("failed to decode digest: %w", err)
 	}
+	return digest, nil
+}
 
-	// Deserialize TrustedSetup
-	ts, err := DeserializeTrustedSetup(trustedSetup)
-	if err != nil {
-		log.Fatalf("Failed to deserialize TrustedSetup: %v", err)
-	}
+// MarshalOpeningProof encodes the quotient commitment followed by the
+// claimed value, big-endian.
+func MarshalOpeningProof(proof OpeningProof) []byte {
+	h := proof.H.Bytes()
+	v := proof.ClaimedValue.Bytes()
+	return append(h[:], v[:]...)
+}
 
-	// Deserialize commitments
-	commitmentsG1, err := DeserializeG1Point(commitments)
-	if err != nil {
-		log.Fatalf("Failed to deserialize commitments: %v", err)
+func UnmarshalOpeningProof(data []byte) (OpeningProof, error) {
+	if len(data) != OpeningProofSize {
+		return OpeningProof{}, fmt.Errorf("opening proof has %d bytes, want %d", len(data), OpeningProofSize)
 	}
-
-	// Deserialize proofs
-	proofsG1, err := DeserializeG1Points(proofs)
-	if err != nil {
-		log.Fatalf("Failed to deserialize proofs: %v", err)
+	var proof OpeningProof
+	if _, err := proof.H.SetBytes(data[:bn254.SizeOfG1AffineCompressed]); err != nil {
+		return OpeningProof{}, fmt.Errorf("failed to decode opening proof: %w", err)
 	}
-	fmt.Println("Time to download and deserialize: ", time.Since(startTime))
-	// Verify proofs
-	for i, proof := range proofsG1 {
-		z := rootsInts[i]
-		y := big.NewInt(0)
-		if !kzg.Verify(ts, commitmentsG1, proof, z, y) {
-			log.Fatalf("Failed to verify proof")
-		}
+	if err := proof.ClaimedValue.SetBytesCanonical(data[bn254.SizeOfG1AffineCompressed:]); err != nil {
+		return OpeningProof{}, fmt.Errorf("failed to decode opening proof: %w", err)
 	}
+	return proof, nil
 }
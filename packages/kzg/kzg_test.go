@@ -0,0 +1,124 @@
+package kzg
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+func testPolynomial(t *testing.T, n int) ([]fr.Element, *SRS) {
+	t.Helper()
+	coefficients := make([]fr.Element, n)
+	for i := range coefficients {
+		coefficients[i].SetUint64(uint64(3*i*i + 7))
+	}
+	srs, err := InsecureSetup(uint64(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return coefficients, srs
+}
+
+func TestOpen(t *testing.T) {
+	coefficients, srs := testPolynomial(t, 16)
+	digest, err := Commit(coefficients, srs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var point fr.Element
+	point.SetUint64(11)
+	proof, err := Open(coefficients, point, srs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := evaluate(coefficients, point); !proof.ClaimedValue.Equal(&want) {
+		t.Fatal("claimed value is not the evaluation")
+	}
+	if err := Verify(digest, proof, point, srs.Vk); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := proof
+	tampered.ClaimedValue.SetUint64(5)
+	if err := Verify(digest, tampered, point, srs.Vk); !errors.Is(err, utils.ErrProofInvalid) {
+		t.Fatalf("altered value: %v", err)
+	}
+	var other fr.Element
+	other.SetUint64(12)
+	if err := Verify(digest, proof, other, srs.Vk); !errors.Is(err, utils.ErrProofInvalid) {
+		t.Fatalf("other point: %v", err)
+	}
+}
+
+func TestOpenMulti(t *testing.T) {
+	coefficients, srs := testPolynomial(t, 32)
+	digest, err := Commit(coefficients, srs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewMultiVerifyingKey(srs, insecureG2Powers(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := make([]fr.Element, 6)
+	for i := range points {
+		points[i].SetUint64(uint64(100 + 17*i))
+	}
+	proof, err := OpenMulti(coefficients, points, srs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range points {
+		if want := evaluate(coefficients, points[i]); !proof.ClaimedValues[i].Equal(&want) {
+			t.Fatalf("claimed value %d is not the evaluation", i)
+		}
+	}
+	if err := VerifyMulti(digest, proof, points, key); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := MultiOpeningProof{H: proof.H, ClaimedValues: append([]fr.Element(nil), proof.ClaimedValues...)}
+	tampered.ClaimedValues[4].SetUint64(1)
+	if err := VerifyMulti(digest, tampered, points, key); !errors.Is(err, utils.ErrProofInvalid) {
+		t.Fatalf("altered value: %v", err)
+	}
+	if err := VerifyMulti(digest, proof, points[:5], key); !errors.Is(err, utils.ErrProofInvalid) {
+		t.Fatalf("fewer points than values: %v", err)
+	}
+	many := make([]fr.Element, 9)
+	for i := range many {
+		many[i].SetUint64(uint64(i + 1))
+	}
+	proof, err = OpenMulti(coefficients, many, srs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMulti(digest, proof, many, key); !errors.Is(err, ErrTooManyPoints) {
+		t.Fatalf("more points than the key supports: %v", err)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	coefficients, _ := testPolynomial(t, 5)
+	points := make([]fr.Element, 5)
+	values := make([]fr.Element, 5)
+	for i := range points {
+		points[i].SetUint64(uint64(2*i + 1))
+		values[i] = evaluate(coefficients, points[i])
+	}
+	interpolant, err := Interpolate(points, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range coefficients {
+		if !interpolant[i].Equal(&coefficients[i]) {
+			t.Fatalf("coefficient %d differs", i)
+		}
+	}
+	points[3] = points[1]
+	if _, err := Interpolate(points, values); err == nil {
+		t.Fatal("interpolated through a repeated point")
+	}
+}
@@ -0,0 +1,181 @@
+package kzg
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+// ChunkSize is the number of droplet bytes per field element under
+// utils.CommitmentKZGData; 31 bytes always fit below the BN254 scalar modulus.
+const ChunkSize = 31
+
+// DropletLayout places the committed values of the droplets on the
+// evaluation domain. Every droplet contributes Chunks values: one hash
+// under utils.CommitmentKZG, its padded data in ChunkSize pieces under
+// utils.CommitmentKZGData. With D the droplet domain, value j of droplet i
+// sits at ω^(i + j·|D|), so a droplet's values fill a coset of the subgroup
+// of order Chunks.
+type DropletLayout struct {
+	Scheme      string
+	DropletSize int
+
+	droplets *fft.Domain
+	domain   *fft.Domain
+	chunks   int
+}
+
+// NewDropletLayout lays out encodedBlockIDs droplets of at most dropletSize
+// bytes under scheme. dropletSize is ignored for utils.CommitmentKZG.
+func NewDropletLayout(scheme string, encodedBlockIDs, dropletSize int) (*DropletLayout, error) {
+	chunks, err := chunksPerDroplet(scheme, dropletSize)
+	if err != nil {
+		return nil, err
+	}
+	l := &DropletLayout{Scheme: scheme, droplets: DropletDomain(encodedBlockIDs), chunks: chunks}
+	if scheme == utils.CommitmentKZGData {
+		l.DropletSize = dropletSize
+	}
+	l.domain = fft.NewDomain(l.droplets.Cardinality * uint64(chunks))
+	return l, nil
+}
+
+func chunksPerDroplet(scheme string, dropletSize int) (int, error) {
+	switch scheme {
+	case utils.CommitmentKZG:
+		return 1, nil
+	case utils.CommitmentKZGData:
+		if dropletSize <= 0 {
+			return 0, fmt.Errorf("droplet size %d", dropletSize)
+		}
+		// One more byte for the padding marker, rounded up to a power of
+		// two so that the chunks of a droplet form a coset.
+		chunks := (dropletSize + 1 + ChunkSize - 1) / ChunkSize
+		return 1 << bits.Len(uint(chunks-1)), nil
+	default:
+		return 0, fmt.Errorf("unknown droplet commitment %q", scheme)
+	}
+}
+
+// MaxDropletSize is the length of the longest droplet; LT droplets differ
+// by at most a byte.
+func MaxDropletSize(droplets []lubyTransform.LTBlock) int {
+	size := 0
+	for _, droplet := range droplets {
+		size = max(size, len(droplet.Data))
+	}
+	return size
+}
+
+// DropletLayoutForSetup returns the layout of a published commitment.
+func DropletLayoutForSetup(setup *utils.CommitmentSetup, encodedBlockIDs int) (*DropletLayout, error) {
+	l, err := NewDropletLayout(setup.Scheme, encodedBlockIDs, setup.DropletSize)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, utils.ErrInvalidSetup)
+	}
+	return l, nil
+}
+
+// Size is the number of coefficients of the committed polynomial, which
+// the SRS must cover.
+func (l *DropletLayout) Size() uint64 {
+	return l.domain.Cardinality
+}
+
+// Chunks is the number of values each droplet is committed as.
+func (l *DropletLayout) Chunks() int {
+	return l.chunks
+}
+
+// Values returns the committed values of a droplet's data.
+func (l *DropletLayout) Values(data []byte) ([]fr.Element, error) {
+	if l.Scheme == utils.CommitmentKZG {
+		return []fr.Element{DropletHash(data)}, nil
+	}
+	if len(data) > l.DropletSize {
+		return nil, fmt.Errorf("droplet of %d bytes, the commitment holds %d", len(data), l.DropletSize)
+	}
+	// data, a 0x01 marker and zeros: droplets that differ only in trailing
+	// zeros still commit to different values.
+	padded := make([]byte, l.chunks*ChunkSize)
+	copy(padded, data)
+	padded[len(data)] = 1
+	values := make([]fr.Element, l.chunks)
+	for j := range values {
+		values[j].SetBytes(padded[j*ChunkSize : (j+1)*ChunkSize])
+	}
+	return values, nil
+}
+
+// Chunk returns chunk j of a droplet's data as committed under
+// utils.CommitmentKZGData.
+func (l *DropletLayout) Chunk(data []byte, j int) (fr.Element, error) {
+	if l.Scheme != utils.CommitmentKZGData {
+		return fr.Element{}, fmt.Errorf("droplets are committed as %s, not in chunks", l.Scheme)
+	}
+	if j < 0 || j >= l.chunks {
+		return fr.Element{}, fmt.Errorf("chunk %d of %d", j, l.chunks)
+	}
+	values, err := l.Values(data)
+	if err != nil {
+		return fr.Element{}, err
+	}
+	return values[j], nil
+}
+
+// index is the position in the domain of value j of the droplet with
+// blockCode.
+func (l *DropletLayout) index(blockCode int64, j int) (uint64, error) {
+	if blockCode < 0 || uint64(blockCode) >= l.droplets.Cardinality {
+		return 0, fmt.Errorf("block code %d outside a domain of %d droplets", blockCode, l.droplets.Cardinality)
+	}
+	if j < 0 || j >= l.chunks {
+		return 0, fmt.Errorf("chunk %d of %d", j, l.chunks)
+	}
+	return uint64(blockCode) + uint64(j)*l.droplets.Cardinality, nil
+}
+
+// point is the evaluation point of value j of the droplet with blockCode.
+func (l *DropletLayout) point(blockCode int64, j int) (fr.Element, error) {
+	index, err := l.index(blockCode, j)
+	if err != nil {
+		return fr.Element{}, err
+	}
+	var point fr.Element
+	point.Exp(l.domain.Generator, new(big.Int).SetUint64(index))
+	return point, nil
+}
+
+// points returns the evaluation points of every value of the droplets with
+// BlockCodes in [start, end), droplet by droplet.
+func (l *DropletLayout) points(start, end int64) ([]fr.Element, error) {
+	if start >= end {
+		return nil, fmt.Errorf("empty droplet range [%d, %d)", start, end)
+	}
+	if _, err := l.index(end-1, 0); err != nil {
+		return nil, err
+	}
+	// Steps between droplets and between the chunks of one droplet.
+	var step, chunkStep fr.Element
+	step.Set(&l.domain.Generator)
+	chunkStep.Exp(l.domain.Generator, new(big.Int).SetUint64(l.droplets.Cardinality))
+	first, err := l.point(start, 0)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]fr.Element, 0, int(end-start)*l.chunks)
+	for code := start; code < end; code++ {
+		p := first
+		for j := 0; j < l.chunks; j++ {
+			points = append(points, p)
+			p.Mul(&p, &chunkStep)
+		}
+		first.Mul(&first, &step)
+	}
+	return points, nil
+}
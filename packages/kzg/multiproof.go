@@ -0,0 +1,281 @@
+package kzg
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+// A multi-opening proves p(zᵢ) = yᵢ for a set of k points with one group
+// element. With Z(X) = ∏(X - zᵢ) the vanishing polynomial of the points and
+// I(X) the polynomial of degree < k through (zᵢ, yᵢ), p - I is divisible by
+// Z and the proof is a commitment to q = (p - I) / Z. The verifier checks
+//
+//	e([p(τ)]G₁ - [I(τ)]G₁, G₂) = e([q(τ)]G₁, [Z(τ)]G₂)
+//
+// which needs τ up to k in G₂, more than an SRS carries.
+
+var ErrTooManyPoints = errors.New("more points than the multi-opening key supports")
+
+// DefaultMaxRangePoints bounds the points of one multi-opening. The
+// Ethereum ceremony publishes 65 powers in G2, enough for 64 points.
+const DefaultMaxRangePoints = 64
+
+// MultiVerifyingKey verifies multi-openings at up to MaxPoints points: it
+// holds [τʲ]G₁ for j < MaxPoints and [τʲ]G₂ for j ≤ MaxPoints.
+type MultiVerifyingKey struct {
+	G1 []bn254.G1Affine
+	G2 []bn254.G2Affine
+}
+
+// NewMultiVerifyingKey combines the G1 powers of srs with g2Powers.
+func NewMultiVerifyingKey(srs *SRS, g2Powers []bn254.G2Affine) (*MultiVerifyingKey, error) {
+	if len(g2Powers) < 2 {
+		return nil, fmt.Errorf("%d G2 powers, need at least 2", len(g2Powers))
+	}
+	if !g2Powers[0].Equal(&srs.Vk.G2[0]) || !g2Powers[1].Equal(&srs.Vk.G2[1]) {
+		return nil, errors.New("G2 powers do not belong to the SRS")
+	}
+	maxPoints := len(g2Powers) - 1
+	if len(srs.Pk.G1) < maxPoints {
+		return nil, fmt.Errorf("SRS has %d G1 powers, need %d", len(srs.Pk.G1), maxPoints)
+	}
+	return &MultiVerifyingKey{G1: srs.Pk.G1[:maxPoints], G2: g2Powers}, nil
+}
+
+// MaxPoints is the largest set of points the key verifies openings at.
+func (k *MultiVerifyingKey) MaxPoints() int {
+	return len(k.G2) - 1
+}
+
+// VerifyG2Powers checks that g2Powers are consecutive powers of the secret
+// of srs: e(G₁, τʲ⁺¹G₂) = e(τG₁, τʲG₂) for every j, checked together over a
+// random linear combination.
+func VerifyG2Powers(srs *SRS, g2Powers []bn254.G2Affine) error {
+	if len(g2Powers) < 2 || !g2Powers[0].Equal(&srs.Vk.G2[0]) || !g2Powers[1].Equal(&srs.Vk.G2[1]) {
+		return fmt.Errorf("G2 powers do not start at the SRS: %w", ErrInvalidCeremony)
+	}
+	n := len(g2Powers) - 1
+	r := make([]fr.Element, n)
+	for i := range r {
+		if _, err := r[i].SetRandom(); err != nil {
+			return err
+		}
+	}
+	var lower, upper bn254.G2Affine
+	if _, err := lower.MultiExp(g2Powers[:n], r, ecc.MultiExpConfig{}); err != nil {
+		return err
+	}
+	if _, err := upper.MultiExp(g2Powers[1:], r, ecc.MultiExpConfig{}); err != nil {
+		return err
+	}
+	var negTau bn254.G1Affine
+	negTau.Neg(&srs.Pk.G1[1])
+	ok, err := bn254.PairingCheck([]bn254.G1Affine{srs.Vk.G1, negTau}, []bn254.G2Affine{upper, lower})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("G2 powers are not consecutive powers of the SRS secret: %w", ErrInvalidCeremony)
+	}
+	return nil
+}
+
+// insecureG2Powers returns the first n powers in G2 of the secret of
+// InsecureSetup.
+func insecureG2Powers(n int) []bn254.G2Affine {
+	_, _, _, g2 := bn254.Generators()
+	powers := make([]bn254.G2Affine, n)
+	power := big.NewInt(1)
+	for i := range powers {
+		powers[i].ScalarMultiplication(&g2, power)
+		power.Mul(power, insecureSecret)
+	}
+	return powers
+}
+
+// MarshalG2Powers concatenates the compressed points.
+func MarshalG2Powers(powers []bn254.G2Affine) []byte {
+	data := make([]byte, 0, len(powers)*bn254.SizeOfG2AffineCompressed)
+	for i := range powers {
+		b := powers[i].Bytes()
+		data = append(data, b[:]...)
+	}
+	return data
+}
+
+func UnmarshalG2Powers(data []byte) ([]bn254.G2Affine, error) {
+	if len(data)%bn254.SizeOfG2AffineCompressed != 0 {
+		return nil, fmt.Errorf("%d bytes of G2 powers is not a whole number of points", len(data))
+	}
+	powers := make([]bn254.G2Affine, len(data)/bn254.SizeOfG2AffineCompressed)
+	for i := range powers {
+		if _, err := powers[i].SetBytes(data[i*bn254.SizeOfG2AffineCompressed:]); err != nil {
+			return nil, fmt.Errorf("failed to decode G2 power %d: %w", i, err)
+		}
+	}
+	return powers, nil
+}
+
+// MultiOpeningProof is the commitment to the quotient and the claimed
+// evaluations, in the order of the points.
+type MultiOpeningProof struct {
+	H             bn254.G1Affine
+	ClaimedValues []fr.Element
+}
+
+// OpenMulti proves the evaluations of the polynomial at points. The points
+// must be distinct.
+func OpenMulti(coefficients []fr.Element, points []fr.Element, srs *SRS) (MultiOpeningProof, error) {
+	if len(points) == 0 {
+		return MultiOpeningProof{}, errors.New("no points to open at")
+	}
+	z := vanishingPolynomial(points)
+	// The remainder of p / Z is I, so q is just the quotient, and p agrees
+	// with the much shorter remainder at the points.
+	q, remainder := dividePolynomial(coefficients, z)
+	proof := MultiOpeningProof{ClaimedValues: make([]fr.Element, len(points))}
+	for i := range points {
+		proof.ClaimedValues[i] = evaluate(remainder, points[i])
+	}
+	if len(q) == 0 {
+		// p has degree below k, so p = I.
+		return proof, nil
+	}
+	h, err := Commit(q, srs)
+	if err != nil {
+		return MultiOpeningProof{}, err
+	}
+	proof.H = h
+	return proof, nil
+}
+
+// VerifyMulti checks that proof opens digest to proof.ClaimedValues at
+// points. Failures wrap utils.ErrProofInvalid.
+func VerifyMulti(digest Digest, proof MultiOpeningProof, points []fr.Element, key *MultiVerifyingKey) error {
+	k := len(points)
+	if k == 0 || len(proof.ClaimedValues) != k {
+		return fmt.Errorf("%d claimed values for %d points: %w", len(proof.ClaimedValues), k, utils.ErrProofInvalid)
+	}
+	if k > key.MaxPoints() {
+		return fmt.Errorf("%d points, key supports %d: %w", k, key.MaxPoints(), ErrTooManyPoints)
+	}
+	interpolant, err := Interpolate(points, proof.ClaimedValues)
+	if err != nil {
+		return fmt.Errorf("%v: %w", err, utils.ErrProofInvalid)
+	}
+	z := vanishingPolynomial(points)
+
+	var iCommit bn254.G1Affine
+	if _, err := iCommit.MultiExp(key.G1[:k], interpolant, ecc.MultiExpConfig{}); err != nil {
+		return err
+	}
+	var zCommit bn254.G2Affine
+	if _, err := zCommit.MultiExp(key.G2[:k+1], z, ecc.MultiExpConfig{}); err != nil {
+		return err
+	}
+
+	// e([p(τ)]G₁ - [I(τ)]G₁, G₂) · e(-[q(τ)]G₁, [Z(τ)]G₂) = 1
+	var lhs, negH bn254.G1Affine
+	lhs.Sub(&digest, &iCommit)
+	negH.Neg(&proof.H)
+	ok, err := bn254.PairingCheck([]bn254.G1Affine{lhs, negH}, []bn254.G2Affine{key.G2[0], zCommit})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("multi-opening at %d points: %w", k, utils.ErrProofInvalid)
+	}
+	return nil
+}
+
+// vanishingPolynomial returns the coefficients of ∏(X - zᵢ), lowest first.
+func vanishingPolynomial(points []fr.Element) []fr.Element {
+	z := make([]fr.Element, 1, len(points)+1)
+	z[0].SetOne()
+	for i := range points {
+		z = append(z, fr.Element{})
+		// z ← z·X - zᵢ·z
+		for j := len(z) - 1; j > 0; j-- {
+			var t fr.Element
+			t.Mul(&z[j], &points[i])
+			z[j].Sub(&z[j-1], &t)
+		}
+		z[0].Mul(&z[0], &points[i]).Neg(&z[0])
+	}
+	return z
+}
+
+// dividePolynomial divides p by the monic polynomial d and returns the
+// quotient and the remainder.
+func dividePolynomial(p, d []fr.Element) (quotient, remainder []fr.Element) {
+	k := len(d) - 1
+	if len(p) <= k {
+		return nil, append([]fr.Element{}, p...)
+	}
+	// Vanishing polynomials of cosets are X^k - a, so only the non-zero
+	// terms of d below the leading one are worth visiting.
+	var terms []int
+	for j := 0; j < k; j++ {
+		if !d[j].IsZero() {
+			terms = append(terms, j)
+		}
+	}
+	r := append([]fr.Element{}, p...)
+	quotient = make([]fr.Element, len(p)-k)
+	for i := len(p) - 1; i >= k; i-- {
+		c := r[i]
+		quotient[i-k] = c
+		r[i].SetZero()
+		for _, j := range terms {
+			var t fr.Element
+			t.Mul(&c, &d[j])
+			r[i-k+j].Sub(&r[i-k+j], &t)
+		}
+	}
+	return quotient, r[:k]
+}
+
+// Interpolate returns the coefficients of the polynomial of degree below
+// len(points) through (points[i], values[i]).
+func Interpolate(points, values []fr.Element) ([]fr.Element, error) {
+	k := len(points)
+	z := vanishingPolynomial(points)
+	numerators := make([][]fr.Element, k)
+	denominators := make([]fr.Element, k)
+	for i := range points {
+		var root fr.Element
+		root.Neg(&points[i])
+		// Z / (X - zᵢ), exact.
+		numerators[i], _ = dividePolynomial(z, []fr.Element{root, fr.One()})
+		denominators[i] = evaluate(numerators[i], points[i])
+		if denominators[i].IsZero() {
+			return nil, errors.New("points are not distinct")
+		}
+	}
+	inverses := fr.BatchInvert(denominators)
+	result := make([]fr.Element, k)
+	for i := range points {
+		var scale fr.Element
+		scale.Mul(&values[i], &inverses[i])
+		for j := range numerators[i] {
+			var t fr.Element
+			t.Mul(&numerators[i][j], &scale)
+			result[j].Add(&result[j], &t)
+		}
+	}
+	return result, nil
+}
+
+func evaluate(p []fr.Element, x fr.Element) fr.Element {
+	var y fr.Element
+	for i := len(p) - 1; i >= 0; i-- {
+		y.Mul(&y, &x).Add(&y, &p[i])
+	}
+	return y
+}
@@ -0,0 +1,120 @@
+package kzg
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+var insecureConfig = SRSConfig{Insecure: true}
+
+func testDroplets(n, size int) []lubyTransform.LTBlock {
+	random := rand.New(rand.NewSource(1))
+	droplets := make([]lubyTransform.LTBlock, n)
+	for i := range droplets {
+		droplets[i].BlockCode = int64(i)
+		droplets[i].Data = make([]byte, size)
+		random.Read(droplets[i].Data)
+	}
+	return droplets
+}
+
+// testDropletSetup commits to droplets under scheme with the insecure
+// setup and returns the prover and the setup record a verifier reads.
+func testDropletSetup(t *testing.T, scheme string, droplets []lubyTransform.LTBlock) (*DropletProver, *utils.CommitmentSetup) {
+	t.Helper()
+	layout, err := NewDropletLayout(scheme, len(droplets), MaxDropletSize(droplets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srs, err := LoadSRS(insecureConfig, layout.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	multi, err := LoadMultiOpeningKey(insecureConfig, srs, DefaultMaxRangePoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prover, err := NewDropletProver(srs, layout, len(droplets), droplets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serializedSRS, err := MarshalSRS(srs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := prover.Digest.Bytes()
+	return prover, &utils.CommitmentSetup{
+		Scheme:      scheme,
+		SRS:         serializedSRS,
+		G2Powers:    MarshalG2Powers(multi.G2),
+		DropletSize: layout.DropletSize,
+		Digest:      digest[:],
+	}
+}
+
+func TestRangeProof(t *testing.T) {
+	for _, scheme := range []string{utils.CommitmentKZG, utils.CommitmentKZGData} {
+		t.Run(scheme, func(t *testing.T) {
+			droplets := testDroplets(16, 70)
+			prover, setup := testDropletSetup(t, scheme, droplets)
+			verifier, err := DropletVerifierForSetup(insecureConfig, setup, len(droplets))
+			if err != nil {
+				t.Fatal(err)
+			}
+			proof, err := prover.OpenRange(4, 12)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := utils.RangeProof{Start: 4, End: 12, Proof: proof}
+			if err := verifier.VerifyRange(r, droplets[4:12]); err != nil {
+				t.Fatal(err)
+			}
+
+			tampered := append([]lubyTransform.LTBlock(nil), droplets[4:12]...)
+			tampered[3].Data = append([]byte(nil), tampered[3].Data...)
+			tampered[3].Data[0] ^= 1
+			if err := verifier.VerifyRange(r, tampered); !errors.Is(err, utils.ErrProofInvalid) {
+				t.Fatalf("tampered droplet: %v", err)
+			}
+			if err := verifier.VerifyRange(utils.RangeProof{Start: 5, End: 13, Proof: proof}, droplets[5:13]); !errors.Is(err, utils.ErrProofInvalid) {
+				t.Fatalf("proof for another range: %v", err)
+			}
+			if err := verifier.VerifyRange(r, droplets[4:11]); !errors.Is(err, utils.ErrProofInvalid) {
+				t.Fatalf("range missing a droplet: %v", err)
+			}
+		})
+	}
+}
+
+func TestTamperedG2Powers(t *testing.T) {
+	droplets := testDroplets(16, 70)
+	_, setup := testDropletSetup(t, utils.CommitmentKZGData, droplets)
+	if _, _, _, err := ParametersForSetup(insecureConfig, setup, len(droplets)); err != nil {
+		t.Fatal(err)
+	}
+
+	g2Powers, err := UnmarshalG2Powers(setup.G2Powers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tamper := range map[string]func(p []bn254.G2Affine){
+		"swapped":   func(p []bn254.G2Affine) { p[3], p[4] = p[4], p[3] },
+		"doubled":   func(p []bn254.G2Affine) { p[5].Add(&p[5], &p[5]) },
+		"generator": func(p []bn254.G2Affine) { p[1] = p[0] },
+	} {
+		t.Run(name, func(t *testing.T) {
+			forged := append([]bn254.G2Affine(nil), g2Powers...)
+			tamper(forged)
+			tampered := *setup
+			tampered.G2Powers = MarshalG2Powers(forged)
+			if _, _, _, err := ParametersForSetup(insecureConfig, &tampered, len(droplets)); !errors.Is(err, utils.ErrInvalidSetup) {
+				t.Fatalf("got %v, want ErrInvalidSetup", err)
+			}
+		})
+	}
+}
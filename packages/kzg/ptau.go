@@ -0,0 +1,172 @@
+package kzg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+)
+
+// Sections of a snarkjs .ptau file used here. The file starts with
+// ptauMagic, a version and the number of sections; each section is its
+// type, its size and its contents.
+const (
+	ptauMagic         = "ptau"
+	ptauSectionHeader = 1
+	ptauSectionTauG1  = 2
+	ptauSectionTauG2  = 3
+)
+
+// ptauFieldSize is the size of a BN254 base field element in a .ptau file.
+const ptauFieldSize = fp.Bytes
+
+// readPtau reads the first g1 powers of tau in G1 and up to g2 in G2 from
+// the .ptau file at path, which must hold at least minG1 powers in G1. Points
+// are stored uncompressed, each coordinate little-endian in Montgomery form,
+// which is also how fp.Element keeps its limbs. Only the powers needed are
+// read, so large files from the Perpetual Powers of Tau ceremony can be used
+// as they are.
+func readPtau(path string, minG1, g1, g2 int) ([]bn254.G1Affine, []bn254.G2Affine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read ceremony transcript: %w", err)
+	}
+	defer f.Close()
+	sections, err := ptauSections(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header, ok := sections[ptauSectionHeader]
+	if !ok {
+		return nil, nil, fmt.Errorf("ptau file has no header section: %w", ErrInvalidCeremony)
+	}
+	buf := make([]byte, 4+ptauFieldSize+4)
+	if header.size < int64(len(buf)) {
+		return nil, nil, fmt.Errorf("ptau header section of %d bytes: %w", header.size, ErrInvalidCeremony)
+	}
+	if _, err := f.ReadAt(buf, header.offset); err != nil {
+		return nil, nil, fmt.Errorf("failed to read ptau header: %w", err)
+	}
+	if n8 := binary.LittleEndian.Uint32(buf); n8 != ptauFieldSize {
+		return nil, nil, fmt.Errorf("ptau field elements of %d bytes, BN254 needs %d: %w", n8, ptauFieldSize, ErrInvalidCeremony)
+	}
+	if q := new(big.Int).SetBytes(reversed(buf[4 : 4+ptauFieldSize])); q.Cmp(fp.Modulus()) != 0 {
+		return nil, nil, fmt.Errorf("ptau file is not over BN254: %w", ErrInvalidCeremony)
+	}
+	power := binary.LittleEndian.Uint32(buf[4+ptauFieldSize:])
+	if power > 30 {
+		return nil, nil, fmt.Errorf("ptau file of power %d: %w", power, ErrInvalidCeremony)
+	}
+	// A file of power p holds 2^(p+1)-1 powers in G1 and 2^p in G2.
+	numG1, numG2 := 1<<(power+1)-1, 1<<power
+	if numG1 < minG1 {
+		return nil, nil, fmt.Errorf("ptau file has %d G1 powers, %d are needed: %w", numG1, minG1, ErrInvalidCeremony)
+	}
+
+	g1Powers := make([]bn254.G1Affine, g1)
+	if err := readPtauPoints(f, sections[ptauSectionTauG1], numG1, 2*ptauFieldSize, len(g1Powers), func(i int, b []byte) error {
+		p := &g1Powers[i]
+		if err := setPtauElements(b, &p.X, &p.Y); err != nil {
+			return fmt.Errorf("G1 power %d: %w", i, err)
+		}
+		if !p.IsOnCurve() || !p.IsInSubGroup() {
+			return fmt.Errorf("G1 power %d is not in the subgroup: %w", i, ErrInvalidCeremony)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	g2Powers := make([]bn254.G2Affine, min(g2, numG2))
+	if err := readPtauPoints(f, sections[ptauSectionTauG2], numG2, 4*ptauFieldSize, len(g2Powers), func(i int, b []byte) error {
+		p := &g2Powers[i]
+		if err := setPtauElements(b, &p.X.A0, &p.X.A1, &p.Y.A0, &p.Y.A1); err != nil {
+			return fmt.Errorf("G2 power %d: %w", i, err)
+		}
+		if !p.IsOnCurve() || !p.IsInSubGroup() {
+			return fmt.Errorf("G2 power %d is not in the subgroup: %w", i, ErrInvalidCeremony)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	return g1Powers, g2Powers, nil
+}
+
+type ptauSection struct {
+	offset, size int64
+}
+
+// ptauSections checks the file header and locates every section.
+func ptauSections(f *os.File) (map[uint32]ptauSection, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var header [12]byte
+	if _, err := f.ReadAt(header[:], 0); err != nil || string(header[:4]) != ptauMagic {
+		return nil, fmt.Errorf("not a ptau file: %w", ErrInvalidCeremony)
+	}
+	count := binary.LittleEndian.Uint32(header[8:])
+	sections := make(map[uint32]ptauSection, count)
+	offset := int64(len(header))
+	for i := uint32(0); i < count; i++ {
+		var b [12]byte
+		if _, err := f.ReadAt(b[:], offset); err != nil {
+			return nil, fmt.Errorf("ptau section %d: %v: %w", i, err, ErrInvalidCeremony)
+		}
+		section := ptauSection{offset: offset + int64(len(b)), size: int64(binary.LittleEndian.Uint64(b[4:]))}
+		if section.size < 0 || section.size > info.Size()-section.offset {
+			return nil, fmt.Errorf("ptau section %d of %d bytes overruns the file: %w", i, section.size, ErrInvalidCeremony)
+		}
+		sections[binary.LittleEndian.Uint32(b[:4])] = section
+		offset = section.offset + section.size
+	}
+	return sections, nil
+}
+
+// readPtauPoints reads the first n of the total points of pointSize bytes
+// in section and passes each to set.
+func readPtauPoints(f io.ReaderAt, section ptauSection, total, pointSize, n int, set func(i int, b []byte) error) error {
+	if section.size != int64(total)*int64(pointSize) {
+		return fmt.Errorf("ptau section of %d bytes for %d points: %w", section.size, total, ErrInvalidCeremony)
+	}
+	buf := make([]byte, n*pointSize)
+	if _, err := f.ReadAt(buf, section.offset); err != nil {
+		return fmt.Errorf("failed to read ptau points: %w", err)
+	}
+	for i := 0; i < n; i++ {
+		if err := set(i, buf[i*pointSize:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setPtauElements sets each of elements in turn from a little-endian value
+// in Montgomery form, read from b. Values of the modulus or more are not
+// canonical and rejected.
+func setPtauElements(b []byte, elements ...*fp.Element) error {
+	for _, e := range elements {
+		if new(big.Int).SetBytes(reversed(b[:ptauFieldSize])).Cmp(fp.Modulus()) >= 0 {
+			return fmt.Errorf("coordinate is not reduced: %w", ErrInvalidCeremony)
+		}
+		for i := range e {
+			e[i] = binary.LittleEndian.Uint64(b[8*i:])
+		}
+		b = b[ptauFieldSize:]
+	}
+	return nil
+}
+
+func reversed(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
@@ -150,6 +150,18 @@ func (c *lubyCodec) GenerateIntermediateBlocks(message []byte, numBlocks int) []
 	return equalizeBlockLengths(long, short)
 }
 
+// SplitMessage returns the source blocks c splits message into, the blocks
+// its code blocks XOR together. Padding is left off, so the last blocks may
+// be shorter than the others.
+func SplitMessage(message []byte, c Codec) [][]byte {
+	source := c.GenerateIntermediateBlocks(message, c.SourceBlocks())
+	blocks := make([][]byte, len(source))
+	for i := range source {
+		blocks[i] = append([]byte{}, source[i].data...)
+	}
+	return blocks
+}
+
 // generateLubyTransformBlock generates a single code block from the set of
 // source blocks, given the composition indices, by XORing the source blocks
 // together.
@@ -212,7 +224,9 @@ func newLubyDecoder(c *lubyCodec, length int) *lubyDecoder {
 func (d *lubyDecoder) AddBlocks(blocks []LTBlock) bool {
 	for i := range blocks {
 		indices := d.codec.PickIndices(blocks[i].BlockCode)
-		d.matrix.addEquation(indices, block{data: blocks[i].Data})
+		// The matrix XORs rows in place; copy so the caller's blocks survive.
+		data := append([]byte{}, blocks[i].Data...)
+		d.matrix.addEquation(indices, block{data: data})
 	}
 	return d.matrix.determined()
 }
@@ -0,0 +1,414 @@
+package rs2d
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	kzg "github.com/xm0onh/thesis/packages/kzg"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+// Commitments are the KZG commitments to the 2K rows and 2K columns of an
+// extended matrix, each to the polynomial of degree below K through it.
+type Commitments struct {
+	Rows    []kzg.Digest
+	Columns []kzg.Digest
+}
+
+// Commit commits to every row and column of m.
+func (c *Code) Commit(m Matrix, srs *kzg.SRS) (Commitments, error) {
+	commitments := Commitments{Rows: make([]kzg.Digest, 2*c.K), Columns: make([]kzg.Digest, 2*c.K)}
+	for r := range commitments.Rows {
+		digest, err := kzg.Commit(c.Coefficients(m[r]), srs)
+		if err != nil {
+			return Commitments{}, fmt.Errorf("failed to commit to row %d: %w", r, err)
+		}
+		commitments.Rows[r] = digest
+	}
+	column := make([]fr.Element, c.K)
+	for j := range commitments.Columns {
+		for r := range column {
+			column[r] = m[r][j]
+		}
+		digest, err := kzg.Commit(c.Coefficients(column), srs)
+		if err != nil {
+			return Commitments{}, fmt.Errorf("failed to commit to column %d: %w", j, err)
+		}
+		commitments.Columns[j] = digest
+	}
+	return commitments, nil
+}
+
+// CommitMessage encodes message and returns the material for the setup
+// record. The SRS must hold at least K powers.
+func (c *Code) CommitMessage(message []byte, srs *kzg.SRS) (*utils.MatrixSetup, error) {
+	if len(srs.Pk.G1) < c.K {
+		return nil, fmt.Errorf("SRS of size %d cannot commit to rows of %d cells", len(srs.Pk.G1), c.K)
+	}
+	m, err := c.Encode(message)
+	if err != nil {
+		return nil, err
+	}
+	commitments, err := c.Commit(m, srs)
+	if err != nil {
+		return nil, err
+	}
+	serializedSRS, err := kzg.MarshalSRS(srs)
+	if err != nil {
+		return nil, err
+	}
+	return &utils.MatrixSetup{
+		K:       c.K,
+		SRS:     serializedSRS,
+		Rows:    marshalDigests(commitments.Rows),
+		Columns: marshalDigests(commitments.Columns),
+	}, nil
+}
+
+func marshalDigests(digests []kzg.Digest) []byte {
+	var buf bytes.Buffer
+	for _, d := range digests {
+		buf.Write(kzg.MarshalDigest(d))
+	}
+	return buf.Bytes()
+}
+
+func unmarshalDigests(data []byte, n int) ([]kzg.Digest, error) {
+	if len(data) != n*kzg.DigestSize {
+		return nil, fmt.Errorf("%d bytes of commitments for %d lines", len(data), n)
+	}
+	digests := make([]kzg.Digest, n)
+	for i := range digests {
+		digest, err := kzg.UnmarshalDigest(data[i*kzg.DigestSize : (i+1)*kzg.DigestSize])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i, err)
+		}
+		digests[i] = digest
+	}
+	return digests, nil
+}
+
+// parseSetup reads the code, SRS and commitments of a published matrix.
+// The SRS is taken as published.
+func parseSetup(setup *utils.MatrixSetup) (*Code, *kzg.SRS, Commitments, error) {
+	code, err := NewCode(setup.K)
+	if err != nil {
+		return nil, nil, Commitments{}, fmt.Errorf("%v: %w", err, utils.ErrInvalidSetup)
+	}
+	srs, err := kzg.UnmarshalSRS(setup.SRS)
+	if err != nil {
+		return nil, nil, Commitments{}, fmt.Errorf("%v: %w", err, utils.ErrInvalidSetup)
+	}
+	if len(srs.Pk.G1) < code.K {
+		return nil, nil, Commitments{}, fmt.Errorf("SRS of size %d for rows of %d cells: %w", len(srs.Pk.G1), code.K, utils.ErrInvalidSetup)
+	}
+	var commitments Commitments
+	if commitments.Rows, err = unmarshalDigests(setup.Rows, 2*code.K); err != nil {
+		return nil, nil, Commitments{}, fmt.Errorf("row commitments: %v: %w", err, utils.ErrInvalidSetup)
+	}
+	if commitments.Columns, err = unmarshalDigests(setup.Columns, 2*code.K); err != nil {
+		return nil, nil, Commitments{}, fmt.Errorf("column commitments: %v: %w", err, utils.ErrInvalidSetup)
+	}
+	return code, srs, commitments, nil
+}
+
+// Prover rebuilds the extended matrix of a published setup and proves its
+// cells.
+type Prover struct {
+	code   *Code
+	srs    *kzg.SRS
+	matrix Matrix
+}
+
+// NewProverForSetup encodes message, which must be the message the setup
+// committed to. It uses the published SRS as is: cells proven under an
+// untrusted SRS fail at verifiers that check it.
+func NewProverForSetup(setup *utils.MatrixSetup, message []byte) (*Prover, error) {
+	code, srs, published, err := parseSetup(setup)
+	if err != nil {
+		return nil, err
+	}
+	m, err := code.Encode(message)
+	if err != nil {
+		return nil, err
+	}
+	commitments, err := code.Commit(m, srs)
+	if err != nil {
+		return nil, err
+	}
+	for r := range commitments.Rows {
+		if !commitments.Rows[r].Equal(&published.Rows[r]) || !commitments.Columns[r].Equal(&published.Columns[r]) {
+			return nil, fmt.Errorf("message does not match the published commitments: %w", utils.ErrInvalidSetup)
+		}
+	}
+	return &Prover{code: code, srs: srs, matrix: m}, nil
+}
+
+// Code returns the code of the matrix.
+func (p *Prover) Code() *Code {
+	return p.code
+}
+
+// Row returns the cells of row r with their openings against the row's
+// commitment.
+func (p *Prover) Row(r int) ([]utils.Cell, error) {
+	if r < 0 || r >= 2*p.code.K {
+		return nil, fmt.Errorf("row %d outside a matrix of %d rows", r, 2*p.code.K)
+	}
+	coefficients := p.code.Coefficients(p.matrix[r])
+	cells := make([]utils.Cell, 2*p.code.K)
+	for j := range cells {
+		proof, err := kzg.Open(coefficients, p.code.Point(j), p.srs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open cell (%d, %d): %w", r, j, err)
+		}
+		value := p.matrix[r][j].Bytes()
+		h := proof.H.Bytes()
+		cells[j] = utils.Cell{Row: r, Column: j, Value: value[:], Proof: h[:]}
+	}
+	return cells, nil
+}
+
+// Verifier checks cells against the commitments of a published matrix and
+// repairs the matrix from them.
+type Verifier struct {
+	code        *Code
+	srs         *kzg.SRS
+	commitments Commitments
+}
+
+// VerifierForSetup reads a published matrix and checks its SRS against the
+// source config selects.
+func VerifierForSetup(config kzg.SRSConfig, setup *utils.MatrixSetup) (*Verifier, error) {
+	code, _, commitments, err := parseSetup(setup)
+	if err != nil {
+		return nil, err
+	}
+	srs, err := kzg.TrustedSRS(config, setup.SRS)
+	if err != nil {
+		return nil, err
+	}
+	return &Verifier{code: code, srs: srs, commitments: commitments}, nil
+}
+
+// Code returns the code of the matrix.
+func (v *Verifier) Code() *Code {
+	return v.code
+}
+
+// opening parses a stored cell into an opening of its row's commitment.
+func (v *Verifier) opening(cell utils.Cell) (kzg.OpeningProof, fr.Element, error) {
+	if cell.Row < 0 || cell.Row >= 2*v.code.K || cell.Column < 0 || cell.Column >= 2*v.code.K {
+		return kzg.OpeningProof{}, fr.Element{}, fmt.Errorf("cell (%d, %d) outside a %d×%d matrix: %w", cell.Row, cell.Column, 2*v.code.K, 2*v.code.K, utils.ErrProofInvalid)
+	}
+	var proof kzg.OpeningProof
+	if err := proof.ClaimedValue.SetBytesCanonical(cell.Value); err != nil {
+		return kzg.OpeningProof{}, fr.Element{}, fmt.Errorf("cell (%d, %d): malformed value: %v: %w", cell.Row, cell.Column, err, utils.ErrProofInvalid)
+	}
+	if len(cell.Proof) != bn254.SizeOfG1AffineCompressed {
+		return kzg.OpeningProof{}, fr.Element{}, fmt.Errorf("cell (%d, %d): proof of %d bytes: %w", cell.Row, cell.Column, len(cell.Proof), utils.ErrProofInvalid)
+	}
+	if _, err := proof.H.SetBytes(cell.Proof); err != nil {
+		return kzg.OpeningProof{}, fr.Element{}, fmt.Errorf("cell (%d, %d): malformed proof: %v: %w", cell.Row, cell.Column, err, utils.ErrProofInvalid)
+	}
+	return proof, v.code.Point(cell.Column), nil
+}
+
+// VerifyCell checks a cell's opening against its row's commitment. Any
+// failure wraps utils.ErrProofInvalid.
+func (v *Verifier) VerifyCell(cell utils.Cell) error {
+	proof, point, err := v.opening(cell)
+	if err != nil {
+		return err
+	}
+	if err := kzg.Verify(v.commitments.Rows[cell.Row], proof, point, v.srs.Vk); err != nil {
+		return fmt.Errorf("cell (%d, %d): %w", cell.Row, cell.Column, err)
+	}
+	return nil
+}
+
+// cellOpening is a parsed cell and the digest it is checked against.
+type cellOpening struct {
+	cell   utils.Cell
+	digest kzg.Digest
+	proof  kzg.OpeningProof
+	point  fr.Element
+}
+
+// VerifyCells returns the cells whose openings verify and the ones
+// rejected. The openings are checked together with one multi-pairing; if
+// that fails the batch is bisected to find the bad cells, as for droplets.
+func (v *Verifier) VerifyCells(cells []utils.Cell) ([]utils.Cell, []utils.Cell) {
+	var rejected []utils.Cell
+	openings := make([]cellOpening, 0, len(cells))
+	for _, cell := range cells {
+		proof, point, err := v.opening(cell)
+		if err != nil {
+			rejected = append(rejected, cell)
+			continue
+		}
+		openings = append(openings, cellOpening{cell: cell, digest: v.commitments.Rows[cell.Row], proof: proof, point: point})
+	}
+	valid := make([]utils.Cell, 0, len(openings))
+	v.bisect(openings, &valid, &rejected)
+	return valid, rejected
+}
+
+func (v *Verifier) bisect(openings []cellOpening, valid, rejected *[]utils.Cell) {
+	if len(openings) == 0 {
+		return
+	}
+	digests := make([]kzg.Digest, len(openings))
+	proofs := make([]kzg.OpeningProof, len(openings))
+	points := make([]fr.Element, len(openings))
+	for i, o := range openings {
+		digests[i], proofs[i], points[i] = o.digest, o.proof, o.point
+	}
+	if kzg.VerifyOpenings(digests, proofs, points, v.srs.Vk) == nil {
+		for _, o := range openings {
+			*valid = append(*valid, o.cell)
+		}
+		return
+	}
+	if len(openings) == 1 {
+		*rejected = append(*rejected, openings[0].cell)
+		return
+	}
+	mid := len(openings) / 2
+	v.bisect(openings[:mid], valid, rejected)
+	v.bisect(openings[mid:], valid, rejected)
+}
+
+// Repair rebuilds the matrix from verified cells. Any row or column with
+// at least K known cells is completed from them, which may in turn
+// complete lines of the other dimension, until the message quarter is
+// known or no line can be completed. Every completed line is checked
+// against its commitment; a mismatch wraps ErrInconsistentEncoding.
+func (v *Verifier) Repair(cells []utils.Cell) (Matrix, int, error) {
+	n := 2 * v.code.K
+	m := make(Matrix, n)
+	known := make([][]bool, n)
+	for r := range m {
+		m[r] = make([]fr.Element, n)
+		known[r] = make([]bool, n)
+	}
+	for _, cell := range cells {
+		if err := m[cell.Row][cell.Column].SetBytesCanonical(cell.Value); err != nil {
+			return nil, 0, fmt.Errorf("cell (%d, %d): %v: %w", cell.Row, cell.Column, err, utils.ErrProofInvalid)
+		}
+		known[cell.Row][cell.Column] = true
+	}
+
+	repaired := 0
+	line := make([]fr.Element, n)
+	lineKnown := make([]bool, n)
+	for progress := true; progress; {
+		progress = false
+		for _, columns := range []bool{false, true} {
+			for i := 0; i < n; i++ {
+				count := 0
+				for j := 0; j < n; j++ {
+					r, c := i, j
+					if columns {
+						r, c = j, i
+					}
+					line[j], lineKnown[j] = m[r][c], known[r][c]
+					if lineKnown[j] {
+						count++
+					}
+				}
+				if count < v.code.K || count == n {
+					continue
+				}
+				coefficients, err := v.code.Recover(line, lineKnown)
+				if err != nil {
+					return nil, 0, err
+				}
+				if err := v.checkLine(coefficients, i, columns); err != nil {
+					return nil, 0, err
+				}
+				full := v.code.Evaluate(coefficients)
+				for j := 0; j < n; j++ {
+					r, c := i, j
+					if columns {
+						r, c = j, i
+					}
+					if !known[r][c] {
+						m[r][c], known[r][c] = full[j], true
+						repaired++
+					}
+				}
+				progress = true
+			}
+		}
+	}
+
+	missing := 0
+	for r := 0; r < v.code.K; r++ {
+		for c := 0; c < v.code.K; c++ {
+			if !known[r][c] {
+				missing++
+			}
+		}
+	}
+	if missing > 0 {
+		return nil, repaired, fmt.Errorf("%d message cells missing after repairing %d: %w", missing, repaired, ErrUnrecoverable)
+	}
+	return m, repaired, nil
+}
+
+// checkLine compares the commitment of a completed row or column with the
+// published one.
+func (v *Verifier) checkLine(coefficients []fr.Element, i int, column bool) error {
+	digest, err := kzg.Commit(coefficients, v.srs)
+	if err != nil {
+		return err
+	}
+	published, name := v.commitments.Rows[i], "row"
+	if column {
+		published, name = v.commitments.Columns[i], "column"
+	}
+	if !digest.Equal(&published) {
+		return fmt.Errorf("%s %d: %w", name, i, ErrInconsistentEncoding)
+	}
+	return nil
+}
+
+// Blob store keys of the material a two-dimensional run publishes.
+const (
+	SRSKey     = "rs2d-srs.dat"
+	RowsKey    = "rs2d-rows.dat"
+	ColumnsKey = "rs2d-columns.dat"
+)
+
+// Publish lays out message in the smallest matrix that holds it, loads an
+// SRS for its rows from config, commits to the extended matrix and uploads
+// the SRS and the commitments to blobs.
+func Publish(ctx context.Context, blobs utils.BlobStore, config kzg.SRSConfig, message []byte) (*utils.MatrixSetup, error) {
+	code := CodeForMessage(len(message))
+	srs, err := kzg.LoadSRS(config, uint64(code.K))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SRS: %w", err)
+	}
+	startTime := time.Now()
+	setup, err := code.CommitMessage(message, srs)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Committed to a %d×%d matrix extended to %d×%d in %s\n", code.K, code.K, 2*code.K, 2*code.K, time.Since(startTime))
+	if setup.SRSBlob, err = utils.PutBlob(ctx, blobs, SRSKey, setup.SRS); err != nil {
+		return nil, err
+	}
+	if setup.RowsBlob, err = utils.PutBlob(ctx, blobs, RowsKey, setup.Rows); err != nil {
+		return nil, err
+	}
+	if setup.ColumnsBlob, err = utils.PutBlob(ctx, blobs, ColumnsKey, setup.Columns); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Matrix parameters: %d bytes of SRS and %d bytes of commitments in the blob store.\n", len(setup.SRS), len(setup.Rows)+len(setup.Columns))
+	return setup, nil
+}
@@ -0,0 +1,172 @@
+// Package rs2d implements the two-dimensional erasure code of a run in the
+// style of Danksharding: the message is laid out as a K×K matrix of field
+// elements, every row and then every column is extended with Reed-Solomon
+// to a 2K×2K matrix, and each row and column of the result is committed
+// with KZG. Any K cells of a row or column determine the rest of it, so a
+// decoder can repair missing cells from either dimension.
+package rs2d
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+	kzg "github.com/xm0onh/thesis/packages/kzg"
+)
+
+// ChunkSize is the number of message bytes per cell; 31 bytes always fit
+// below the BN254 scalar modulus.
+const ChunkSize = kzg.ChunkSize
+
+var (
+	// ErrUnrecoverable means the cells do not determine the message yet;
+	// more cells can make it recoverable.
+	ErrUnrecoverable = errors.New("not enough cells to recover the message")
+
+	// ErrInconsistentEncoding means a row or column rebuilt from verified
+	// cells does not match its published commitment, so the matrix was not
+	// extended correctly.
+	ErrInconsistentEncoding = errors.New("cells are not a valid encoding under the commitments")
+)
+
+// Code is the Reed-Solomon code of one row or column: K values, taken as
+// the evaluations of a polynomial of degree below K on the subgroup H of
+// order K, followed by its evaluations on the coset gH, g the multiplicative
+// generator of the field. Position i < K is ω^i, position K+i is g·ω^i.
+type Code struct {
+	K      int
+	domain *fft.Domain
+}
+
+// NewCode returns the code for rows of k values; k must be a power of two.
+func NewCode(k int) (*Code, error) {
+	if k <= 0 || k&(k-1) != 0 {
+		return nil, fmt.Errorf("matrix size %d is not a power of two", k)
+	}
+	return &Code{K: k, domain: fft.NewDomain(uint64(k))}, nil
+}
+
+// CodeForMessage returns the code of the smallest square matrix that holds
+// messageSize bytes.
+func CodeForMessage(messageSize int) *Code {
+	k := 1
+	for k*k*ChunkSize < messageSize {
+		k *= 2
+	}
+	c, _ := NewCode(k)
+	return c
+}
+
+// Point returns the evaluation point of position i of a row or column.
+func (c *Code) Point(i int) fr.Element {
+	var p fr.Element
+	p.Exp(c.domain.Generator, new(big.Int).SetUint64(uint64(i%c.K)))
+	if i >= c.K {
+		p.Mul(&p, &c.domain.FrMultiplicativeGen)
+	}
+	return p
+}
+
+// Coefficients returns the polynomial through the K values of H.
+func (c *Code) Coefficients(values []fr.Element) []fr.Element {
+	coefficients := append([]fr.Element{}, values[:c.K]...)
+	// Evaluations to coefficients; DIF leaves them in bit-reversed order.
+	c.domain.FFTInverse(coefficients, fft.DIF)
+	fft.BitReverse(coefficients)
+	return coefficients
+}
+
+// Evaluate returns the 2K positions of the polynomial with the given
+// coefficients.
+func (c *Code) Evaluate(coefficients []fr.Element) []fr.Element {
+	line := make([]fr.Element, 2*c.K)
+	onH, onCoset := line[:c.K], line[c.K:]
+	copy(onH, coefficients)
+	copy(onCoset, coefficients)
+	c.domain.FFT(onH, fft.DIF)
+	fft.BitReverse(onH)
+	c.domain.FFT(onCoset, fft.DIF, fft.OnCoset())
+	fft.BitReverse(onCoset)
+	return line
+}
+
+// Extend returns the full row or column of K values.
+func (c *Code) Extend(values []fr.Element) []fr.Element {
+	return c.Evaluate(c.Coefficients(values))
+}
+
+// Recover returns the polynomial through the known positions of line,
+// which must number at least K.
+func (c *Code) Recover(line []fr.Element, known []bool) ([]fr.Element, error) {
+	points := make([]fr.Element, 0, c.K)
+	values := make([]fr.Element, 0, c.K)
+	for i := range line {
+		if !known[i] {
+			continue
+		}
+		points = append(points, c.Point(i))
+		values = append(values, line[i])
+		if len(points) == c.K {
+			return kzg.Interpolate(points, values)
+		}
+	}
+	return nil, fmt.Errorf("%d of %d positions known: %w", len(points), c.K, ErrUnrecoverable)
+}
+
+// Matrix is an extended 2K×2K matrix, row by row. The message fills the
+// top-left K×K quarter row by row, ChunkSize bytes per cell and zeros
+// after its end.
+type Matrix [][]fr.Element
+
+// Encode lays out message and extends it in both dimensions.
+func (c *Code) Encode(message []byte) (Matrix, error) {
+	if len(message) > c.K*c.K*ChunkSize {
+		return nil, fmt.Errorf("message of %d bytes does not fit a %d×%d matrix", len(message), c.K, c.K)
+	}
+	padded := make([]byte, c.K*c.K*ChunkSize)
+	copy(padded, message)
+	m := make(Matrix, 2*c.K)
+	for r := 0; r < c.K; r++ {
+		row := make([]fr.Element, c.K)
+		for j := range row {
+			offset := (r*c.K + j) * ChunkSize
+			row[j].SetBytes(padded[offset : offset+ChunkSize])
+		}
+		m[r] = c.Extend(row)
+	}
+	for r := c.K; r < 2*c.K; r++ {
+		m[r] = make([]fr.Element, 2*c.K)
+	}
+	column := make([]fr.Element, c.K)
+	for j := 0; j < 2*c.K; j++ {
+		for r := 0; r < c.K; r++ {
+			column[r] = m[r][j]
+		}
+		extended := c.Extend(column)
+		for r := c.K; r < 2*c.K; r++ {
+			m[r][j] = extended[r]
+		}
+	}
+	return m, nil
+}
+
+// Message reads the first size bytes of the message back from the top-left
+// quarter of m.
+func (c *Code) Message(m Matrix, size int) ([]byte, error) {
+	if size > c.K*c.K*ChunkSize {
+		return nil, fmt.Errorf("message of %d bytes does not fit a %d×%d matrix", size, c.K, c.K)
+	}
+	message := make([]byte, 0, c.K*c.K*ChunkSize)
+	for r := 0; r < c.K; r++ {
+		for j := 0; j < c.K; j++ {
+			b := m[r][j].Bytes()
+			if b[0] != 0 {
+				return nil, fmt.Errorf("cell (%d, %d) holds more than %d bytes: %w", r, j, ChunkSize, ErrInconsistentEncoding)
+			}
+			message = append(message, b[1:]...)
+		}
+	}
+	return message[:size], nil
+}
@@ -0,0 +1,212 @@
+package rs2d
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	kzg "github.com/xm0onh/thesis/packages/kzg"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+var insecureConfig = kzg.SRSConfig{Insecure: true}
+
+func testMessage(size int) []byte {
+	message := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(message)
+	return message
+}
+
+func TestEncode(t *testing.T) {
+	message := testMessage(400)
+	code := CodeForMessage(len(message))
+	if code.K != 4 {
+		t.Fatalf("%d bytes in a %d×%d matrix, want 4×4", len(message), code.K, code.K)
+	}
+	m, err := code.Encode(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := code.Message(m, len(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, message) {
+		t.Fatal("message read back differs")
+	}
+
+	// Every row and every column is a codeword.
+	column := make([]fr.Element, 2*code.K)
+	for i := 0; i < 2*code.K; i++ {
+		for r := range column {
+			column[r] = m[r][i]
+		}
+		for name, line := range map[string][]fr.Element{"row": m[i], "column": column} {
+			extended := code.Extend(line)
+			for j := range line {
+				if !extended[j].Equal(&line[j]) {
+					t.Fatalf("%s %d is not extended from its first %d cells", name, i, code.K)
+				}
+			}
+		}
+	}
+
+	// Any K positions of a row determine it.
+	known := make([]bool, 2*code.K)
+	for _, j := range []int{1, 4, 6, 7} {
+		known[j] = true
+	}
+	coefficients, err := code.Recover(m[2], known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered := code.Evaluate(coefficients)
+	for j := range recovered {
+		if !recovered[j].Equal(&m[2][j]) {
+			t.Fatalf("recovered row differs at %d", j)
+		}
+	}
+	known[1] = false
+	if _, err := code.Recover(m[2], known); !errors.Is(err, ErrUnrecoverable) {
+		t.Fatalf("recovered a row from %d positions: %v", code.K-1, err)
+	}
+
+	if _, err := code.Encode(testMessage(code.K*code.K*ChunkSize + 1)); err == nil {
+		t.Fatal("encoded a message larger than the matrix")
+	}
+}
+
+// testCells publishes message and returns the setup, a verifier for it and
+// every cell of the extended matrix.
+func testCells(t *testing.T, message []byte) (*utils.MatrixSetup, *Verifier, []utils.Cell) {
+	t.Helper()
+	setup, err := Publish(context.Background(), utils.NewMemoryBlobStore(), insecureConfig, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prover, err := NewProverForSetup(setup, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cells []utils.Cell
+	for r := 0; r < 2*setup.K; r++ {
+		row, err := prover.Row(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cells = append(cells, row...)
+	}
+	verifier, err := VerifierForSetup(insecureConfig, setup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return setup, verifier, cells
+}
+
+// selectCells returns the cells for which keep holds.
+func selectCells(cells []utils.Cell, keep func(r, c int) bool) []utils.Cell {
+	var selected []utils.Cell
+	for _, cell := range cells {
+		if keep(cell.Row, cell.Column) {
+			selected = append(selected, cell)
+		}
+	}
+	return selected
+}
+
+func TestRepair(t *testing.T) {
+	message := testMessage(400)
+	setup, verifier, cells := testCells(t, message)
+	k := setup.K
+
+	valid, rejected := verifier.VerifyCells(cells)
+	if len(valid) != len(cells) || len(rejected) != 0 {
+		t.Fatalf("%d of %d cells verified", len(valid), len(cells))
+	}
+
+	// Only the extension quarter: its rows complete first, then the
+	// columns through them.
+	quarter := selectCells(cells, func(r, c int) bool { return r >= k && c >= k })
+	m, repaired, err := verifier.Repair(quarter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired != 3*k*k {
+		t.Fatalf("repaired %d cells, want %d", repaired, 3*k*k)
+	}
+	decoded, err := verifier.Code().Message(m, len(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, message) {
+		t.Fatal("repaired message differs")
+	}
+
+	// K-1 complete rows leave every column one cell short.
+	short := selectCells(cells, func(r, c int) bool { return r < k-1 })
+	if _, _, err := verifier.Repair(short); !errors.Is(err, ErrUnrecoverable) {
+		t.Fatalf("repair from %d rows: %v", k-1, err)
+	}
+}
+
+func TestVerifyCellsRejectsTamperedCells(t *testing.T) {
+	_, verifier, cells := testCells(t, testMessage(400))
+	tampered := append([]utils.Cell(nil), cells...)
+
+	var altered fr.Element
+	altered.SetUint64(12345)
+	value := altered.Bytes()
+	tampered[3].Value = value[:]
+	tampered[9].Proof = tampered[10].Proof
+	tampered[12].Row, tampered[12].Column = tampered[12].Column, tampered[12].Row+1
+	tampered[20].Column = 4 * len(cells)
+
+	for _, i := range []int{3, 9, 12, 20} {
+		if err := verifier.VerifyCell(tampered[i]); !errors.Is(err, utils.ErrProofInvalid) {
+			t.Fatalf("cell %d: got %v, want ErrProofInvalid", i, err)
+		}
+	}
+	valid, rejected := verifier.VerifyCells(tampered)
+	if len(valid) != len(cells)-4 || len(rejected) != 4 {
+		t.Fatalf("%d cells valid and %d rejected, want %d and 4", len(valid), len(rejected), len(cells)-4)
+	}
+}
+
+func TestRepairDetectsInconsistentEncoding(t *testing.T) {
+	message := testMessage(400)
+	setup, _, cells := testCells(t, message)
+	k := setup.K
+
+	// Swap two column commitments: the row openings still verify, but
+	// the columns rebuilt through the repaired rows no longer match.
+	forged := *setup
+	forged.Columns = append([]byte(nil), setup.Columns...)
+	first, second := forged.Columns[:kzg.DigestSize], forged.Columns[kzg.DigestSize:2*kzg.DigestSize]
+	swapped := append(append([]byte(nil), second...), first...)
+	copy(forged.Columns, swapped)
+	verifier, err := VerifierForSetup(insecureConfig, &forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quarter := selectCells(cells, func(r, c int) bool { return r >= k && c >= k })
+	valid, _ := verifier.VerifyCells(quarter)
+	if len(valid) != len(quarter) {
+		t.Fatalf("%d of %d cells verified", len(valid), len(quarter))
+	}
+	if _, _, err := verifier.Repair(valid); !errors.Is(err, ErrInconsistentEncoding) {
+		t.Fatalf("repair under swapped column commitments: %v", err)
+	}
+}
+
+func TestVerifierForSetupChecksSRS(t *testing.T) {
+	setup, _, _ := testCells(t, testMessage(100))
+	if _, err := VerifierForSetup(kzg.SRSConfig{}, setup); !errors.Is(err, kzg.ErrNoSRS) {
+		t.Fatalf("verifier without a trusted SRS: %v", err)
+	}
+	if _, err := NewProverForSetup(setup, testMessage(101)); !errors.Is(err, utils.ErrInvalidSetup) {
+		t.Fatalf("prover for another message: %v", err)
+	}
+}
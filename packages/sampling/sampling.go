@@ -0,0 +1,171 @@
+// Package sampling checks that the droplets of a run are available without
+// downloading them all: a sampler fetches a few random droplets by
+// BlockCode, verifies each against the published droplet commitment and
+// bounds the chance that the pool could not be decoded.
+package sampling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+
+	commitment "github.com/xm0onh/thesis/packages/commitment"
+	kzg "github.com/xm0onh/thesis/packages/kzg"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+// ErrNoCommitment means the setup publishes no droplet commitment, so a
+// sampled droplet cannot be told apart from a forged one.
+var ErrNoCommitment = errors.New("setup publishes no droplet commitment")
+
+// DefaultOverhead is the reception overhead assumed when none is given: a
+// message of k source blocks is taken to decode from any k·(1+overhead)
+// droplets. LT codes need more than k droplets, and for few source blocks
+// noticeably more, so the bound is only as good as this assumption.
+const DefaultOverhead = 0.1
+
+// Report is the outcome of one round of sampling.
+type Report struct {
+	// Samples are the sampled BlockCodes, in the order they were fetched.
+	Samples []int64
+	// Available counts the samples that were stored and verified.
+	Available int
+	// Missing are the samples no responder stored; Invalid the ones whose
+	// droplet or proof failed verification.
+	Missing []int64
+	Invalid []int64
+
+	// Needed is the number of droplets assumed to decode the message out
+	// of the Total droplets of the run.
+	Needed int
+	Total  int
+
+	// Confidence is the probability with which the samples rule out that
+	// fewer than Needed droplets are available.
+	Confidence float64
+}
+
+// Sampler fetches droplets of one run by BlockCode and verifies them.
+type Sampler struct {
+	stores     utils.Stores
+	setup      utils.SetupRecord
+	commitment commitment.DropletCommitment
+	cipher     *utils.DropletCipher
+
+	// proofs are the per-droplet proofs setup published, loaded the first
+	// time a sampled droplet carries no proof of its own, as droplets
+	// covered by range proofs do.
+	proofs [][]byte
+}
+
+// NewSampler samples the run described by setup, whose KZG commitment is
+// checked against the SRS config selects. cipher opens sealed droplets and
+// must be nil unless the run encrypts them.
+func NewSampler(config kzg.SRSConfig, stores utils.Stores, setup utils.SetupRecord, cipher *utils.DropletCipher) (*Sampler, error) {
+	if setup.Commitment == nil {
+		return nil, ErrNoCommitment
+	}
+	c, err := commitment.ForSetup(config, setup.Commitment, setup.EncodedBlockIDs)
+	if err != nil {
+		return nil, err
+	}
+	return &Sampler{stores: stores, setup: setup, commitment: c, cipher: cipher}, nil
+}
+
+// Sample fetches k distinct droplets chosen uniformly with random, verifies
+// them and reports the confidence that at least
+// SourceBlocks·(1+overhead) droplets are available. Store errors other
+// than a missing droplet abort the round.
+func (s *Sampler) Sample(ctx context.Context, k int, overhead float64, random *rand.Rand) (Report, error) {
+	total := s.setup.EncodedBlockIDs
+	if k <= 0 || k > total {
+		return Report{}, fmt.Errorf("cannot sample %d of %d droplets", k, total)
+	}
+	report := Report{
+		Needed: NeededDroplets(s.setup.SourceBlocks, overhead),
+		Total:  total,
+	}
+	for _, code := range random.Perm(total)[:k] {
+		blockCode := int64(code)
+		report.Samples = append(report.Samples, blockCode)
+		err := s.fetch(ctx, blockCode)
+		switch {
+		case err == nil:
+			report.Available++
+		case errors.Is(err, utils.ErrDropletNotFound):
+			report.Missing = append(report.Missing, blockCode)
+		case errors.Is(err, utils.ErrProofInvalid):
+			report.Invalid = append(report.Invalid, blockCode)
+		default:
+			return Report{}, err
+		}
+	}
+	report.Confidence = Confidence(total, report.Needed, k, report.Available)
+	return report, nil
+}
+
+// fetch gets the droplet with blockCode and verifies it. A droplet that is
+// stored under the wrong BlockCode, fails authentication or does not match
+// its proof wraps utils.ErrProofInvalid.
+func (s *Sampler) fetch(ctx context.Context, blockCode int64) error {
+	droplet, err := s.stores.Droplets.GetDroplet(ctx, int(blockCode))
+	if err != nil {
+		return err
+	}
+	if droplet.BlockCode != blockCode {
+		return fmt.Errorf("droplet %d stored as %d: %w", droplet.BlockCode, blockCode, utils.ErrProofInvalid)
+	}
+	if s.cipher != nil {
+		if droplet.LTBlock, err = s.cipher.Open(droplet.LTBlock); err != nil {
+			return fmt.Errorf("droplet %d: %v: %w", blockCode, err, utils.ErrProofInvalid)
+		}
+	}
+	proof := droplet.Proof
+	if len(proof) == 0 {
+		if s.proofs == nil {
+			if s.proofs, err = s.setup.LoadDropletProofs(ctx, s.stores.Blobs); err != nil {
+				return err
+			}
+		}
+		proof = s.proofs[blockCode]
+	}
+	return s.commitment.Verify(s.setup.Commitment.Digest, droplet.LTBlock, proof)
+}
+
+// NeededDroplets is the number of droplets assumed to decode a message of
+// sourceBlocks blocks with the given reception overhead.
+func NeededDroplets(sourceBlocks int, overhead float64) int {
+	return int(math.Ceil(float64(sourceBlocks) * (1 + overhead)))
+}
+
+// Confidence returns 1 - p, with p the largest probability that k distinct
+// uniform samples out of total droplets find at least available of them
+// stored and valid while fewer than needed are. p is the hypergeometric
+// tail at needed-1 available droplets, where it peaks. With every sample
+// available it is ∏ (needed-1-i)/(total-i) over i < k.
+func Confidence(total, needed, k, available int) float64 {
+	if needed <= 0 {
+		return 1
+	}
+	if needed > total {
+		return 0
+	}
+	good := needed - 1
+	p := 0.0
+	for x := available; x <= min(k, good); x++ {
+		if k-x > total-good {
+			continue
+		}
+		p += math.Exp(logChoose(good, x) + logChoose(total-good, k-x) - logChoose(total, k))
+	}
+	return math.Max(0, 1-p)
+}
+
+func logChoose(n, k int) float64 {
+	a, _ := math.Lgamma(float64(n + 1))
+	b, _ := math.Lgamma(float64(k + 1))
+	c, _ := math.Lgamma(float64(n - k + 1))
+	return a - b - c
+}
@@ -0,0 +1,168 @@
+package sampling
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	commitment "github.com/xm0onh/thesis/packages/commitment"
+	kzg "github.com/xm0onh/thesis/packages/kzg"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+var insecureConfig = kzg.SRSConfig{Insecure: true}
+
+func TestNeededDroplets(t *testing.T) {
+	for _, c := range []struct {
+		sourceBlocks int
+		overhead     float64
+		want         int
+	}{
+		{20, 0.1, 22},
+		{10, 0, 10},
+		{3, 0.1, 4},
+		{100, 0.25, 125},
+	} {
+		if got := NeededDroplets(c.sourceBlocks, c.overhead); got != c.want {
+			t.Errorf("NeededDroplets(%d, %v) = %d, want %d", c.sourceBlocks, c.overhead, got, c.want)
+		}
+	}
+}
+
+func TestConfidence(t *testing.T) {
+	// Every sample available: 1 - ∏ (needed-1-i)/(total-i).
+	if got, want := Confidence(10, 5, 3, 3), 1-4.0/10*3/9*2/8; math.Abs(got-want) > 1e-12 {
+		t.Fatalf("Confidence(10, 5, 3, 3) = %v, want %v", got, want)
+	}
+	// More samples, all available, can only raise the confidence.
+	previous := 0.0
+	for k := 1; k <= 20; k++ {
+		c := Confidence(100, 50, k, k)
+		if c <= previous || c > 1 {
+			t.Fatalf("confidence %v after %d good samples, %v after %d", c, k, previous, k-1)
+		}
+		previous = c
+	}
+	// Once more than total-needed samples are good, fewer than needed
+	// available droplets are ruled out.
+	if got := Confidence(100, 50, 52, 52); got != 1 {
+		t.Fatalf("confidence %v with 52 of 100 droplets seen", got)
+	}
+	// A missing sample lowers it.
+	if Confidence(100, 50, 10, 9) >= Confidence(100, 50, 10, 10) {
+		t.Fatal("a missing sample did not lower the confidence")
+	}
+	if got := Confidence(100, 50, 10, 0); got > 1e-9 {
+		t.Fatalf("confidence %v without any available sample", got)
+	}
+	if got := Confidence(10, 11, 5, 5); got != 0 {
+		t.Fatalf("confidence %v when more droplets are needed than exist", got)
+	}
+	if got := Confidence(10, 0, 1, 0); got != 1 {
+		t.Fatalf("confidence %v when no droplets are needed", got)
+	}
+}
+
+// testRun publishes a commitment under scheme to encodedBlockIDs random
+// droplets and returns the stores and setup record of the run with
+// droplets not yet stored.
+func testRun(t *testing.T, scheme string, encodedBlockIDs int) (utils.Stores, utils.SetupRecord, []utils.StoredDroplet) {
+	t.Helper()
+	random := rand.New(rand.NewSource(3))
+	droplets := make([]lubyTransform.LTBlock, encodedBlockIDs)
+	for i := range droplets {
+		droplets[i].BlockCode = int64(i)
+		droplets[i].Data = make([]byte, 48)
+		random.Read(droplets[i].Data)
+	}
+	stores := utils.NewMemoryStores()
+	c, err := commitment.New(scheme, insecureConfig, encodedBlockIDs, 0, droplets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	published, err := commitment.Publish(ctx, stores.Blobs, c, encodedBlockIDs, droplets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup := utils.SetupRecord{SourceBlocks: encodedBlockIDs / 3, EncodedBlockIDs: encodedBlockIDs, Commitment: published}
+	proofs, err := setup.LoadDropletProofs(ctx, stores.Blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := make([]utils.StoredDroplet, encodedBlockIDs)
+	for i := range droplets {
+		stored[i] = utils.StoredDroplet{LTBlock: droplets[i], Proof: proofs[i]}
+	}
+	return stores, setup, stored
+}
+
+func TestSample(t *testing.T) {
+	for _, scheme := range []string{utils.CommitmentKZG, utils.CommitmentMerkle} {
+		t.Run(scheme, func(t *testing.T) {
+			ctx := context.Background()
+			stores, setup, stored := testRun(t, scheme, 30)
+			for i, droplet := range stored {
+				switch {
+				case i%10 == 3:
+					// Missing.
+					continue
+				case i == 5:
+					droplet.Data = append([]byte(nil), droplet.Data...)
+					droplet.Data[0] ^= 1
+				case i == 6:
+					droplet.LTBlock = stored[7].LTBlock
+				case i%2 == 0:
+					// Covered by the proofs setup published.
+					droplet.Proof = nil
+				}
+				if err := stores.Droplets.PutDroplet(ctx, i, droplet); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			sampler, err := NewSampler(insecureConfig, stores, setup, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report, err := sampler.Sample(ctx, 30, DefaultOverhead, rand.New(rand.NewSource(1)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Samples) != 30 || report.Available != 25 || len(report.Missing) != 3 || len(report.Invalid) != 2 {
+				t.Fatalf("%d samples: %d available, missing %v, invalid %v", len(report.Samples), report.Available, report.Missing, report.Invalid)
+			}
+			if report.Needed != 11 || report.Total != 30 {
+				t.Fatalf("needed %d of %d droplets", report.Needed, report.Total)
+			}
+			if report.Confidence != Confidence(30, 11, 30, 25) || report.Confidence != 1 {
+				t.Fatalf("confidence %v after sampling every droplet", report.Confidence)
+			}
+
+			report, err = sampler.Sample(ctx, 5, DefaultOverhead, rand.New(rand.NewSource(2)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Available+len(report.Missing)+len(report.Invalid) != 5 {
+				t.Fatalf("%d of 5 samples accounted for", report.Available+len(report.Missing)+len(report.Invalid))
+			}
+			if _, err := sampler.Sample(ctx, 31, DefaultOverhead, rand.New(rand.NewSource(2))); err == nil {
+				t.Fatal("sampled more droplets than the run has")
+			}
+		})
+	}
+}
+
+func TestNewSampler(t *testing.T) {
+	stores, setup, _ := testRun(t, utils.CommitmentKZG, 8)
+	if _, err := NewSampler(kzg.SRSConfig{}, stores, setup, nil); !errors.Is(err, kzg.ErrNoSRS) {
+		t.Fatalf("KZG setup without a trusted SRS: %v", err)
+	}
+	setup.Commitment = nil
+	if _, err := NewSampler(insecureConfig, stores, setup, nil); !errors.Is(err, ErrNoCommitment) {
+		t.Fatalf("setup without a commitment: %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone   = "none"
+	CompressionGzip   = "gzip"
+	CompressionZstd   = "zstd"
+	CompressionSnappy = "snappy"
+)
+
+// Compressor is an optional stage between serialization and fountain
+// encoding. Compress must be deterministic, since setup and every responder
+// compress the same message independently and must produce the same bytes.
+type Compressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// CompressorByName returns the compressor registered under name. An empty
+// name selects no compression.
+func CompressorByName(name string) (Compressor, error) {
+	switch name {
+	case "", CompressionNone:
+		return NoCompression{}, nil
+	case CompressionGzip:
+		return GzipCompressor{}, nil
+	case CompressionZstd:
+		return ZstdCompressor{}, nil
+	case CompressionSnappy:
+		return SnappyCompressor{}, nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", name)
+	}
+}
+
+type NoCompression struct{}
+
+func (NoCompression) Name() string { return CompressionNone }
+
+func (NoCompression) Compress(data []byte) ([]byte, error) { return data, nil }
+
+func (NoCompression) Decompress(data []byte) ([]byte, error) { return data, nil }
+
+type GzipCompressor struct{}
+
+func (GzipCompressor) Name() string { return CompressionGzip }
+
+func (GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+type ZstdCompressor struct{}
+
+func (ZstdCompressor) Name() string { return CompressionZstd }
+
+func (ZstdCompressor) Compress(data []byte) ([]byte, error) {
+	// A single-threaded encoder keeps the output independent of the number
+	// of CPUs of the machine doing the compression.
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	if err != nil {
+		return nil, err
+	}
+	defer enc.Close()
+	return enc.EncodeAll(data, nil), nil
+}
+
+func (ZstdCompressor) Decompress(data []byte) ([]byte, error) {
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	return dec.DecodeAll(data, nil)
+}
+
+type SnappyCompressor struct{}
+
+func (SnappyCompressor) Name() string { return CompressionSnappy }
+
+func (SnappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (SnappyCompressor) Decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
+
+// CompressionStats records the effect of the compression stage on a run.
+type CompressionStats struct {
+	Compression      string        `json:"compression"`
+	UncompressedSize int           `json:"uncompressedSize"`
+	CompressedSize   int           `json:"compressedSize"`
+	Ratio            float64       `json:"ratio"`
+	Duration         time.Duration `json:"duration"`
+}
+
+// CompressMessage compresses message with compressor and measures how long
+// it took and how much it saved.
+func CompressMessage(compressor Compressor, message []byte) ([]byte, CompressionStats, error) {
+	start := time.Now()
+	compressed, err := compressor.Compress(message)
+	if err != nil {
+		return nil, CompressionStats{}, fmt.Errorf("failed to compress message with %s: %w", compressor.Name(), err)
+	}
+	stats := CompressionStats{
+		Compression:      compressor.Name(),
+		UncompressedSize: len(message),
+		CompressedSize:   len(compressed),
+		Duration:         time.Since(start),
+	}
+	if len(compressed) > 0 {
+		stats.Ratio = float64(len(message)) / float64(len(compressed))
+	}
+	return compressed, stats, nil
+}
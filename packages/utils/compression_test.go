@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCompressorsRoundTrip(t *testing.T) {
+	message, _, _, err := CalculateMessageAndMessageSize(testChain(t), []int{0, 1, 2}, GobSerializer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{CompressionNone, CompressionGzip, CompressionZstd, CompressionSnappy} {
+		t.Run(name, func(t *testing.T) {
+			compressor, err := CompressorByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compressed, stats, err := CompressMessage(compressor, message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.UncompressedSize != len(message) || stats.CompressedSize != len(compressed) || stats.Compression != name {
+				t.Fatalf("stats %+v for %d -> %d bytes", stats, len(message), len(compressed))
+			}
+			if name != CompressionNone && len(compressed) >= len(message) {
+				t.Fatalf("%s did not shrink a %d byte message", name, len(message))
+			}
+			decompressed, err := compressor.Decompress(compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decompressed, message) {
+				t.Fatal("round trip changed the message")
+			}
+		})
+	}
+}
+
+func TestDecodeMessageRejectsCorruptCompression(t *testing.T) {
+	message, _, _, err := CalculateMessageAndMessageSize(testChain(t), []int{0}, GobSerializer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, _, err := CompressMessage(GzipCompressor{}, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	param := SetupParameters{Serialization: SerializationGob, Compression: CompressionGzip}
+	blocks, err := DecodeMessage(compressed, param)
+	if err != nil || len(blocks) != 1 {
+		t.Fatalf("decoded %d blocks, %v", len(blocks), err)
+	}
+	compressed[len(compressed)/2] ^= 0xff
+	if _, err := DecodeMessage(compressed, param); !errors.Is(err, ErrCorruptMessage) {
+		t.Fatalf("corrupt message: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+)
+
+const (
+	EncryptionNone   = "none"
+	EncryptionAESGCM = "aes-256-gcm"
+
+	DropletKeySize = 32
+)
+
+var ErrDropletAuthentication = errors.New("droplet failed authentication")
+
+// ParseDropletKey decodes a hex encoded 256-bit droplet key, as distributed
+// out of band in DROPLET_KEY. An empty string means no key.
+func ParseDropletKey(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("droplet key is not hex: %w", err)
+	}
+	if len(key) != DropletKeySize {
+		return nil, fmt.Errorf("droplet key must be %d bytes, got %d", DropletKeySize, len(key))
+	}
+	return key, nil
+}
+
+// DropletKeyID is a public fingerprint of key. Setup records it so that
+// responders and decoders can tell they were given the session's key without
+// the key itself being stored.
+func DropletKeyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("droplet-key-id:"), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// DropletCipher seals the droplets and the message blob of a session with
+// AES-256-GCM. Each sealed droplet carries its own random nonce in front of
+// the ciphertext. The setup ID, the key ID and the droplet's BlockCode are
+// authenticated as associated data, so a droplet's data cannot be replayed
+// under a different code or into another session that reuses the key.
+type DropletCipher struct {
+	aead    cipher.AEAD
+	keyID   string
+	setupID string
+}
+
+// NewDropletCipher returns the cipher of the session setupID.
+func NewDropletCipher(key []byte, setupID string) (*DropletCipher, error) {
+	if len(key) != DropletKeySize {
+		return nil, fmt.Errorf("droplet key must be %d bytes, got %d", DropletKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &DropletCipher{aead: aead, keyID: DropletKeyID(key), setupID: setupID}, nil
+}
+
+// SessionEncryption validates the encryption requested for a session and
+// returns what setup records for it: the encryption name and the key ID.
+func SessionEncryption(encryption string, key []byte) (string, string, error) {
+	switch encryption {
+	case "", EncryptionNone:
+		return EncryptionNone, "", nil
+	case EncryptionAESGCM:
+	default:
+		return "", "", fmt.Errorf("unknown encryption %q", encryption)
+	}
+	if key == nil {
+		return "", "", errors.New("droplet encryption requested but no droplet key was provided")
+	}
+	if len(key) != DropletKeySize {
+		return "", "", fmt.Errorf("droplet key must be %d bytes, got %d", DropletKeySize, len(key))
+	}
+	return encryption, DropletKeyID(key), nil
+}
+
+// DropletCipherForSetup returns the cipher for a session, or nil if the
+// session does not encrypt droplets. The key must match the recorded key ID.
+func DropletCipherForSetup(param SetupParameters, key []byte) (*DropletCipher, error) {
+	switch param.Encryption {
+	case "", EncryptionNone:
+		return nil, nil
+	case EncryptionAESGCM:
+	default:
+		return nil, fmt.Errorf("unknown encryption %q", param.Encryption)
+	}
+	if key == nil {
+		return nil, errors.New("droplets are encrypted but no droplet key was provided")
+	}
+	c, err := NewDropletCipher(key, param.SetupID)
+	if err != nil {
+		return nil, err
+	}
+	if c.KeyID() != param.DropletKeyID {
+		return nil, fmt.Errorf("droplet key %s does not match the session key %s", c.KeyID(), param.DropletKeyID)
+	}
+	return c, nil
+}
+
+func (c *DropletCipher) KeyID() string {
+	return c.keyID
+}
+
+// Kinds of sealed data, so a droplet cannot pass for the message blob.
+const (
+	sealedDroplet = 'd'
+	sealedMessage = 'm'
+)
+
+// associatedData binds sealed data to the session: the length-prefixed
+// setup ID, the key ID, the kind of data and, for droplets, the BlockCode.
+func (c *DropletCipher) associatedData(kind byte, blockCode int64) []byte {
+	ad := binary.AppendUvarint(nil, uint64(len(c.setupID)))
+	ad = append(ad, c.setupID...)
+	ad = append(ad, c.keyID...)
+	ad = append(ad, kind)
+	return binary.BigEndian.AppendUint64(ad, uint64(blockCode))
+}
+
+func (c *DropletCipher) seal(data, ad []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(data)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, data, ad), nil
+}
+
+func (c *DropletCipher) open(sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < c.aead.NonceSize()+c.aead.Overhead() {
+		return nil, errors.New("too short to be sealed")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	return c.aead.Open(nil, nonce, ciphertext, ad)
+}
+
+// SealMessage seals the message blob of the session, which holds the same
+// data as its droplets.
+func (c *DropletCipher) SealMessage(message []byte) ([]byte, error) {
+	return c.seal(message, c.associatedData(sealedMessage, 0))
+}
+
+// OpenMessage verifies and decrypts a message sealed by SealMessage.
+func (c *DropletCipher) OpenMessage(sealed []byte) ([]byte, error) {
+	message, err := c.open(sealed, c.associatedData(sealedMessage, 0))
+	if err != nil {
+		return nil, fmt.Errorf("sealed message: %w", ErrDropletAuthentication)
+	}
+	return message, nil
+}
+
+// Seal returns droplet with its data replaced by nonce || ciphertext.
+func (c *DropletCipher) Seal(droplet lubyTransform.LTBlock) (lubyTransform.LTBlock, error) {
+	data, err := c.seal(droplet.Data, c.associatedData(sealedDroplet, droplet.BlockCode))
+	if err != nil {
+		return lubyTransform.LTBlock{}, err
+	}
+	return lubyTransform.LTBlock{BlockCode: droplet.BlockCode, Data: data}, nil
+}
+
+// Open verifies and decrypts a droplet sealed by Seal.
+func (c *DropletCipher) Open(droplet lubyTransform.LTBlock) (lubyTransform.LTBlock, error) {
+	data, err := c.open(droplet.Data, c.associatedData(sealedDroplet, droplet.BlockCode))
+	if err != nil {
+		return lubyTransform.LTBlock{}, fmt.Errorf("droplet %d: %w", droplet.BlockCode, ErrDropletAuthentication)
+	}
+	return lubyTransform.LTBlock{BlockCode: droplet.BlockCode, Data: data}, nil
+}
+
+// OpenDroplets opens every droplet and drops the ones that fail
+// authentication; the fountain code tolerates missing droplets, so a few
+// forged or corrupted ones should not stop decoding. It returns the number
+// of rejected droplets.
+func (c *DropletCipher) OpenDroplets(droplets []StoredDroplet) ([]StoredDroplet, int) {
+	opened := make([]StoredDroplet, 0, len(droplets))
+	rejected := 0
+	for _, droplet := range droplets {
+		d, err := c.Open(droplet.LTBlock)
+		if err != nil {
+			rejected++
+			continue
+		}
+		opened = append(opened, StoredDroplet{LTBlock: d, Proof: droplet.Proof})
+	}
+	return opened, rejected
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+)
+
+var testDropletKey = bytes.Repeat([]byte{7}, DropletKeySize)
+
+func testCipher(t *testing.T, key []byte, setupID string) *DropletCipher {
+	t.Helper()
+	c, err := NewDropletCipher(key, setupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDropletCipherBindsSession(t *testing.T) {
+	c := testCipher(t, testDropletKey, "setup-a")
+	droplet := lubyTransform.LTBlock{BlockCode: 5, Data: []byte("droplet data")}
+	sealed, err := c.Seal(droplet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed.Data, droplet.Data) {
+		t.Fatal("sealed droplet holds its plaintext")
+	}
+	opened, err := c.Open(sealed)
+	if err != nil || !bytes.Equal(opened.Data, droplet.Data) {
+		t.Fatalf("Open = %q, %v", opened.Data, err)
+	}
+
+	moved := sealed
+	moved.BlockCode = 6
+	tampered := lubyTransform.LTBlock{BlockCode: 5, Data: append([]byte{}, sealed.Data...)}
+	tampered.Data[len(tampered.Data)-1] ^= 1
+	for name, bad := range map[string]lubyTransform.LTBlock{
+		"other block code": moved,
+		"tampered data":    tampered,
+		"truncated":        {BlockCode: 5, Data: sealed.Data[:4]},
+	} {
+		if _, err := c.Open(bad); !errors.Is(err, ErrDropletAuthentication) {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	// The same key in another session.
+	if _, err := testCipher(t, testDropletKey, "setup-b").Open(sealed); !errors.Is(err, ErrDropletAuthentication) {
+		t.Fatalf("droplet replayed into another session: %v", err)
+	}
+	// A sealed message is no droplet, and the other way around.
+	message, err := c.SealMessage(droplet.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Open(lubyTransform.LTBlock{BlockCode: 0, Data: message}); err == nil {
+		t.Fatal("sealed message opened as a droplet")
+	}
+}
+
+func TestDropletCipherForSetup(t *testing.T) {
+	encryption, keyID, err := SessionEncryption(EncryptionAESGCM, testDropletKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	param := SetupParameters{SetupID: "setup-a", Encryption: encryption, DropletKeyID: keyID}
+	if c, err := DropletCipherForSetup(param, testDropletKey); err != nil || c == nil {
+		t.Fatalf("DropletCipherForSetup = %v, %v", c, err)
+	}
+	if _, err := DropletCipherForSetup(param, bytes.Repeat([]byte{8}, DropletKeySize)); err == nil {
+		t.Fatal("a key with another key ID was accepted")
+	}
+	if _, err := DropletCipherForSetup(param, nil); err == nil {
+		t.Fatal("an encrypted session opened without a key")
+	}
+	if c, err := DropletCipherForSetup(SetupParameters{Encryption: EncryptionNone}, nil); err != nil || c != nil {
+		t.Fatalf("unencrypted session: %v, %v", c, err)
+	}
+	if _, _, err := SessionEncryption(EncryptionAESGCM, nil); err == nil {
+		t.Fatal("encryption requested without a key")
+	}
+}
+
+func TestLoadSealedMessage(t *testing.T) {
+	ctx := context.Background()
+	blobs := NewMemoryStores().Blobs
+	c := testCipher(t, testDropletKey, "setup-a")
+	message := []byte("compressed message")
+	sealed, err := c.SealMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blobs.Put(ctx, "blockchain_data", sealed); err != nil {
+		t.Fatal(err)
+	}
+	r := SetupRecord{MessageKey: "blockchain_data", MessageSize: len(message), Encryption: EncryptionAESGCM}
+	loaded, err := r.LoadMessage(ctx, blobs, c)
+	if err != nil || !bytes.Equal(loaded, message) {
+		t.Fatalf("LoadMessage = %q, %v", loaded, err)
+	}
+	if _, err := r.LoadMessage(ctx, blobs, nil); err == nil {
+		t.Fatal("sealed message loaded without a cipher")
+	}
+	if _, err := r.LoadMessage(ctx, blobs, testCipher(t, testDropletKey, "setup-b")); !errors.Is(err, ErrDropletAuthentication) {
+		t.Fatalf("message of another session: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	blockchainPkg "github.com/xm0onh/thesis/packages/blockchain"
+)
+
+var ErrBlockOutOfRange = errors.New("block number out of range")
+
+// blockMessageMagic starts every message built by WriteBlockMessage.
+const blockMessageMagic = "LTBM"
+
+// ManifestKey is the blob the manifest of a run is published under.
+const ManifestKey = "manifest.json"
+
+// BlockRange selects the blocks at positions Start up to, but not including,
+// End.
+type BlockRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// ManifestEntry locates one block inside a message built by
+// WriteBlockMessage: the bytes at [Offset, Offset+Length) are the block's
+// frame, which unmarshals to exactly that block.
+type ManifestEntry struct {
+	Position int    `json:"position"`
+	Index    int    `json:"index"`
+	Hash     string `json:"hash"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+}
+
+func checkPosition(bc *blockchainPkg.Blockchain, position int) error {
+	if position < 0 || position >= len(bc.Chain) {
+		return fmt.Errorf("block %d not in chain of %d blocks: %w", position, len(bc.Chain), ErrBlockOutOfRange)
+	}
+	return nil
+}
+
+// MessageStats measures a message built by WriteBlockMessage. Size is its
+// length. FramingOverhead is the part of Size spent on framing: the
+// container header, the length prefixes and whatever the serializer repeats
+// in every frame that one document of all the blocks would hold once, such
+// as gob's type descriptors. Size minus FramingOverhead is the size of the
+// blocks serialized as a single stream.
+type MessageStats struct {
+	Size            int `json:"size"`
+	FramingOverhead int `json:"framingOverhead"`
+}
+
+// streamSizer is implemented by serializers whose documents carry a
+// preamble a stream sends only once. The returned function reports how
+// many bytes each block takes in one stream of the blocks it is called with.
+type streamSizer interface {
+	streamSizes() func(block *blockchainPkg.Block) (int, error)
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// WriteBlockMessage writes the blocks at positions to w as one container:
+// blockMessageMagic, the number of blocks as a uvarint, and then one frame
+// per block, its length as a uvarint followed by the block serialized on
+// its own. Frames are complete documents of the serializer, so a block can
+// be extracted without decoding the others, at the cost of repeating what
+// a format sends once per document; the returned stats report that cost.
+// Only the requested blocks are ever encoded, and every position is checked
+// before anything is written.
+func WriteBlockMessage(w io.Writer, bc *blockchainPkg.Blockchain, positions []int, serializer Serializer) ([]ManifestEntry, MessageStats, error) {
+	for _, position := range positions {
+		if err := checkPosition(bc, position); err != nil {
+			return nil, MessageStats{}, err
+		}
+	}
+	var streamSize func(*blockchainPkg.Block) (int, error)
+	if sizer, ok := serializer.(streamSizer); ok {
+		streamSize = sizer.streamSizes()
+	}
+	out := &countingWriter{w: w}
+	header := binary.AppendUvarint([]byte(blockMessageMagic), uint64(len(positions)))
+	if _, err := out.Write(header); err != nil {
+		return nil, MessageStats{}, err
+	}
+	manifest := make([]ManifestEntry, 0, len(positions))
+	overhead := len(header)
+	for _, position := range positions {
+		block := &bc.Chain[position]
+		data, err := serializer.Marshal([]*blockchainPkg.Block{block})
+		if err != nil {
+			return nil, MessageStats{}, fmt.Errorf("failed to serialize block %d: %w", block.Index, err)
+		}
+		prefix := binary.AppendUvarint(nil, uint64(len(data)))
+		if _, err := out.Write(prefix); err != nil {
+			return nil, MessageStats{}, err
+		}
+		manifest = append(manifest, ManifestEntry{
+			Position: position,
+			Index:    block.Index,
+			Hash:     block.Hash,
+			Offset:   out.n,
+			Length:   len(data),
+		})
+		if _, err := out.Write(data); err != nil {
+			return nil, MessageStats{}, err
+		}
+		overhead += len(prefix)
+		if streamSize != nil {
+			size, err := streamSize(block)
+			if err != nil {
+				return nil, MessageStats{}, fmt.Errorf("failed to measure block %d: %w", block.Index, err)
+			}
+			overhead += len(data) - size
+		}
+	}
+	return manifest, MessageStats{Size: out.n, FramingOverhead: overhead}, nil
+}
+
+// ExtractBlock decodes the block described by entry from message.
+func ExtractBlock(message []byte, entry ManifestEntry, serializer Serializer) (blockchainPkg.Block, error) {
+	if entry.Offset < 0 || entry.Length < 0 || entry.Offset+entry.Length > len(message) {
+		return blockchainPkg.Block{}, fmt.Errorf("block %d at [%d, %d) is outside the %d byte message", entry.Index, entry.Offset, entry.Offset+entry.Length, len(message))
+	}
+	return decodeFrame(message[entry.Offset:entry.Offset+entry.Length], serializer)
+}
+
+func decodeFrame(frame []byte, serializer Serializer) (blockchainPkg.Block, error) {
+	blocks, err := serializer.Unmarshal(frame)
+	if err != nil {
+		return blockchainPkg.Block{}, err
+	}
+	if len(blocks) != 1 {
+		return blockchainPkg.Block{}, fmt.Errorf("frame holds %d blocks", len(blocks))
+	}
+	return blocks[0], nil
+}
+
+// DecodeBlockMessage decodes every block of a message built by
+// WriteBlockMessage. The container delimits the frames itself, so no
+// manifest is needed.
+func DecodeBlockMessage(message []byte, serializer Serializer) ([]blockchainPkg.Block, error) {
+	if !bytes.HasPrefix(message, []byte(blockMessageMagic)) {
+		return nil, errors.New("message is not a block container")
+	}
+	rest := message[len(blockMessageMagic):]
+	count, n := binary.Uvarint(rest)
+	if n <= 0 || count > uint64(len(rest)) {
+		return nil, errors.New("block container has a malformed block count")
+	}
+	rest = rest[n:]
+	blocks := make([]blockchainPkg.Block, 0, count)
+	for i := uint64(0); i < count; i++ {
+		length, n := binary.Uvarint(rest)
+		if n <= 0 || length > uint64(len(rest)-n) {
+			return nil, fmt.Errorf("frame %d of the block container is truncated", i)
+		}
+		block, err := decodeFrame(rest[n:n+int(length)], serializer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode frame %d: %w", i, err)
+		}
+		blocks = append(blocks, block)
+		rest = rest[n+int(length):]
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%d bytes after the last frame of the block container", len(rest))
+	}
+	return blocks, nil
+}
+
+// CalculateMessageAndMessageSize builds the message for the blocks at
+// blockNumber and returns it with its stats and manifest.
+func CalculateMessageAndMessageSize(blockchain *blockchainPkg.Blockchain, blockNumber []int, serializer Serializer) ([]byte, MessageStats, []ManifestEntry, error) {
+	var buf bytes.Buffer
+	manifest, stats, err := WriteBlockMessage(&buf, blockchain, blockNumber, serializer)
+	if err != nil {
+		return nil, MessageStats{}, nil, err
+	}
+	return buf.Bytes(), stats, manifest, nil
+}
+
+// MeasureBlockMessage returns the stats of the message for the blocks at
+// positions without keeping it: the blocks are serialized one at a time and
+// only counted.
+func MeasureBlockMessage(blockchain *blockchainPkg.Blockchain, positions []int, serializer Serializer) (MessageStats, error) {
+	_, stats, err := WriteBlockMessage(io.Discard, blockchain, positions, serializer)
+	return stats, err
+}
+
+// PublishManifest uploads manifest to blobs and returns the key to record
+// as SetupRecord.ManifestKey. Manifests grow with the number of blocks, so
+// they are kept out of the setup item.
+func PublishManifest(ctx context.Context, blobs BlobStore, manifest []ManifestEntry) (string, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := blobs.Put(ctx, ManifestKey, data); err != nil {
+		return "", fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	return ManifestKey, nil
+}
+
+// LoadManifest fetches the manifest of the run from blobs and checks that
+// its frames follow each other inside the uncompressed message.
+func (r SetupRecord) LoadManifest(ctx context.Context, blobs BlobStore) ([]ManifestEntry, error) {
+	data, err := blobs.Get(ctx, r.ManifestKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load manifest %s: %w", r.ManifestKey, err)
+	}
+	var manifest []ManifestEntry
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest %s: %v: %w", r.ManifestKey, err, ErrInvalidSetup)
+	}
+	end := len(blockMessageMagic)
+	for _, entry := range manifest {
+		if entry.Offset <= end || entry.Length <= 0 || entry.Offset+entry.Length > r.RawMessageSize {
+			return nil, fmt.Errorf("manifest entry for block %d at [%d, %d) does not follow the previous block in a %d byte message: %w", entry.Index, entry.Offset, entry.Offset+entry.Length, r.RawMessageSize, ErrInvalidSetup)
+		}
+		end = entry.Offset + entry.Length
+	}
+	return manifest, nil
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	blockchainPkg "github.com/xm0onh/thesis/packages/blockchain"
+)
+
+func TestBlockMessageRoundTrip(t *testing.T) {
+	bc := testChain(t)
+	positions := []int{2, 0}
+	for _, name := range []string{SerializationGob, SerializationJSON, SerializationProtobuf, SerializationRLP} {
+		t.Run(name, func(t *testing.T) {
+			s, err := SerializerByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			message, stats, manifest, err := CalculateMessageAndMessageSize(bc, positions, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Size != len(message) || len(manifest) != 2 {
+				t.Fatalf("%d byte message with %d manifest entries", stats.Size, len(manifest))
+			}
+			measured, err := MeasureBlockMessage(bc, positions, s)
+			if err != nil || measured != stats {
+				t.Fatalf("measured %+v, %v, built %+v", measured, err, stats)
+			}
+			blocks, err := DecodeBlockMessage(message, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSameBlocks(t, []blockchainPkg.Block{bc.Chain[2], bc.Chain[0]}, blocks)
+
+			for i, entry := range manifest {
+				block, err := ExtractBlock(message, entry, s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if entry.Position != positions[i] || block.Hash != entry.Hash {
+					t.Fatalf("manifest entry %d %+v extracts block %s", i, entry, block.Hash)
+				}
+				if name == SerializationJSON && !json.Valid(message[entry.Offset:entry.Offset+entry.Length]) {
+					t.Fatalf("frame of block %d is not valid JSON", entry.Index)
+				}
+			}
+		})
+	}
+}
+
+func TestGobFramingOverhead(t *testing.T) {
+	bc := testChain(t)
+	positions := []int{0, 1, 2}
+	_, stats, _, err := CalculateMessageAndMessageSize(bc, positions, GobSerializer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := make([]*blockchainPkg.Block, len(positions))
+	for i, position := range positions {
+		blocks[i] = &bc.Chain[position]
+	}
+	var stream bytes.Buffer
+	encoder := gob.NewEncoder(&stream)
+	for _, block := range blocks {
+		if err := encoder.Encode([]*blockchainPkg.Block{block}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Every frame after the first repeats the type descriptors.
+	if stats.Size-stats.FramingOverhead != stream.Len() {
+		t.Fatalf("%d byte message with %d bytes of framing, one gob stream takes %d", stats.Size, stats.FramingOverhead, stream.Len())
+	}
+}
+
+func TestDecodeBlockMessageRejectsMalformedContainers(t *testing.T) {
+	bc := testChain(t)
+	message, _, _, err := CalculateMessageAndMessageSize(bc, []int{0, 1}, GobSerializer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, corrupt := range map[string][]byte{
+		"truncated":     message[:len(message)-1],
+		"trailing data": append(append([]byte{}, message...), 0),
+		"no container":  message[len(blockMessageMagic):],
+	} {
+		if _, err := DecodeBlockMessage(corrupt, GobSerializer{}); err == nil {
+			t.Fatalf("%s message was decoded", name)
+		}
+	}
+	if _, _, _, err := CalculateMessageAndMessageSize(bc, []int{3}, GobSerializer{}); !errors.Is(err, ErrBlockOutOfRange) {
+		t.Fatalf("block past the tip: %v", err)
+	}
+}
+
+func TestManifestBlob(t *testing.T) {
+	ctx := context.Background()
+	blobs := NewMemoryStores().Blobs
+	message, _, manifest, err := CalculateMessageAndMessageSize(testChain(t), []int{0, 1, 2}, JSONSerializer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := PublishManifest(ctx, blobs, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := SetupRecord{ManifestKey: key, RawMessageSize: len(message)}
+	loaded, err := r.LoadManifest(ctx, blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 3 || loaded[2] != manifest[2] {
+		t.Fatalf("loaded manifest %+v", loaded)
+	}
+
+	// A manifest that reaches past the message is rejected.
+	r.RawMessageSize = manifest[2].Offset
+	if _, err := r.LoadManifest(ctx, blobs); !errors.Is(err, ErrInvalidSetup) {
+		t.Fatalf("manifest past the message: %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/cbergoon/merkletree"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	blockchainPkg "github.com/xm0onh/thesis/packages/blockchain"
+)
+
+const (
+	SerializationGob      = "gob"
+	SerializationJSON     = "json"
+	SerializationProtobuf = "protobuf"
+	SerializationRLP      = "rlp"
+)
+
+// Serializer turns a list of blocks into the byte message that is fed to the
+// fountain encoder, and back again. The name is stored in the setup
+// parameters so the decoder can pick the same format.
+type Serializer interface {
+	Name() string
+	Marshal(blocks []*blockchainPkg.Block) ([]byte, error)
+	Unmarshal(data []byte) ([]blockchainPkg.Block, error)
+}
+
+func init() {
+	gob.Register(blockchainPkg.Transaction{})
+	gob.Register(blockchainPkg.Block{})
+}
+
+// SerializerByName returns the serializer registered under name. An empty
+// name selects gob, which is what older setups used.
+func SerializerByName(name string) (Serializer, error) {
+	switch name {
+	case "", SerializationGob:
+		return GobSerializer{}, nil
+	case SerializationJSON:
+		return JSONSerializer{}, nil
+	case SerializationProtobuf:
+		return ProtobufSerializer{}, nil
+	case SerializationRLP:
+		return RLPSerializer{}, nil
+	}
+	return nil, fmt.Errorf("unknown serialization format %q", name)
+}
+
+func SerializeBlockchain(s Serializer, bc *blockchainPkg.Blockchain) ([]byte, error) {
+	blocks := make([]*blockchainPkg.Block, len(bc.Chain))
+	for i := range bc.Chain {
+		blocks[i] = &bc.Chain[i]
+	}
+	return s.Marshal(blocks)
+}
+
+func DeserializeBlockchain(s Serializer, data []byte) (*blockchainPkg.Blockchain, error) {
+	blocks, err := s.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &blockchainPkg.Blockchain{Chain: blocks}, nil
+}
+
+// GobSerializer is the original Go-only encoding.
+type GobSerializer struct{}
+
+func (GobSerializer) Name() string { return SerializationGob }
+
+func (GobSerializer) Marshal(blocks []*blockchainPkg.Block) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(blocks); err != nil {
+		return nil, fmt.Errorf("failed to gob encode blocks: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// streamSizes encodes the blocks into one gob stream, which sends the type
+// descriptors with the first block only, and counts what each block adds.
+func (GobSerializer) streamSizes() func(*blockchainPkg.Block) (int, error) {
+	stream := &countingWriter{w: io.Discard}
+	encoder := gob.NewEncoder(stream)
+	return func(block *blockchainPkg.Block) (int, error) {
+		before := stream.n
+		if err := encoder.Encode([]*blockchainPkg.Block{block}); err != nil {
+			return 0, fmt.Errorf("failed to gob encode block: %w", err)
+		}
+		return stream.n - before, nil
+	}
+}
+
+func (GobSerializer) Unmarshal(data []byte) ([]blockchainPkg.Block, error) {
+	var blocks []blockchainPkg.Block
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&blocks); err != nil {
+		return nil, fmt.Errorf("failed to gob decode blocks: %w", err)
+	}
+	return blocks, nil
+}
+
+// wireBlock is the language-neutral form of a block used by the JSON
+// serializer. Transactions are stored as concrete values instead of the
+// merkletree.Content interface.
+type wireBlock struct {
+	Index        int                         `json:"index"`
+	Timestamp    string                      `json:"timestamp"`
+	Transactions []blockchainPkg.Transaction `json:"transactions"`
+	PrevHash     string                      `json:"prevHash"`
+	Hash         string                      `json:"hash"`
+	MerkleRoot   []byte                      `json:"merkleRoot"`
+	Proof        bool                        `json:"proof"`
+	Difficulty   uint64                      `json:"difficulty,omitempty"`
+	Nonce        uint64                      `json:"nonce,omitempty"`
+	Signer       string                      `json:"signer,omitempty"`
+	Signature    []byte                      `json:"signature,omitempty"`
+	Origin       string                      `json:"origin,omitempty"`
+}
+
+func transactionsOf(block *blockchainPkg.Block) ([]blockchainPkg.Transaction, error) {
+	txs := make([]blockchainPkg.Transaction, len(block.Transactions))
+	for i, content := range block.Transactions {
+		tx, ok := content.(blockchainPkg.Transaction)
+		if !ok {
+			return nil, fmt.Errorf("block %d: unsupported transaction type %T", block.Index, content)
+		}
+		txs[i] = tx
+	}
+	return txs, nil
+}
+
+func contentsOf(txs []blockchainPkg.Transaction) []merkletree.Content {
+	contents := make([]merkletree.Content, len(txs))
+	for i, tx := range txs {
+		contents[i] = tx
+	}
+	return contents
+}
+
+func toWireBlock(block *blockchainPkg.Block) (wireBlock, error) {
+	txs, err := transactionsOf(block)
+	if err != nil {
+		return wireBlock{}, err
+	}
+	return wireBlock{
+		Index:        block.Index,
+		Timestamp:    block.Timestamp,
+		Transactions: txs,
+		PrevHash:     block.PrevHash,
+		Hash:         block.Hash,
+		MerkleRoot:   block.MerkleRoot,
+		Proof:        block.Proof,
+		Difficulty:   block.Difficulty,
+		Nonce:        block.Nonce,
+		Signer:       block.Signer,
+		Signature:    block.Signature,
+		Origin:       block.Origin,
+	}, nil
+}
+
+func (w wireBlock) toBlock() blockchainPkg.Block {
+	return blockchainPkg.Block{
+		Index:        w.Index,
+		Timestamp:    w.Timestamp,
+		Transactions: contentsOf(w.Transactions),
+		PrevHash:     w.PrevHash,
+		Hash:         w.Hash,
+		MerkleRoot:   w.MerkleRoot,
+		Proof:        w.Proof,
+		Difficulty:   w.Difficulty,
+		Nonce:        w.Nonce,
+		Signer:       w.Signer,
+		Signature:    w.Signature,
+		Origin:       w.Origin,
+	}
+}
+
+// JSONSerializer encodes blocks as a JSON array.
+type JSONSerializer struct{}
+
+func (JSONSerializer) Name() string { return SerializationJSON }
+
+func (JSONSerializer) Marshal(blocks []*blockchainPkg.Block) ([]byte, error) {
+	wire := make([]wireBlock, len(blocks))
+	for i, block := range blocks {
+		w, err := toWireBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		wire[i] = w
+	}
+	data, err := json.Marshal(wire)
+	if err != nil {
+		return nil, fmt.Errorf("failed to json encode blocks: %w", err)
+	}
+	return data, nil
+}
+
+func (JSONSerializer) Unmarshal(data []byte) ([]blockchainPkg.Block, error) {
+	var wire []wireBlock
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return nil, fmt.Errorf("failed to json decode blocks: %w", err)
+	}
+	blocks := make([]blockchainPkg.Block, len(wire))
+	for i, w := range wire {
+		blocks[i] = w.toBlock()
+	}
+	return blocks, nil
+}
+
+// rlpTransaction and rlpBlock mirror the block types with RLP-friendly field
+// types: RLP only knows unsigned integers, so the index is stored as uint64
+// and the amount and fee as the IEEE 754 bits of the float.
+type rlpTransaction struct {
+	Sender   string
+	Receiver string
+	Amount   uint64
+	Payload  []byte
+	Nonce    uint64 `rlp:"optional"`
+	Fee      uint64 `rlp:"optional"`
+}
+
+type rlpBlock struct {
+	Index        uint64
+	Timestamp    string
+	Transactions []rlpTransaction
+	PrevHash     string
+	Hash         string
+	MerkleRoot   []byte
+	Proof        bool
+	Difficulty   uint64
+	Nonce        uint64
+	Signer       string
+	Signature    []byte
+	Origin       string `rlp:"optional"`
+}
+
+// RLPSerializer encodes blocks with Ethereum's recursive length prefix.
+type RLPSerializer struct{}
+
+func (RLPSerializer) Name() string { return SerializationRLP }
+
+func (RLPSerializer) Marshal(blocks []*blockchainPkg.Block) ([]byte, error) {
+	wire := make([]rlpBlock, len(blocks))
+	for i, block := range blocks {
+		if block.Index < 0 {
+			return nil, fmt.Errorf("block %d: negative index cannot be rlp encoded", block.Index)
+		}
+		txs, err := transactionsOf(block)
+		if err != nil {
+			return nil, err
+		}
+		rlpTxs := make([]rlpTransaction, len(txs))
+		for j, tx := range txs {
+			rlpTxs[j] = rlpTransaction{
+				Sender:   tx.Sender,
+				Receiver: tx.Receiver,
+				Amount:   math.Float64bits(tx.Amount),
+				Payload:  tx.Payload,
+				Nonce:    tx.Nonce,
+				Fee:      math.Float64bits(tx.Fee),
+			}
+		}
+		wire[i] = rlpBlock{
+			Index:        uint64(block.Index),
+			Timestamp:    block.Timestamp,
+			Transactions: rlpTxs,
+			PrevHash:     block.PrevHash,
+			Hash:         block.Hash,
+			MerkleRoot:   block.MerkleRoot,
+			Proof:        block.Proof,
+			Difficulty:   block.Difficulty,
+			Nonce:        block.Nonce,
+			Signer:       block.Signer,
+			Signature:    block.Signature,
+			Origin:       block.Origin,
+		}
+	}
+	data, err := rlp.EncodeToBytes(wire)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rlp encode blocks: %w", err)
+	}
+	return data, nil
+}
+
+func (RLPSerializer) Unmarshal(data []byte) ([]blockchainPkg.Block, error) {
+	var wire []rlpBlock
+	if err := rlp.DecodeBytes(data, &wire); err != nil {
+		return nil, fmt.Errorf("failed to rlp decode blocks: %w", err)
+	}
+	blocks := make([]blockchainPkg.Block, len(wire))
+	for i, w := range wire {
+		txs := make([]blockchainPkg.Transaction, len(w.Transactions))
+		for j, tx := range w.Transactions {
+			txs[j] = blockchainPkg.Transaction{
+				Sender:   tx.Sender,
+				Receiver: tx.Receiver,
+				Amount:   math.Float64frombits(tx.Amount),
+				Payload:  tx.Payload,
+				Nonce:    tx.Nonce,
+				Fee:      math.Float64frombits(tx.Fee),
+			}
+		}
+		blocks[i] = blockchainPkg.Block{
+			Index:        int(w.Index),
+			Timestamp:    w.Timestamp,
+			Transactions: contentsOf(txs),
+			PrevHash:     w.PrevHash,
+			Hash:         w.Hash,
+			MerkleRoot:   w.MerkleRoot,
+			Proof:        w.Proof,
+			Difficulty:   w.Difficulty,
+			Nonce:        w.Nonce,
+			Signer:       w.Signer,
+			Signature:    w.Signature,
+			Origin:       w.Origin,
+		}
+	}
+	return blocks, nil
+}
@@ -0,0 +1,240 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	blockchainPkg "github.com/xm0onh/thesis/packages/blockchain"
+)
+
+// Field numbers of the messages in blocks.proto.
+const (
+	pbBlocksBlock = 1
+
+	pbBlockIndex        = 1
+	pbBlockTimestamp    = 2
+	pbBlockTransactions = 3
+	pbBlockPrevHash     = 4
+	pbBlockHash         = 5
+	pbBlockMerkleRoot   = 6
+	pbBlockProof        = 7
+	pbBlockDifficulty   = 8
+	pbBlockNonce        = 9
+	pbBlockSigner       = 10
+	pbBlockSignature    = 11
+	pbBlockOrigin       = 12
+
+	pbTxSender   = 1
+	pbTxReceiver = 2
+	pbTxAmount   = 3
+	pbTxPayload  = 4
+	pbTxNonce    = 5
+	pbTxFee      = 6
+)
+
+// ProtobufSerializer encodes blocks as the Blocks message from blocks.proto.
+// The wire format is written directly with protowire so no generated code is
+// needed on the Go side.
+type ProtobufSerializer struct{}
+
+func (ProtobufSerializer) Name() string { return SerializationProtobuf }
+
+func (ProtobufSerializer) Marshal(blocks []*blockchainPkg.Block) ([]byte, error) {
+	var out []byte
+	for _, block := range blocks {
+		txs, err := transactionsOf(block)
+		if err != nil {
+			return nil, err
+		}
+		out = protowire.AppendTag(out, pbBlocksBlock, protowire.BytesType)
+		out = protowire.AppendBytes(out, appendProtoBlock(nil, block, txs))
+	}
+	return out, nil
+}
+
+func appendProtoBlock(b []byte, block *blockchainPkg.Block, txs []blockchainPkg.Transaction) []byte {
+	b = protowire.AppendTag(b, pbBlockIndex, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(int64(block.Index)))
+	b = protowire.AppendTag(b, pbBlockTimestamp, protowire.BytesType)
+	b = protowire.AppendString(b, block.Timestamp)
+	for _, tx := range txs {
+		var t []byte
+		t = protowire.AppendTag(t, pbTxSender, protowire.BytesType)
+		t = protowire.AppendString(t, tx.Sender)
+		t = protowire.AppendTag(t, pbTxReceiver, protowire.BytesType)
+		t = protowire.AppendString(t, tx.Receiver)
+		t = protowire.AppendTag(t, pbTxAmount, protowire.Fixed64Type)
+		t = protowire.AppendFixed64(t, math.Float64bits(tx.Amount))
+		if len(tx.Payload) > 0 {
+			t = protowire.AppendTag(t, pbTxPayload, protowire.BytesType)
+			t = protowire.AppendBytes(t, tx.Payload)
+		}
+		if tx.Nonce != 0 || tx.Fee != 0 {
+			t = protowire.AppendTag(t, pbTxNonce, protowire.VarintType)
+			t = protowire.AppendVarint(t, tx.Nonce)
+			t = protowire.AppendTag(t, pbTxFee, protowire.Fixed64Type)
+			t = protowire.AppendFixed64(t, math.Float64bits(tx.Fee))
+		}
+		b = protowire.AppendTag(b, pbBlockTransactions, protowire.BytesType)
+		b = protowire.AppendBytes(b, t)
+	}
+	b = protowire.AppendTag(b, pbBlockPrevHash, protowire.BytesType)
+	b = protowire.AppendString(b, block.PrevHash)
+	b = protowire.AppendTag(b, pbBlockHash, protowire.BytesType)
+	b = protowire.AppendString(b, block.Hash)
+	b = protowire.AppendTag(b, pbBlockMerkleRoot, protowire.BytesType)
+	b = protowire.AppendBytes(b, block.MerkleRoot)
+	b = protowire.AppendTag(b, pbBlockProof, protowire.VarintType)
+	b = protowire.AppendVarint(b, protowire.EncodeBool(block.Proof))
+	if block.Difficulty != 0 || block.Nonce != 0 || block.Signer != "" {
+		b = protowire.AppendTag(b, pbBlockDifficulty, protowire.VarintType)
+		b = protowire.AppendVarint(b, block.Difficulty)
+		b = protowire.AppendTag(b, pbBlockNonce, protowire.VarintType)
+		b = protowire.AppendVarint(b, block.Nonce)
+		b = protowire.AppendTag(b, pbBlockSigner, protowire.BytesType)
+		b = protowire.AppendString(b, block.Signer)
+		b = protowire.AppendTag(b, pbBlockSignature, protowire.BytesType)
+		b = protowire.AppendBytes(b, block.Signature)
+	}
+	if block.Origin != "" {
+		b = protowire.AppendTag(b, pbBlockOrigin, protowire.BytesType)
+		b = protowire.AppendString(b, block.Origin)
+	}
+	return b
+}
+
+func (ProtobufSerializer) Unmarshal(data []byte) ([]blockchainPkg.Block, error) {
+	var blocks []blockchainPkg.Block
+	err := walkProto(data, func(num protowire.Number, typ protowire.Type, field []byte) (int, error) {
+		if num != pbBlocksBlock || typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, field), nil
+		}
+		v, n := protowire.ConsumeBytes(field)
+		if n < 0 {
+			return n, nil
+		}
+		block, err := parseProtoBlock(v)
+		if err != nil {
+			return 0, err
+		}
+		blocks = append(blocks, block)
+		return n, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to protobuf decode blocks: %w", err)
+	}
+	return blocks, nil
+}
+
+func parseProtoBlock(data []byte) (blockchainPkg.Block, error) {
+	var block blockchainPkg.Block
+	var txs []blockchainPkg.Transaction
+	err := walkProto(data, func(num protowire.Number, typ protowire.Type, field []byte) (int, error) {
+		switch {
+		case num == pbBlockIndex && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(field)
+			block.Index = int(int64(v))
+			return n, nil
+		case num == pbBlockProof && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(field)
+			block.Proof = protowire.DecodeBool(v)
+			return n, nil
+		case num == pbBlockDifficulty && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(field)
+			block.Difficulty = v
+			return n, nil
+		case num == pbBlockNonce && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(field)
+			block.Nonce = v
+			return n, nil
+		case typ != protowire.BytesType:
+			return protowire.ConsumeFieldValue(num, typ, field), nil
+		}
+		v, n := protowire.ConsumeBytes(field)
+		if n < 0 {
+			return n, nil
+		}
+		switch num {
+		case pbBlockTimestamp:
+			block.Timestamp = string(v)
+		case pbBlockTransactions:
+			tx, err := parseProtoTransaction(v)
+			if err != nil {
+				return 0, err
+			}
+			txs = append(txs, tx)
+		case pbBlockPrevHash:
+			block.PrevHash = string(v)
+		case pbBlockHash:
+			block.Hash = string(v)
+		case pbBlockMerkleRoot:
+			block.MerkleRoot = append([]byte(nil), v...)
+		case pbBlockSigner:
+			block.Signer = string(v)
+		case pbBlockSignature:
+			block.Signature = append([]byte(nil), v...)
+		case pbBlockOrigin:
+			block.Origin = string(v)
+		}
+		return n, nil
+	})
+	block.Transactions = contentsOf(txs)
+	return block, err
+}
+
+func parseProtoTransaction(data []byte) (blockchainPkg.Transaction, error) {
+	var tx blockchainPkg.Transaction
+	err := walkProto(data, func(num protowire.Number, typ protowire.Type, field []byte) (int, error) {
+		switch {
+		case num == pbTxAmount && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(field)
+			tx.Amount = math.Float64frombits(v)
+			return n, nil
+		case num == pbTxNonce && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(field)
+			tx.Nonce = v
+			return n, nil
+		case num == pbTxFee && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(field)
+			tx.Fee = math.Float64frombits(v)
+			return n, nil
+		case typ != protowire.BytesType:
+			return protowire.ConsumeFieldValue(num, typ, field), nil
+		}
+		v, n := protowire.ConsumeBytes(field)
+		switch num {
+		case pbTxSender:
+			tx.Sender = string(v)
+		case pbTxReceiver:
+			tx.Receiver = string(v)
+		case pbTxPayload:
+			tx.Payload = append([]byte(nil), v...)
+		}
+		return n, nil
+	})
+	return tx, err
+}
+
+// walkProto calls visit for every field in a protobuf message. visit returns
+// how many bytes of the field value it consumed, or a negative protowire
+// error code.
+func walkProto(data []byte, visit func(num protowire.Number, typ protowire.Type, field []byte) (int, error)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		m, err := visit(num, typ, data)
+		if err != nil {
+			return err
+		}
+		if m < 0 {
+			return protowire.ParseError(m)
+		}
+		data = data[m:]
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	blockchainPkg "github.com/xm0onh/thesis/packages/blockchain"
+)
+
+func testChain(t *testing.T) *blockchainPkg.Blockchain {
+	t.Helper()
+	bc, err := InitializeBlockchain(3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Exercise the optional fields as well.
+	tip := &bc.Chain[2]
+	tip.Difficulty, tip.Nonce, tip.Signer, tip.Signature = 8, 42, "0xabc", []byte{1, 2, 3}
+	tip.Origin = blockchainPkg.OriginEthereum
+	tx := tip.Transactions[0].(blockchainPkg.Transaction)
+	tx.Payload, tx.Nonce, tx.Fee = []byte("payload"), 7, 1.5
+	tip.Transactions[0] = tx
+	return bc
+}
+
+func checkSameBlocks(t *testing.T, want []blockchainPkg.Block, got []blockchainPkg.Block) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d blocks, want %d", len(got), len(want))
+	}
+	for i := range want {
+		w, g := want[i], got[i]
+		if g.Index != w.Index || g.Timestamp != w.Timestamp || g.PrevHash != w.PrevHash || g.Hash != w.Hash ||
+			!bytes.Equal(g.MerkleRoot, w.MerkleRoot) || g.Proof != w.Proof ||
+			g.Difficulty != w.Difficulty || g.Nonce != w.Nonce || g.Signer != w.Signer || !bytes.Equal(g.Signature, w.Signature) ||
+			g.Origin != w.Origin {
+			t.Fatalf("block %d: got %+v, want %+v", i, g, w)
+		}
+		if len(g.Transactions) != len(w.Transactions) {
+			t.Fatalf("block %d: got %d transactions, want %d", i, len(g.Transactions), len(w.Transactions))
+		}
+		for j := range w.Transactions {
+			if ok, err := w.Transactions[j].Equals(g.Transactions[j]); err != nil || !ok {
+				t.Fatalf("block %d transaction %d: got %+v, want %+v (%v)", i, j, g.Transactions[j], w.Transactions[j], err)
+			}
+		}
+	}
+}
+
+func TestSerializersRoundTrip(t *testing.T) {
+	bc := testChain(t)
+	for _, name := range []string{SerializationGob, SerializationJSON, SerializationProtobuf, SerializationRLP} {
+		t.Run(name, func(t *testing.T) {
+			s, err := SerializerByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := SerializeBlockchain(s, bc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DeserializeBlockchain(s, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSameBlocks(t, bc.Chain, decoded.Chain)
+		})
+	}
+}
+
+func TestSerializerByNameRejectsUnknown(t *testing.T) {
+	if _, err := SerializerByName("xml"); err == nil {
+		t.Fatal("unknown serialization was accepted")
+	}
+}
+
+func TestBlockchainToBytesKeepsGobStructFormat(t *testing.T) {
+	bc := testChain(t)
+	// Blobs written before serializers existed: the gob-encoded struct.
+	var old bytes.Buffer
+	if err := gob.NewEncoder(&old).Encode(bc); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := BytesToBlockchain(old.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSameBlocks(t, bc.Chain, decoded.Chain)
+
+	data, err := BlockchainToBytes(bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, old.Bytes()) {
+		t.Fatal("BlockchainToBytes changed the encoding of the Blockchain struct")
+	}
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	blockchainPkg "github.com/xm0onh/thesis/packages/blockchain"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+)
+
+// MessageKey is the blob the message of a run is uploaded to.
+const MessageKey = "blockchain_data"
+
+// CommitFunc publishes the commitment of a run and records it in record.
+// param holds the compressed message.
+type CommitFunc func(ctx context.Context, blobs BlobStore, event StartSignal, param SetupParameters, record *SetupRecord) error
+
+// SetupConfig is what the setup stage takes from its environment rather
+// than from the start signal.
+type SetupConfig struct {
+	// TransactionsPerBlock sizes synthetic blocks without a workload.
+	TransactionsPerBlock int
+	// BlockStoreDir, if set, keeps the chain across runs; see
+	// LoadOrInitializeBlockchain.
+	BlockStoreDir string
+	// DropletKey is the out-of-band session key; see ParseDropletKey.
+	DropletKey string
+	// Commit runs once the message and its manifest are uploaded, before
+	// the record is saved.
+	Commit CommitFunc
+}
+
+// RunSetup performs the setup stage of a run: it builds the chain and the
+// message for event, compresses and uploads the message, commits to it and
+// saves the record of the run, which it returns. If the chain is imported
+// and event requests no blocks, every imported block is encoded.
+func RunSetup(ctx context.Context, stores Stores, event StartSignal, config SetupConfig) (SetupRecord, error) {
+	degreeCDF := lubyTransform.SolitonDistribution(event.SourceBlocks)
+	seed := time.Now().UnixNano()
+
+	build := func() (*blockchainPkg.Blockchain, error) {
+		return BuildBlockchain(event, config.TransactionsPerBlock)
+	}
+	var blockchain *blockchainPkg.Blockchain
+	var err error
+	if config.BlockStoreDir != "" {
+		blockchain, err = LoadOrInitializeBlockchain(config.BlockStoreDir, event.NumberOfBlocks, build)
+	} else {
+		blockchain, err = build()
+	}
+	if err != nil {
+		return SetupRecord{}, fmt.Errorf("failed to build blockchain: %w", err)
+	}
+	request := RequestedBlocks{
+		BlockNumber: event.RequestedBlocks,
+		BlockHashes: event.RequestedBlockHashes,
+		Ranges:      event.RequestedBlockRanges,
+	}
+	if event.EthereumBlocks != nil && len(blockchain.Chain) > 0 && len(request.BlockNumber)+len(request.BlockHashes)+len(request.Ranges) == 0 {
+		// Encode every imported block, by its Ethereum block number.
+		first, last := blockchain.Chain[0].Index, blockchain.Chain[len(blockchain.Chain)-1].Index
+		event.NumberOfBlocks = len(blockchain.Chain)
+		request.Ranges = []BlockRange{{Start: first, End: last + 1}}
+	}
+
+	serializer, err := SerializerByName(event.Serialization)
+	if err != nil {
+		return SetupRecord{}, err
+	}
+	compressor, err := CompressorByName(event.Compression)
+	if err != nil {
+		return SetupRecord{}, err
+	}
+	requestedBlocks, err := ResolveRequestedBlocks(blockchain, request)
+	if err != nil {
+		return SetupRecord{}, fmt.Errorf("failed to resolve requested blocks: %w", err)
+	}
+	message, messageStats, manifest, err := CalculateMessageAndMessageSize(blockchain, requestedBlocks, serializer)
+	if err != nil {
+		return SetupRecord{}, fmt.Errorf("failed to build message: %w", err)
+	}
+	fmt.Printf("Built a %d byte message, %d bytes of it framing\n", messageStats.Size, messageStats.FramingOverhead)
+
+	// Responders seal droplets with the out-of-band key; only its
+	// fingerprint goes into the setup table.
+	key, err := ParseDropletKey(config.DropletKey)
+	if err != nil {
+		return SetupRecord{}, err
+	}
+	encryption, dropletKeyID, err := SessionEncryption(event.Encryption, key)
+	if err != nil {
+		return SetupRecord{}, err
+	}
+
+	// The message is compressed once; the blob store keeps the compressed
+	// message, which responders encode as is.
+	compressed, compressionStats, err := CompressMessage(compressor, message)
+	if err != nil {
+		return SetupRecord{}, err
+	}
+	fmt.Printf("Compressed message with %s: %d -> %d bytes (ratio %.2f) in %s\n", compressionStats.Compression,
+		compressionStats.UncompressedSize, compressionStats.CompressedSize, compressionStats.Ratio, compressionStats.Duration)
+
+	setupID, err := NewSetupID()
+	if err != nil {
+		return SetupRecord{}, err
+	}
+	// Encrypted sessions must not leave the message readable either.
+	stored := compressed
+	if encryption != EncryptionNone {
+		messageCipher, err := NewDropletCipher(key, setupID)
+		if err != nil {
+			return SetupRecord{}, err
+		}
+		stored, err = messageCipher.SealMessage(compressed)
+		if err != nil {
+			return SetupRecord{}, err
+		}
+	}
+	if err := stores.Blobs.Put(ctx, MessageKey, stored); err != nil {
+		return SetupRecord{}, fmt.Errorf("failed to upload message: %w", err)
+	}
+
+	param := SetupParameters{
+		SetupID:         setupID,
+		DegreeCDF:       degreeCDF,
+		RandomSeed:      seed,
+		SourceBlocks:    event.SourceBlocks,
+		EncodedBlockIDs: event.EncodedBlockIDs,
+		NumberOfBlocks:  event.NumberOfBlocks,
+		MessageSize:     compressionStats.CompressedSize,
+		Message:         compressed,
+		Serialization:   serializer.Name(),
+		Compression:     compressor.Name(),
+		Encryption:      encryption,
+		DropletKeyID:    dropletKeyID,
+	}
+
+	// Record the run so responders and the decoder use the same parameters.
+	record := NewSetupRecord(param, MessageKey)
+	record.RequestedBlocks = requestedBlocks
+	record.SetCompressionStats(compressionStats)
+	record.FramingOverhead = messageStats.FramingOverhead
+	record.ManifestKey, err = PublishManifest(ctx, stores.Blobs, manifest)
+	if err != nil {
+		return SetupRecord{}, err
+	}
+	if config.Commit != nil {
+		if err := config.Commit(ctx, stores.Blobs, event, param, &record); err != nil {
+			return SetupRecord{}, err
+		}
+	}
+	if err := SaveSetup(ctx, stores.Setup, record); err != nil {
+		return SetupRecord{}, fmt.Errorf("failed to put setup item: %w", err)
+	}
+	return record, nil
+}
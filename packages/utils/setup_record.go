@@ -0,0 +1,680 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// SetupRecordVersion is the schema version written by SaveSetup. LoadSetup
+// rejects records of any other version.
+const SetupRecordVersion = 7
+
+var (
+	ErrInvalidSetup = errors.New("invalid setup record")
+	ErrSetupVersion = errors.New("unsupported setup record version")
+)
+
+// Droplet commitment schemes. CommitmentKZG commits to the SHA-256 hash of
+// each droplet with KZG; CommitmentKZGData commits to the droplet bytes
+// themselves in 31-byte chunks, so that single chunks can be opened and
+// checked. CommitmentMerkle is a binary SHA-256 Merkle tree over the droplet
+// hashes, which needs no trusted setup but proves each droplet with a path
+// of log(n) hashes.
+const (
+	CommitmentKZG     = "kzg"
+	CommitmentKZGData = "kzg-data"
+	CommitmentMerkle  = "merkle"
+)
+
+// CommitmentScheme returns the droplet commitment selected by name. An
+// empty name selects CommitmentKZG.
+func CommitmentScheme(name string) (string, error) {
+	switch name {
+	case "", CommitmentKZG:
+		return CommitmentKZG, nil
+	case CommitmentKZGData, CommitmentMerkle:
+		return name, nil
+	default:
+		return "", fmt.Errorf("unknown droplet commitment %q", name)
+	}
+}
+
+// Codings of the message. CodingLT fountain-codes it into droplets;
+// CodingRS2D lays it out as a square matrix extended with Reed-Solomon in
+// both dimensions, see MatrixSetup.
+const (
+	CodingLT   = "lt"
+	CodingRS2D = "rs2d"
+)
+
+// CodingByName returns the coding selected by name. An empty name selects
+// CodingLT.
+func CodingByName(name string) (string, error) {
+	switch name {
+	case "", CodingLT:
+		return CodingLT, nil
+	case CodingRS2D:
+		return CodingRS2D, nil
+	default:
+		return "", fmt.Errorf("unknown coding %q", name)
+	}
+}
+
+// CommitmentSetup is the droplet commitment that setupEC2 publishes: the
+// Scheme, one of the Commitment constants, the Digest it produced (a KZG
+// commitment or a Merkle root) and the blob key of the per-droplet proofs,
+// which responders attach to the droplets they store. The KZG schemes add
+// the serialized SRS; G2Powers, if set, are the extra powers of the secret
+// in G2 that verifying a multi-opening needs, and responders then publish
+// one proof per range of droplets instead. DropletSize is the longest
+// droplet, which fixes the number of chunks per droplet under
+// CommitmentKZGData.
+//
+// The SRS and the G2 powers grow with the droplets and live in the blob
+// store: the setup item records only SRSBlob and G2PowersBlob, and
+// LoadSetup fills SRS and G2Powers from them.
+type CommitmentSetup struct {
+	SRS         []byte
+	Digest      []byte
+	ProofsKey   string
+	G2Powers    []byte
+	Scheme      string
+	DropletSize int
+
+	SRSBlob      BlobRef
+	G2PowersBlob BlobRef
+}
+
+// BlobRef names a blob of setup material too large for the setup item and
+// pins its content by its SHA-256.
+type BlobRef struct {
+	Key  string
+	Hash []byte
+}
+
+// PutBlob uploads data to blobs under key and returns a reference to it.
+func PutBlob(ctx context.Context, blobs BlobStore, key string, data []byte) (BlobRef, error) {
+	if err := blobs.Put(ctx, key, data); err != nil {
+		return BlobRef{}, fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	hash := sha256.Sum256(data)
+	return BlobRef{Key: key, Hash: hash[:]}, nil
+}
+
+// Load fetches the blob from blobs and checks it against the hash.
+func (b BlobRef) Load(ctx context.Context, blobs BlobStore) ([]byte, error) {
+	data, err := blobs.Get(ctx, b.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", b.Key, err)
+	}
+	if hash := sha256.Sum256(data); !bytes.Equal(hash[:], b.Hash) {
+		return nil, fmt.Errorf("%s does not match the hash the setup recorded: %w", b.Key, ErrInvalidSetup)
+	}
+	return data, nil
+}
+
+// loadBlobs fills the setup material kept in the blob store.
+func (r SetupRecord) loadBlobs(ctx context.Context, blobs BlobStore) error {
+	if c := r.Commitment; c != nil {
+		var err error
+		if c.SRSBlob.Key != "" {
+			if c.SRS, err = c.SRSBlob.Load(ctx, blobs); err != nil {
+				return err
+			}
+		}
+		if c.G2PowersBlob.Key != "" {
+			if c.G2Powers, err = c.G2PowersBlob.Load(ctx, blobs); err != nil {
+				return err
+			}
+		}
+	}
+	if m := r.Matrix; m != nil {
+		var err error
+		if m.SRS, err = m.SRSBlob.Load(ctx, blobs); err != nil {
+			return err
+		}
+		if m.Rows, err = m.RowsBlob.Load(ctx, blobs); err != nil {
+			return err
+		}
+		if m.Columns, err = m.ColumnsBlob.Load(ctx, blobs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MatrixSetup is what a two-dimensional run publishes in place of droplets:
+// the message is laid out as a K×K matrix of field elements, every row and
+// column is extended with Reed-Solomon to a 2K×2K matrix, and each of its
+// rows and columns is committed with KZG. Rows and Columns are the 2K
+// compressed digests of each, concatenated; SRS is the serialized SRS they
+// were made with.
+//
+// All three grow with K and live in the blob store: the setup item records
+// only SRSBlob, RowsBlob and ColumnsBlob, and LoadSetup fills SRS, Rows and
+// Columns from them.
+type MatrixSetup struct {
+	K       int
+	SRS     []byte
+	Rows    []byte
+	Columns []byte
+
+	SRSBlob     BlobRef
+	RowsBlob    BlobRef
+	ColumnsBlob BlobRef
+}
+
+// SetupRecord is everything the setup stage publishes for a run. The message
+// itself lives in the blob store under MessageKey.
+type SetupRecord struct {
+	Version int
+	SetupID string
+
+	DegreeCDF       []float64
+	RandomSeed      int64
+	SourceBlocks    int
+	EncodedBlockIDs int
+	NumberOfBlocks  int
+	RequestedBlocks []int
+
+	MessageKey    string
+	MessageSize   int
+	Serialization string
+	ManifestKey   string
+	// FramingOverhead is the part of RawMessageSize spent on framing the
+	// blocks; see MessageStats.
+	FramingOverhead int
+
+	Compression      string
+	RawMessageSize   int
+	CompressionRatio float64
+	CompressionTime  time.Duration
+	Encryption       string
+	DropletKeyID     string
+
+	// Commitment is only set by setups that publish a commitment to the
+	// droplets.
+	Commitment *CommitmentSetup
+
+	// Matrix is only set by two-dimensional runs, whose responders store
+	// cells of the extended matrix instead of droplets.
+	Matrix *MatrixSetup
+}
+
+// NewSetupID returns a random ID for a new run.
+func NewSetupID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", fmt.Errorf("failed to generate setup ID: %w", err)
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+// NewSetupRecord starts a record from the parameters the droplets were
+// generated with.
+func NewSetupRecord(param SetupParameters, messageKey string) SetupRecord {
+	return SetupRecord{
+		Version:         SetupRecordVersion,
+		SetupID:         param.SetupID,
+		DegreeCDF:       param.DegreeCDF,
+		RandomSeed:      param.RandomSeed,
+		SourceBlocks:    param.SourceBlocks,
+		EncodedBlockIDs: param.EncodedBlockIDs,
+		NumberOfBlocks:  param.NumberOfBlocks,
+		MessageKey:      messageKey,
+		MessageSize:     param.MessageSize,
+		Serialization:   param.Serialization,
+		Compression:     param.Compression,
+		RawMessageSize:  param.MessageSize,
+		Encryption:      param.Encryption,
+		DropletKeyID:    param.DropletKeyID,
+	}
+}
+
+// SetCompressionStats records the compression stage of the run.
+func (r *SetupRecord) SetCompressionStats(stats CompressionStats) {
+	r.Compression = stats.Compression
+	r.RawMessageSize = stats.UncompressedSize
+	r.MessageSize = stats.CompressedSize
+	r.CompressionRatio = stats.Ratio
+	r.CompressionTime = stats.Duration
+}
+
+// Parameters returns the codec parameters of the record. The message is not
+// included; see LoadMessage.
+func (r SetupRecord) Parameters() SetupParameters {
+	return SetupParameters{
+		SetupID:         r.SetupID,
+		DegreeCDF:       r.DegreeCDF,
+		RandomSeed:      r.RandomSeed,
+		SourceBlocks:    r.SourceBlocks,
+		EncodedBlockIDs: r.EncodedBlockIDs,
+		NumberOfBlocks:  r.NumberOfBlocks,
+		MessageSize:     r.MessageSize,
+		Serialization:   r.Serialization,
+		Compression:     r.Compression,
+		Encryption:      r.Encryption,
+		DropletKeyID:    r.DropletKeyID,
+	}
+}
+
+// LoadMessage fetches the message of the run from blobs, compressed as
+// setup left it for the fountain encoder. Sessions that encrypt droplets
+// store the message sealed; c, the session's cipher, opens it.
+func (r SetupRecord) LoadMessage(ctx context.Context, blobs BlobStore, c *DropletCipher) ([]byte, error) {
+	message, err := blobs.Get(ctx, r.MessageKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message %s: %w", r.MessageKey, err)
+	}
+	if r.Encryption != EncryptionNone {
+		if c == nil {
+			return nil, fmt.Errorf("message %s is sealed but no droplet cipher was given", r.MessageKey)
+		}
+		if message, err = c.OpenMessage(message); err != nil {
+			return nil, fmt.Errorf("message %s: %w", r.MessageKey, err)
+		}
+	}
+	if len(message) != r.MessageSize {
+		return nil, fmt.Errorf("message %s has %d bytes, setup recorded %d: %w", r.MessageKey, len(message), r.MessageSize, ErrInvalidSetup)
+	}
+	return message, nil
+}
+
+// EncodeDropletProofs serializes the opening proofs of a run, indexed by
+// droplet BlockCode, for the blob named by CommitmentSetup.ProofsKey.
+func EncodeDropletProofs(proofs [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(proofs); err != nil {
+		return nil, fmt.Errorf("failed to encode droplet proofs: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// LoadDropletProofs fetches the per-droplet opening proofs, indexed by
+// BlockCode. It returns nil when the setup publishes no commitment.
+func (r SetupRecord) LoadDropletProofs(ctx context.Context, blobs BlobStore) ([][]byte, error) {
+	if r.Commitment == nil {
+		return nil, nil
+	}
+	data, err := blobs.Get(ctx, r.Commitment.ProofsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load droplet proofs %s: %w", r.Commitment.ProofsKey, err)
+	}
+	var proofs [][]byte
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&proofs); err != nil {
+		return nil, fmt.Errorf("failed to decode droplet proofs %s: %v: %w", r.Commitment.ProofsKey, err, ErrInvalidSetup)
+	}
+	if len(proofs) != r.EncodedBlockIDs {
+		return nil, fmt.Errorf("%d droplet proofs for %d droplets: %w", len(proofs), r.EncodedBlockIDs, ErrInvalidSetup)
+	}
+	return proofs, nil
+}
+
+// Validate checks that the record is complete and consistent.
+func (r SetupRecord) Validate() error {
+	if r.Version != SetupRecordVersion {
+		return fmt.Errorf("version %d, want %d: %w", r.Version, SetupRecordVersion, ErrSetupVersion)
+	}
+	var problems []string
+	if r.SetupID == "" {
+		problems = append(problems, "setup ID is empty")
+	}
+	if len(r.DegreeCDF) == 0 {
+		problems = append(problems, "degree CDF is empty")
+	}
+	if r.SourceBlocks <= 0 {
+		problems = append(problems, "source blocks must be positive")
+	}
+	if r.EncodedBlockIDs < r.SourceBlocks {
+		problems = append(problems, fmt.Sprintf("%d encoded blocks cannot cover %d source blocks", r.EncodedBlockIDs, r.SourceBlocks))
+	}
+	if r.MessageKey == "" {
+		problems = append(problems, "message key is empty")
+	}
+	if r.MessageSize <= 0 || r.RawMessageSize <= 0 {
+		problems = append(problems, "message sizes must be positive")
+	}
+	if _, err := SerializerByName(r.Serialization); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := CompressorByName(r.Compression); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if r.Compression == CompressionNone && r.MessageSize != r.RawMessageSize {
+		problems = append(problems, fmt.Sprintf("uncompressed message size %d differs from raw size %d", r.MessageSize, r.RawMessageSize))
+	}
+	switch r.Encryption {
+	case EncryptionNone:
+		if r.DropletKeyID != "" {
+			problems = append(problems, "droplet key ID set without encryption")
+		}
+	case EncryptionAESGCM:
+		if r.DropletKeyID == "" {
+			problems = append(problems, "encrypted droplets without a droplet key ID")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown encryption %q", r.Encryption))
+	}
+	if r.ManifestKey == "" {
+		problems = append(problems, "manifest key is empty")
+	}
+	if r.Commitment != nil {
+		if len(r.Commitment.Digest) == 0 || r.Commitment.ProofsKey == "" {
+			problems = append(problems, "droplet commitment is incomplete")
+		}
+		switch r.Commitment.Scheme {
+		case CommitmentKZG, CommitmentKZGData, CommitmentMerkle:
+		default:
+			problems = append(problems, fmt.Sprintf("unknown droplet commitment %q", r.Commitment.Scheme))
+		}
+		if r.Commitment.Scheme == CommitmentMerkle {
+			if r.Commitment.SRSBlob.Key != "" || r.Commitment.G2PowersBlob.Key != "" {
+				problems = append(problems, "Merkle commitment with KZG parameters")
+			}
+		} else if r.Commitment.SRSBlob.Key == "" {
+			problems = append(problems, "KZG commitment without an SRS")
+		}
+		if r.Commitment.Scheme == CommitmentKZGData {
+			if r.Commitment.DropletSize <= 0 {
+				problems = append(problems, "data commitment without a droplet size")
+			}
+			if r.Commitment.G2PowersBlob.Key == "" {
+				problems = append(problems, "data commitment without G2 powers")
+			}
+		}
+		for _, b := range []BlobRef{r.Commitment.SRSBlob, r.Commitment.G2PowersBlob} {
+			if b.Key != "" && len(b.Hash) != sha256.Size {
+				problems = append(problems, b.Key+" without a hash")
+			}
+		}
+	}
+	if m := r.Matrix; m != nil {
+		if m.K <= 0 || m.K&(m.K-1) != 0 {
+			problems = append(problems, fmt.Sprintf("matrix size %d is not a power of two", m.K))
+		}
+		for _, b := range []BlobRef{m.SRSBlob, m.RowsBlob, m.ColumnsBlob} {
+			if b.Key == "" || len(b.Hash) != sha256.Size {
+				problems = append(problems, "matrix setup is missing a blob")
+				break
+			}
+		}
+		if r.Commitment != nil {
+			problems = append(problems, "two-dimensional run with a droplet commitment")
+		}
+		if r.Encryption != EncryptionNone {
+			problems = append(problems, "two-dimensional runs do not encrypt cells")
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %w", strings.Join(problems, "; "), ErrInvalidSetup)
+	}
+	return nil
+}
+
+// SaveSetup validates r and writes it to store.
+func SaveSetup(ctx context.Context, store SetupStore, r SetupRecord) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	item, err := r.item()
+	if err != nil {
+		return err
+	}
+	return store.PutSetup(ctx, item)
+}
+
+// LoadSetup reads the setup record from store and the setup material it
+// keeps in blobs. Records with missing or malformed attributes, or of
+// another schema version, are rejected, and so is material that does not
+// match its recorded hash.
+func LoadSetup(ctx context.Context, store SetupStore, blobs BlobStore) (SetupRecord, error) {
+	item, err := store.GetSetup(ctx)
+	if err != nil {
+		return SetupRecord{}, err
+	}
+	r, err := setupRecordFromItem(item)
+	if err != nil {
+		return SetupRecord{}, err
+	}
+	if err := r.Validate(); err != nil {
+		return SetupRecord{}, err
+	}
+	if err := r.loadBlobs(ctx, blobs); err != nil {
+		return SetupRecord{}, err
+	}
+	return r, nil
+}
+
+func (r SetupRecord) item() (map[string]types.AttributeValue, error) {
+	degreeCDF, err := json.Marshal(r.DegreeCDF)
+	if err != nil {
+		return nil, err
+	}
+	requestedBlocks, err := json.Marshal(r.RequestedBlocks)
+	if err != nil {
+		return nil, err
+	}
+	item := map[string]types.AttributeValue{
+		"schemaVersion":     &types.AttributeValueMemberN{Value: strconv.Itoa(r.Version)},
+		"setupID":           &types.AttributeValueMemberS{Value: r.SetupID},
+		"degreeCDF":         &types.AttributeValueMemberS{Value: string(degreeCDF)},
+		"randomSeed":        &types.AttributeValueMemberN{Value: strconv.FormatInt(r.RandomSeed, 10)},
+		"sourceBlocks":      &types.AttributeValueMemberN{Value: strconv.Itoa(r.SourceBlocks)},
+		"encodedBlockIDs":   &types.AttributeValueMemberN{Value: strconv.Itoa(r.EncodedBlockIDs)},
+		"numberOfBlocks":    &types.AttributeValueMemberN{Value: strconv.Itoa(r.NumberOfBlocks)},
+		"requestedBlocks":   &types.AttributeValueMemberS{Value: string(requestedBlocks)},
+		"messageKey":        &types.AttributeValueMemberS{Value: r.MessageKey},
+		"messageSize":       &types.AttributeValueMemberN{Value: strconv.Itoa(r.MessageSize)},
+		"serialization":     &types.AttributeValueMemberS{Value: r.Serialization},
+		"manifestKey":       &types.AttributeValueMemberS{Value: r.ManifestKey},
+		"framingOverhead":   &types.AttributeValueMemberN{Value: strconv.Itoa(r.FramingOverhead)},
+		"compression":       &types.AttributeValueMemberS{Value: r.Compression},
+		"rawMessageSize":    &types.AttributeValueMemberN{Value: strconv.Itoa(r.RawMessageSize)},
+		"compressionRatio":  &types.AttributeValueMemberN{Value: strconv.FormatFloat(r.CompressionRatio, 'f', -1, 64)},
+		"compressionMicros": &types.AttributeValueMemberN{Value: strconv.FormatInt(r.CompressionTime.Microseconds(), 10)},
+		"encryption":        &types.AttributeValueMemberS{Value: r.Encryption},
+		"dropletKeyID":      &types.AttributeValueMemberS{Value: r.DropletKeyID},
+	}
+	if r.Commitment != nil {
+		item["digest"] = &types.AttributeValueMemberB{Value: r.Commitment.Digest}
+		item["dropletProofsKey"] = &types.AttributeValueMemberS{Value: r.Commitment.ProofsKey}
+		item["commitment"] = &types.AttributeValueMemberS{Value: r.Commitment.Scheme}
+		item["dropletSize"] = &types.AttributeValueMemberN{Value: strconv.Itoa(r.Commitment.DropletSize)}
+		if b := r.Commitment.SRSBlob; b.Key != "" {
+			item["srsKey"] = &types.AttributeValueMemberS{Value: b.Key}
+			item["srsHash"] = &types.AttributeValueMemberB{Value: b.Hash}
+		}
+		if b := r.Commitment.G2PowersBlob; b.Key != "" {
+			item["g2PowersKey"] = &types.AttributeValueMemberS{Value: b.Key}
+			item["g2PowersHash"] = &types.AttributeValueMemberB{Value: b.Hash}
+		}
+	}
+	if r.Matrix != nil {
+		item["matrixSize"] = &types.AttributeValueMemberN{Value: strconv.Itoa(r.Matrix.K)}
+		item["matrixSRSKey"] = &types.AttributeValueMemberS{Value: r.Matrix.SRSBlob.Key}
+		item["matrixSRSHash"] = &types.AttributeValueMemberB{Value: r.Matrix.SRSBlob.Hash}
+		item["rowCommitmentsKey"] = &types.AttributeValueMemberS{Value: r.Matrix.RowsBlob.Key}
+		item["rowCommitmentsHash"] = &types.AttributeValueMemberB{Value: r.Matrix.RowsBlob.Hash}
+		item["columnCommitmentsKey"] = &types.AttributeValueMemberS{Value: r.Matrix.ColumnsBlob.Key}
+		item["columnCommitmentsHash"] = &types.AttributeValueMemberB{Value: r.Matrix.ColumnsBlob.Hash}
+	}
+	return item, nil
+}
+
+// itemReader reads typed attributes from a setup item and collects every
+// missing or malformed one, so a bad record is reported in one error.
+type itemReader struct {
+	item     map[string]types.AttributeValue
+	problems []string
+}
+
+func (r *itemReader) has(name string) bool {
+	_, ok := r.item[name]
+	return ok
+}
+
+func (r *itemReader) str(name string) string {
+	v, ok := r.item[name].(*types.AttributeValueMemberS)
+	if !ok {
+		r.problems = append(r.problems, fmt.Sprintf("missing string attribute %s", name))
+		return ""
+	}
+	return v.Value
+}
+
+func (r *itemReader) number(name string) string {
+	v, ok := r.item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		r.problems = append(r.problems, fmt.Sprintf("missing number attribute %s", name))
+		return ""
+	}
+	return v.Value
+}
+
+func (r *itemReader) int64(name string) int64 {
+	s := r.number(name)
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		r.problems = append(r.problems, fmt.Sprintf("attribute %s: %v", name, err))
+	}
+	return n
+}
+
+func (r *itemReader) int(name string) int {
+	return int(r.int64(name))
+}
+
+func (r *itemReader) float(name string) float64 {
+	s := r.number(name)
+	if s == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		r.problems = append(r.problems, fmt.Sprintf("attribute %s: %v", name, err))
+	}
+	return f
+}
+
+func (r *itemReader) binary(name string) []byte {
+	v, ok := r.item[name].(*types.AttributeValueMemberB)
+	if !ok {
+		r.problems = append(r.problems, fmt.Sprintf("missing binary attribute %s", name))
+		return nil
+	}
+	return v.Value
+}
+
+func (r *itemReader) json(name string, v any) {
+	s := r.str(name)
+	if s == "" {
+		return
+	}
+	if err := json.Unmarshal([]byte(s), v); err != nil {
+		r.problems = append(r.problems, fmt.Sprintf("attribute %s: %v", name, err))
+	}
+}
+
+func setupRecordFromItem(item map[string]types.AttributeValue) (SetupRecord, error) {
+	in := &itemReader{item: item}
+	version := in.int("schemaVersion")
+	if len(in.problems) > 0 {
+		return SetupRecord{}, fmt.Errorf("setup record has no schema version: %w", ErrSetupVersion)
+	}
+	if version != SetupRecordVersion {
+		return SetupRecord{}, fmt.Errorf("version %d, want %d: %w", version, SetupRecordVersion, ErrSetupVersion)
+	}
+
+	r := SetupRecord{
+		Version:          version,
+		SetupID:          in.str("setupID"),
+		RandomSeed:       in.int64("randomSeed"),
+		SourceBlocks:     in.int("sourceBlocks"),
+		EncodedBlockIDs:  in.int("encodedBlockIDs"),
+		NumberOfBlocks:   in.int("numberOfBlocks"),
+		MessageKey:       in.str("messageKey"),
+		MessageSize:      in.int("messageSize"),
+		Serialization:    in.str("serialization"),
+		ManifestKey:      in.str("manifestKey"),
+		FramingOverhead:  in.int("framingOverhead"),
+		Compression:      in.str("compression"),
+		RawMessageSize:   in.int("rawMessageSize"),
+		CompressionRatio: in.float("compressionRatio"),
+		CompressionTime:  time.Duration(in.int64("compressionMicros")) * time.Microsecond,
+		Encryption:       in.str("encryption"),
+		DropletKeyID:     in.str("dropletKeyID"),
+	}
+	in.json("degreeCDF", &r.DegreeCDF)
+	in.json("requestedBlocks", &r.RequestedBlocks)
+
+	commitmentAttributes := 0
+	commitmentNames := []string{"digest", "dropletProofsKey", "commitment", "dropletSize"}
+	for _, name := range commitmentNames {
+		if in.has(name) {
+			commitmentAttributes++
+		}
+	}
+	switch commitmentAttributes {
+	case 0:
+		for _, name := range []string{"srsKey", "g2PowersKey"} {
+			if in.has(name) {
+				in.problems = append(in.problems, name+" without a droplet commitment")
+			}
+		}
+	case len(commitmentNames):
+		r.Commitment = &CommitmentSetup{
+			Digest:      in.binary("digest"),
+			ProofsKey:   in.str("dropletProofsKey"),
+			Scheme:      in.str("commitment"),
+			DropletSize: in.int("dropletSize"),
+		}
+		if in.has("srsKey") {
+			r.Commitment.SRSBlob = BlobRef{Key: in.str("srsKey"), Hash: in.binary("srsHash")}
+		}
+		if in.has("g2PowersKey") {
+			r.Commitment.G2PowersBlob = BlobRef{Key: in.str("g2PowersKey"), Hash: in.binary("g2PowersHash")}
+		}
+	default:
+		in.problems = append(in.problems, "setup has only some of "+strings.Join(commitmentNames, ", "))
+	}
+
+	matrixAttributes := 0
+	matrixNames := []string{"matrixSize", "matrixSRSKey", "matrixSRSHash", "rowCommitmentsKey", "rowCommitmentsHash", "columnCommitmentsKey", "columnCommitmentsHash"}
+	for _, name := range matrixNames {
+		if in.has(name) {
+			matrixAttributes++
+		}
+	}
+	switch matrixAttributes {
+	case 0:
+	case len(matrixNames):
+		r.Matrix = &MatrixSetup{
+			K:           in.int("matrixSize"),
+			SRSBlob:     BlobRef{Key: in.str("matrixSRSKey"), Hash: in.binary("matrixSRSHash")},
+			RowsBlob:    BlobRef{Key: in.str("rowCommitmentsKey"), Hash: in.binary("rowCommitmentsHash")},
+			ColumnsBlob: BlobRef{Key: in.str("columnCommitmentsKey"), Hash: in.binary("columnCommitmentsHash")},
+		}
+	default:
+		in.problems = append(in.problems, "setup has only some of "+strings.Join(matrixNames, ", "))
+	}
+
+	if len(in.problems) > 0 {
+		return SetupRecord{}, fmt.Errorf("%s: %w", strings.Join(in.problems, "; "), ErrInvalidSetup)
+	}
+	return r, nil
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRunSetup(t *testing.T) {
+	ctx := context.Background()
+	stores := NewMemoryStores()
+	event := StartSignal{
+		Start:           true,
+		SourceBlocks:    4,
+		EncodedBlockIDs: 16,
+		NumberOfBlocks:  3,
+		RequestedBlocks: []int{2, 0},
+		Compression:     CompressionGzip,
+	}
+	committed := false
+	commit := func(ctx context.Context, blobs BlobStore, event StartSignal, param SetupParameters, record *SetupRecord) error {
+		committed = len(param.Message) == record.MessageSize
+		return nil
+	}
+	record, err := RunSetup(ctx, stores, event, SetupConfig{TransactionsPerBlock: 2, Commit: commit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !committed {
+		t.Fatal("commit step did not see the compressed message")
+	}
+	if record.FramingOverhead <= 0 || record.FramingOverhead >= record.RawMessageSize {
+		t.Fatalf("framing overhead %d of a %d byte message", record.FramingOverhead, record.RawMessageSize)
+	}
+
+	loaded, err := LoadSetup(ctx, stores.Setup, stores.Blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	param := loaded.Parameters()
+	if param.Message, err = loaded.LoadMessage(ctx, stores.Blobs, nil); err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := Decoder(GenerateDroplet(param), param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Index != 2 || blocks[1].Index != 0 {
+		t.Fatalf("decoded %d blocks", len(blocks))
+	}
+}
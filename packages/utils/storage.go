@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+)
+
+var (
+	ErrBlobNotFound    = errors.New("blob not found")
+	ErrSetupNotFound   = errors.New("setup not found")
+	ErrDropletExists   = errors.New("droplet already stored")
+	ErrDropletNotFound = errors.New("droplet not found")
+	ErrRangeExists     = errors.New("range proof already stored")
+	ErrCellExists      = errors.New("cell already stored")
+)
+
+// BlobStore holds large objects such as the serialized message and the KZG
+// parameters. S3 in production.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// SetupStore holds the single setup item that the setup stage writes and
+// responders and decoders read. The item uses DynamoDB attribute values,
+// restricted to strings, numbers and binaries.
+type SetupStore interface {
+	PutSetup(ctx context.Context, item map[string]types.AttributeValue) error
+	GetSetup(ctx context.Context) (map[string]types.AttributeValue, error)
+}
+
+// StoredDroplet is a droplet as kept in the droplet pool, together with the
+// opening proof the responder attached to it. Proof is empty when the setup
+// publishes no droplet commitment.
+type StoredDroplet struct {
+	lubyTransform.LTBlock
+	Proof []byte
+}
+
+// RangeProof is one opening proof for every droplet with a BlockCode in
+// [Start, End), published by the responder that stored those droplets in
+// place of a proof per droplet.
+type RangeProof struct {
+	Start int
+	End   int
+	Proof []byte
+}
+
+// Cell is one cell of the extended matrix of a two-dimensional run, with
+// its opening proof against the commitment of its row.
+type Cell struct {
+	Row    int
+	Column int
+	Value  []byte
+	Proof  []byte
+}
+
+// DropletStore is the shared pool that responders write droplets to and the
+// decoder reads them from.
+type DropletStore interface {
+	// PutDroplet stores droplet under id unless a droplet with that id is
+	// already stored, in which case it returns ErrDropletExists.
+	PutDroplet(ctx context.Context, id int, droplet StoredDroplet) error
+
+	// ListDroplets returns every stored droplet, in no particular order.
+	ListDroplets(ctx context.Context) ([]StoredDroplet, error)
+
+	// GetDroplet returns the droplet stored under id, which responders set
+	// to the droplet's BlockCode, or ErrDropletNotFound. Samplers use it to
+	// fetch single droplets without listing the pool.
+	GetDroplet(ctx context.Context, id int) (StoredDroplet, error)
+
+	// PutRangeProof stores proof unless a proof for the same range is
+	// already stored, in which case it returns ErrRangeExists.
+	PutRangeProof(ctx context.Context, proof RangeProof) error
+
+	// ListRangeProofs returns every stored range proof, in no particular
+	// order.
+	ListRangeProofs(ctx context.Context) ([]RangeProof, error)
+
+	// PutCell stores cell unless a cell at the same position is already
+	// stored, in which case it returns ErrCellExists.
+	PutCell(ctx context.Context, cell Cell) error
+
+	// ListCells returns every stored cell, in no particular order.
+	ListCells(ctx context.Context) ([]Cell, error)
+}
+
+// Stores bundles the storage a pipeline stage needs, so stages can be run
+// against AWS, a local directory or memory without changes.
+type Stores struct {
+	Blobs    BlobStore
+	Setup    SetupStore
+	Droplets DropletStore
+}
+
+// StoreConfig selects the storage backend. With Dir set every store lives
+// under that directory; otherwise S3 and DynamoDB are used with the given
+// bucket and tables.
+type StoreConfig struct {
+	Dir          string
+	Bucket       string
+	SetupTable   string
+	DropletTable string
+}
+
+// OpenStores builds the stores selected by c. optFns are passed to the AWS
+// configuration loader.
+func OpenStores(ctx context.Context, c StoreConfig, optFns ...func(*config.LoadOptions) error) (Stores, error) {
+	if c.Dir != "" {
+		return NewFSStores(c.Dir)
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return Stores{}, fmt.Errorf("failed to load AWS configuration, %w", err)
+	}
+	ddbClient := dynamodb.NewFromConfig(cfg)
+	return Stores{
+		Blobs:    NewS3BlobStore(s3.NewFromConfig(cfg), c.Bucket),
+		Setup:    NewDynamoSetupStore(ddbClient, c.SetupTable),
+		Droplets: NewDynamoDropletStore(ddbClient, c.DropletTable),
+	}, nil
+}
+
+func NewMemoryStores() Stores {
+	return Stores{
+		Blobs:    NewMemoryBlobStore(),
+		Setup:    NewMemorySetupStore(),
+		Droplets: NewMemoryDropletStore(),
+	}
+}
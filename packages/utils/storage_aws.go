@@ -0,0 +1,306 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+)
+
+type S3BlobStore struct {
+	client *s3.Client
+	bucket string
+}
+
+func NewS3BlobStore(client *s3.Client, bucket string) *S3BlobStore {
+	return &S3BlobStore{client: client, bucket: bucket}
+}
+
+func (s *S3BlobStore) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+func (s *S3BlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *s3types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("s3://%s/%s: %w", s.bucket, key, ErrBlobNotFound)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// setupItemID is the partition key of the setup item.
+const setupItemID = "setup"
+
+type DynamoSetupStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+func NewDynamoSetupStore(client *dynamodb.Client, table string) *DynamoSetupStore {
+	return &DynamoSetupStore{client: client, table: table}
+}
+
+func (s *DynamoSetupStore) PutSetup(ctx context.Context, item map[string]types.AttributeValue) error {
+	withID := make(map[string]types.AttributeValue, len(item)+1)
+	for k, v := range item {
+		withID[k] = v
+	}
+	withID["ID"] = &types.AttributeValueMemberS{Value: setupItemID}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      withID,
+	})
+	return err
+}
+
+func (s *DynamoSetupStore) GetSetup(ctx context.Context) (map[string]types.AttributeValue, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: setupItemID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item from DynamoDB: %w", err)
+	}
+	if result.Item == nil {
+		return nil, fmt.Errorf("table %s: %w", s.table, ErrSetupNotFound)
+	}
+	return result.Item, nil
+}
+
+type DynamoDropletStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+func NewDynamoDropletStore(client *dynamodb.Client, table string) *DynamoDropletStore {
+	return &DynamoDropletStore{client: client, table: table}
+}
+
+func (s *DynamoDropletStore) PutDroplet(ctx context.Context, id int, droplet StoredDroplet) error {
+	item := map[string]types.AttributeValue{
+		"ID":        &types.AttributeValueMemberS{Value: strconv.Itoa(id)},
+		"Data":      &types.AttributeValueMemberB{Value: droplet.Data},
+		"BlockCode": &types.AttributeValueMemberN{Value: strconv.FormatInt(droplet.BlockCode, 10)},
+	}
+	if len(droplet.Proof) > 0 {
+		item["Proof"] = &types.AttributeValueMemberB{Value: droplet.Proof}
+	}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return fmt.Errorf("droplet %d: %w", id, ErrDropletExists)
+	}
+	return err
+}
+
+func (s *DynamoDropletStore) ListDroplets(ctx context.Context) ([]StoredDroplet, error) {
+	var droplets []StoredDroplet
+	pag := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName: aws.String(s.table),
+	})
+	for pag.HasMorePages() {
+		out, err := pag.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan DynamoDB table: %w", err)
+		}
+		for _, item := range out.Items {
+			droplet, ok, err := dropletFromItem(item)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				droplets = append(droplets, droplet)
+			}
+		}
+	}
+	return droplets, nil
+}
+
+func (s *DynamoDropletStore) GetDroplet(ctx context.Context, id int) (StoredDroplet, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: strconv.Itoa(id)},
+		},
+	})
+	if err != nil {
+		return StoredDroplet{}, fmt.Errorf("failed to get droplet %d: %w", id, err)
+	}
+	droplet, ok, err := dropletFromItem(out.Item)
+	if err != nil {
+		return StoredDroplet{}, err
+	}
+	if !ok {
+		return StoredDroplet{}, fmt.Errorf("droplet %d: %w", id, ErrDropletNotFound)
+	}
+	return droplet, nil
+}
+
+// dropletFromItem reads a droplet item. Items without data or a block
+// code, such as range proofs, are not droplets and yield false.
+func dropletFromItem(item map[string]types.AttributeValue) (StoredDroplet, bool, error) {
+	data, ok := item["Data"].(*types.AttributeValueMemberB)
+	if !ok || len(data.Value) == 0 {
+		return StoredDroplet{}, false, nil
+	}
+	code, ok := item["BlockCode"].(*types.AttributeValueMemberN)
+	if !ok {
+		return StoredDroplet{}, false, nil
+	}
+	blockCode, err := strconv.ParseInt(code.Value, 10, 64)
+	if err != nil {
+		return StoredDroplet{}, false, fmt.Errorf("droplet %v has a malformed block code: %w", item["ID"], err)
+	}
+	droplet := StoredDroplet{LTBlock: lubyTransform.LTBlock{BlockCode: blockCode, Data: data.Value}}
+	if proof, ok := item["Proof"].(*types.AttributeValueMemberB); ok {
+		droplet.Proof = proof.Value
+	}
+	return droplet, true, nil
+}
+
+// Range proofs share the droplet table under IDs that cannot collide with
+// droplet IDs; ListDroplets skips them because they carry no Data.
+func rangeProofID(start, end int) string {
+	return fmt.Sprintf("range-%d-%d", start, end)
+}
+
+func (s *DynamoDropletStore) PutRangeProof(ctx context.Context, proof RangeProof) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]types.AttributeValue{
+			"ID":         &types.AttributeValueMemberS{Value: rangeProofID(proof.Start, proof.End)},
+			"Start":      &types.AttributeValueMemberN{Value: strconv.Itoa(proof.Start)},
+			"End":        &types.AttributeValueMemberN{Value: strconv.Itoa(proof.End)},
+			"RangeProof": &types.AttributeValueMemberB{Value: proof.Proof},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return fmt.Errorf("range [%d, %d): %w", proof.Start, proof.End, ErrRangeExists)
+	}
+	return err
+}
+
+func (s *DynamoDropletStore) ListRangeProofs(ctx context.Context) ([]RangeProof, error) {
+	var proofs []RangeProof
+	pag := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName:        aws.String(s.table),
+		FilterExpression: aws.String("attribute_exists(RangeProof)"),
+	})
+	for pag.HasMorePages() {
+		out, err := pag.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan DynamoDB table: %w", err)
+		}
+		for _, item := range out.Items {
+			data, ok := item["RangeProof"].(*types.AttributeValueMemberB)
+			if !ok {
+				continue
+			}
+			start, okStart := item["Start"].(*types.AttributeValueMemberN)
+			end, okEnd := item["End"].(*types.AttributeValueMemberN)
+			if !okStart || !okEnd {
+				return nil, fmt.Errorf("range proof %v has no bounds", item["ID"])
+			}
+			proof := RangeProof{Proof: data.Value}
+			if proof.Start, err = strconv.Atoi(start.Value); err != nil {
+				return nil, fmt.Errorf("range proof %v has a malformed start: %w", item["ID"], err)
+			}
+			if proof.End, err = strconv.Atoi(end.Value); err != nil {
+				return nil, fmt.Errorf("range proof %v has a malformed end: %w", item["ID"], err)
+			}
+			proofs = append(proofs, proof)
+		}
+	}
+	return proofs, nil
+}
+
+// Cells share the droplet table like range proofs do.
+func cellID(row, column int) string {
+	return fmt.Sprintf("cell-%d-%d", row, column)
+}
+
+func (s *DynamoDropletStore) PutCell(ctx context.Context, cell Cell) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]types.AttributeValue{
+			"ID":        &types.AttributeValueMemberS{Value: cellID(cell.Row, cell.Column)},
+			"Row":       &types.AttributeValueMemberN{Value: strconv.Itoa(cell.Row)},
+			"Column":    &types.AttributeValueMemberN{Value: strconv.Itoa(cell.Column)},
+			"Cell":      &types.AttributeValueMemberB{Value: cell.Value},
+			"CellProof": &types.AttributeValueMemberB{Value: cell.Proof},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return fmt.Errorf("cell (%d, %d): %w", cell.Row, cell.Column, ErrCellExists)
+	}
+	return err
+}
+
+func (s *DynamoDropletStore) ListCells(ctx context.Context) ([]Cell, error) {
+	var cells []Cell
+	pag := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName:        aws.String(s.table),
+		FilterExpression: aws.String("attribute_exists(Cell)"),
+	})
+	for pag.HasMorePages() {
+		out, err := pag.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan DynamoDB table: %w", err)
+		}
+		for _, item := range out.Items {
+			value, ok := item["Cell"].(*types.AttributeValueMemberB)
+			if !ok {
+				continue
+			}
+			row, okRow := item["Row"].(*types.AttributeValueMemberN)
+			column, okColumn := item["Column"].(*types.AttributeValueMemberN)
+			if !okRow || !okColumn {
+				return nil, fmt.Errorf("cell %v has no position", item["ID"])
+			}
+			cell := Cell{Value: value.Value}
+			if proof, ok := item["CellProof"].(*types.AttributeValueMemberB); ok {
+				cell.Proof = proof.Value
+			}
+			if cell.Row, err = strconv.Atoi(row.Value); err != nil {
+				return nil, fmt.Errorf("cell %v has a malformed row: %w", item["ID"], err)
+			}
+			if cell.Column, err = strconv.Atoi(column.Value); err != nil {
+				return nil, fmt.Errorf("cell %v has a malformed column: %w", item["ID"], err)
+			}
+			cells = append(cells, cell)
+		}
+	}
+	return cells, nil
+}
@@ -0,0 +1,369 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+)
+
+// NewFSStores keeps blobs, the setup item and droplets under dir, so a
+// whole run can happen on one machine without AWS.
+func NewFSStores(dir string) (Stores, error) {
+	blobs, err := NewFSBlobStore(filepath.Join(dir, "blobs"))
+	if err != nil {
+		return Stores{}, err
+	}
+	droplets, err := NewFSDropletStore(filepath.Join(dir, "droplets"))
+	if err != nil {
+		return Stores{}, err
+	}
+	return Stores{
+		Blobs:    blobs,
+		Setup:    NewFSSetupStore(filepath.Join(dir, "setup.json")),
+		Droplets: droplets,
+	}, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+type FSBlobStore struct {
+	dir string
+}
+
+func NewFSBlobStore(dir string) (*FSBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &FSBlobStore{dir: dir}, nil
+}
+
+func (s *FSBlobStore) path(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if clean == "." || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, clean), nil
+}
+
+func (s *FSBlobStore) Put(ctx context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+func (s *FSBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", key, ErrBlobNotFound)
+	}
+	return data, err
+}
+
+// attributeJSON is the DynamoDB JSON form of the attribute types the setup
+// item uses.
+type attributeJSON struct {
+	S *string `json:"S,omitempty"`
+	N *string `json:"N,omitempty"`
+	B []byte  `json:"B,omitempty"`
+}
+
+func encodeItem(item map[string]types.AttributeValue) ([]byte, error) {
+	out := make(map[string]attributeJSON, len(item))
+	for name, value := range item {
+		switch v := value.(type) {
+		case *types.AttributeValueMemberS:
+			out[name] = attributeJSON{S: &v.Value}
+		case *types.AttributeValueMemberN:
+			out[name] = attributeJSON{N: &v.Value}
+		case *types.AttributeValueMemberB:
+			out[name] = attributeJSON{B: append([]byte{}, v.Value...)}
+		default:
+			return nil, fmt.Errorf("attribute %s: unsupported attribute type %T", name, value)
+		}
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
+
+func decodeItem(data []byte) (map[string]types.AttributeValue, error) {
+	var in map[string]attributeJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, err
+	}
+	item := make(map[string]types.AttributeValue, len(in))
+	for name, value := range in {
+		switch {
+		case value.S != nil:
+			item[name] = &types.AttributeValueMemberS{Value: *value.S}
+		case value.N != nil:
+			item[name] = &types.AttributeValueMemberN{Value: *value.N}
+		default:
+			item[name] = &types.AttributeValueMemberB{Value: value.B}
+		}
+	}
+	return item, nil
+}
+
+type FSSetupStore struct {
+	path string
+}
+
+func NewFSSetupStore(path string) *FSSetupStore {
+	return &FSSetupStore{path: path}
+}
+
+func (s *FSSetupStore) PutSetup(ctx context.Context, item map[string]types.AttributeValue) error {
+	data, err := encodeItem(item)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+func (s *FSSetupStore) GetSetup(ctx context.Context) (map[string]types.AttributeValue, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", s.path, ErrSetupNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	item, err := decodeItem(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read setup item %s: %w", s.path, err)
+	}
+	return item, nil
+}
+
+// FSDropletStore keeps one JSON file per droplet, range proofs in the
+// ranges subdirectory and the cells of two-dimensional runs in the cells
+// subdirectory. Files are created exclusively, which gives PutDroplet
+// PutRangeProof and PutCell the same first-writer-wins behaviour as the conditional
+// put on DynamoDB.
+type FSDropletStore struct {
+	dir string
+}
+
+func NewFSDropletStore(dir string) (*FSDropletStore, error) {
+	for _, sub := range []string{"ranges", "cells"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create droplet directory: %w", err)
+		}
+	}
+	return &FSDropletStore{dir: dir}, nil
+}
+
+func (s *FSDropletStore) PutDroplet(ctx context.Context, id int, droplet StoredDroplet) error {
+	data, err := json.Marshal(LTBlock{BlockCode: droplet.BlockCode, Data: droplet.Data, Proof: droplet.Proof})
+	if err != nil {
+		return err
+	}
+	if err := createExclusive(filepath.Join(s.dir, strconv.Itoa(id)+".json"), data); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("droplet %d: %w", id, ErrDropletExists)
+		}
+		return err
+	}
+	return nil
+}
+
+// createExclusive writes data to path unless path exists. The content is
+// written first so a concurrent reader never sees an empty file, then the
+// name is claimed with an exclusive link.
+func createExclusive(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".create.tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Link(tmp.Name(), path)
+}
+
+func (s *FSDropletStore) ListDroplets(ctx context.Context) ([]StoredDroplet, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var droplets []StoredDroplet
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var droplet LTBlock
+		if err := json.Unmarshal(data, &droplet); err != nil {
+			return nil, fmt.Errorf("failed to read droplet %s: %w", name, err)
+		}
+		if len(droplet.Data) != 0 {
+			droplets = append(droplets, StoredDroplet{
+				LTBlock: lubyTransform.LTBlock{BlockCode: droplet.BlockCode, Data: droplet.Data},
+				Proof:   droplet.Proof,
+			})
+		}
+	}
+	return droplets, nil
+}
+
+func (s *FSDropletStore) GetDroplet(ctx context.Context, id int) (StoredDroplet, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, strconv.Itoa(id)+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return StoredDroplet{}, fmt.Errorf("droplet %d: %w", id, ErrDropletNotFound)
+	}
+	if err != nil {
+		return StoredDroplet{}, err
+	}
+	var droplet LTBlock
+	if err := json.Unmarshal(data, &droplet); err != nil {
+		return StoredDroplet{}, fmt.Errorf("failed to read droplet %d: %w", id, err)
+	}
+	if len(droplet.Data) == 0 {
+		return StoredDroplet{}, fmt.Errorf("droplet %d: %w", id, ErrDropletNotFound)
+	}
+	return StoredDroplet{
+		LTBlock: lubyTransform.LTBlock{BlockCode: droplet.BlockCode, Data: droplet.Data},
+		Proof:   droplet.Proof,
+	}, nil
+}
+
+func (s *FSDropletStore) PutRangeProof(ctx context.Context, proof RangeProof) error {
+	data, err := json.Marshal(rangeProofJSON(proof))
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%d.json", proof.Start, proof.End)
+	if err := createExclusive(filepath.Join(s.dir, "ranges", name), data); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("range [%d, %d): %w", proof.Start, proof.End, ErrRangeExists)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FSDropletStore) ListRangeProofs(ctx context.Context) ([]RangeProof, error) {
+	dir := filepath.Join(s.dir, "ranges")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var proofs []RangeProof
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var proof rangeProofJSON
+		if err := json.Unmarshal(data, &proof); err != nil {
+			return nil, fmt.Errorf("failed to read range proof %s: %w", name, err)
+		}
+		proofs = append(proofs, RangeProof(proof))
+	}
+	return proofs, nil
+}
+
+type rangeProofJSON struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Proof []byte `json:"proof"`
+}
+
+func (s *FSDropletStore) PutCell(ctx context.Context, cell Cell) error {
+	data, err := json.Marshal(cellJSON(cell))
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%d.json", cell.Row, cell.Column)
+	if err := createExclusive(filepath.Join(s.dir, "cells", name), data); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("cell (%d, %d): %w", cell.Row, cell.Column, ErrCellExists)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FSDropletStore) ListCells(ctx context.Context) ([]Cell, error) {
+	dir := filepath.Join(s.dir, "cells")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var cells []Cell
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var cell cellJSON
+		if err := json.Unmarshal(data, &cell); err != nil {
+			return nil, fmt.Errorf("failed to read cell %s: %w", name, err)
+		}
+		cells = append(cells, Cell(cell))
+	}
+	return cells, nil
+}
+
+type cellJSON struct {
+	Row    int    `json:"row"`
+	Column int    `json:"column"`
+	Value  []byte `json:"value"`
+	Proof  []byte `json:"proof"`
+}
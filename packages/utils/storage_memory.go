@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+)
+
+type MemoryBlobStore struct {
+	mu    sync.RWMutex
+	blobs map[string][]byte
+}
+
+func NewMemoryBlobStore() *MemoryBlobStore {
+	return &MemoryBlobStore{blobs: make(map[string][]byte)}
+}
+
+func (s *MemoryBlobStore) Put(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[key] = append([]byte{}, data...)
+	return nil
+}
+
+func (s *MemoryBlobStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.blobs[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, ErrBlobNotFound)
+	}
+	return append([]byte{}, data...), nil
+}
+
+type MemorySetupStore struct {
+	mu   sync.RWMutex
+	item map[string]types.AttributeValue
+}
+
+func NewMemorySetupStore() *MemorySetupStore {
+	return &MemorySetupStore{}
+}
+
+func (s *MemorySetupStore) PutSetup(ctx context.Context, item map[string]types.AttributeValue) error {
+	// Round-trip through the file encoding so the memory store rejects the
+	// same attribute types as the other backends and keeps no aliases.
+	data, err := encodeItem(item)
+	if err != nil {
+		return err
+	}
+	copied, err := decodeItem(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.item = copied
+	return nil
+}
+
+func (s *MemorySetupStore) GetSetup(ctx context.Context) (map[string]types.AttributeValue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.item == nil {
+		return nil, ErrSetupNotFound
+	}
+	item := make(map[string]types.AttributeValue, len(s.item))
+	for k, v := range s.item {
+		item[k] = v
+	}
+	return item, nil
+}
+
+type MemoryDropletStore struct {
+	mu       sync.RWMutex
+	droplets map[int]StoredDroplet
+	ranges   map[[2]int]RangeProof
+	cells    map[[2]int]Cell
+}
+
+func NewMemoryDropletStore() *MemoryDropletStore {
+	return &MemoryDropletStore{
+		droplets: make(map[int]StoredDroplet),
+		ranges:   make(map[[2]int]RangeProof),
+		cells:    make(map[[2]int]Cell),
+	}
+}
+
+func (s *MemoryDropletStore) PutDroplet(ctx context.Context, id int, droplet StoredDroplet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.droplets[id]; ok {
+		return fmt.Errorf("droplet %d: %w", id, ErrDropletExists)
+	}
+	s.droplets[id] = StoredDroplet{
+		LTBlock: lubyTransform.LTBlock{BlockCode: droplet.BlockCode, Data: append([]byte{}, droplet.Data...)},
+		Proof:   append([]byte{}, droplet.Proof...),
+	}
+	return nil
+}
+
+func (s *MemoryDropletStore) ListDroplets(ctx context.Context) ([]StoredDroplet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	droplets := make([]StoredDroplet, 0, len(s.droplets))
+	for _, droplet := range s.droplets {
+		if len(droplet.Data) != 0 {
+			droplets = append(droplets, droplet)
+		}
+	}
+	return droplets, nil
+}
+
+func (s *MemoryDropletStore) GetDroplet(ctx context.Context, id int) (StoredDroplet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	droplet, ok := s.droplets[id]
+	if !ok || len(droplet.Data) == 0 {
+		return StoredDroplet{}, fmt.Errorf("droplet %d: %w", id, ErrDropletNotFound)
+	}
+	return droplet, nil
+}
+
+func (s *MemoryDropletStore) PutRangeProof(ctx context.Context, proof RangeProof) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]int{proof.Start, proof.End}
+	if _, ok := s.ranges[key]; ok {
+		return fmt.Errorf("range [%d, %d): %w", proof.Start, proof.End, ErrRangeExists)
+	}
+	s.ranges[key] = RangeProof{Start: proof.Start, End: proof.End, Proof: append([]byte{}, proof.Proof...)}
+	return nil
+}
+
+func (s *MemoryDropletStore) ListRangeProofs(ctx context.Context) ([]RangeProof, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	proofs := make([]RangeProof, 0, len(s.ranges))
+	for _, proof := range s.ranges {
+		proofs = append(proofs, proof)
+	}
+	return proofs, nil
+}
+
+func (s *MemoryDropletStore) PutCell(ctx context.Context, cell Cell) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]int{cell.Row, cell.Column}
+	if _, ok := s.cells[key]; ok {
+		return fmt.Errorf("cell (%d, %d): %w", cell.Row, cell.Column, ErrCellExists)
+	}
+	s.cells[key] = Cell{
+		Row:    cell.Row,
+		Column: cell.Column,
+		Value:  append([]byte{}, cell.Value...),
+		Proof:  append([]byte{}, cell.Proof...),
+	}
+	return nil
+}
+
+func (s *MemoryDropletStore) ListCells(ctx context.Context) ([]Cell, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cells := make([]Cell, 0, len(s.cells))
+	for _, cell := range s.cells {
+		cells = append(cells, cell)
+	}
+	return cells, nil
+}
@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	lubyTransform "github.com/xm0onh/thesis/packages/luby"
+)
+
+func testStores(t *testing.T) map[string]Stores {
+	t.Helper()
+	fs, err := NewFSStores(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Stores{"memory": NewMemoryStores(), "fs": fs}
+}
+
+func testSetupRecord() SetupRecord {
+	r := NewSetupRecord(SetupParameters{
+		SetupID:         "0123456789abcdef",
+		DegreeCDF:       []float64{0.5, 1},
+		RandomSeed:      42,
+		SourceBlocks:    4,
+		EncodedBlockIDs: 8,
+		NumberOfBlocks:  3,
+		MessageSize:     100,
+		Serialization:   SerializationJSON,
+		Compression:     CompressionNone,
+		Encryption:      EncryptionNone,
+	}, "blockchain_data")
+	r.RequestedBlocks = []int{0, 2}
+	r.ManifestKey = ManifestKey
+	r.Commitment = &CommitmentSetup{Scheme: CommitmentMerkle, Digest: []byte{1, 2}, ProofsKey: "droplet-proofs.dat"}
+	return r
+}
+
+func TestBlobStores(t *testing.T) {
+	ctx := context.Background()
+	for name, stores := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := stores.Blobs.Get(ctx, "missing"); !errors.Is(err, ErrBlobNotFound) {
+				t.Fatalf("missing blob: %v", err)
+			}
+			for _, data := range [][]byte{[]byte("first"), []byte("second")} {
+				if err := stores.Blobs.Put(ctx, "dir/blob", data); err != nil {
+					t.Fatal(err)
+				}
+				got, err := stores.Blobs.Get(ctx, "dir/blob")
+				if err != nil || !bytes.Equal(got, data) {
+					t.Fatalf("Get = %q, %v; want %q", got, err, data)
+				}
+			}
+		})
+	}
+}
+
+func TestSetupStores(t *testing.T) {
+	ctx := context.Background()
+	for name, stores := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadSetup(ctx, stores.Setup, stores.Blobs); !errors.Is(err, ErrSetupNotFound) {
+				t.Fatalf("no setup: %v", err)
+			}
+			want := testSetupRecord()
+			if err := SaveSetup(ctx, stores.Setup, want); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadSetup(ctx, stores.Setup, stores.Blobs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.SetupID != want.SetupID || got.ManifestKey != want.ManifestKey || len(got.RequestedBlocks) != 2 ||
+				got.Commitment == nil || !bytes.Equal(got.Commitment.Digest, want.Commitment.Digest) {
+				t.Fatalf("loaded %+v, want %+v", got, want)
+			}
+
+			item, err := want.item()
+			if err != nil {
+				t.Fatal(err)
+			}
+			item["schemaVersion"] = &types.AttributeValueMemberN{Value: "1"}
+			if err := stores.Setup.PutSetup(ctx, item); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadSetup(ctx, stores.Setup, stores.Blobs); !errors.Is(err, ErrSetupVersion) {
+				t.Fatalf("old schema version: %v", err)
+			}
+		})
+	}
+
+	bad := testSetupRecord()
+	bad.SetupID, bad.SourceBlocks = "", 0
+	if err := SaveSetup(ctx, NewMemorySetupStore(), bad); !errors.Is(err, ErrInvalidSetup) {
+		t.Fatalf("invalid record: %v", err)
+	}
+}
+
+func TestSetupBlobs(t *testing.T) {
+	ctx := context.Background()
+	stores := NewMemoryStores()
+	r := testSetupRecord()
+	srs := []byte("serialized SRS")
+	var err error
+	r.Commitment = &CommitmentSetup{Scheme: CommitmentKZG, Digest: []byte{1, 2}, ProofsKey: "droplet-proofs.dat", SRS: srs}
+	if r.Commitment.SRSBlob, err = PutBlob(ctx, stores.Blobs, "kzg-srs.dat", srs); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveSetup(ctx, stores.Setup, r); err != nil {
+		t.Fatal(err)
+	}
+	item, err := stores.Setup.GetSetup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := item["srs"]; ok {
+		t.Fatal("the SRS is stored in the setup item")
+	}
+	got, err := LoadSetup(ctx, stores.Setup, stores.Blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Commitment.SRS, srs) {
+		t.Fatalf("loaded SRS %q", got.Commitment.SRS)
+	}
+
+	if err := stores.Blobs.Put(ctx, "kzg-srs.dat", []byte("another SRS")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSetup(ctx, stores.Setup, stores.Blobs); !errors.Is(err, ErrInvalidSetup) {
+		t.Fatalf("replaced SRS: %v", err)
+	}
+	r.Commitment.SRSBlob = BlobRef{}
+	if err := SaveSetup(ctx, stores.Setup, r); !errors.Is(err, ErrInvalidSetup) {
+		t.Fatalf("KZG commitment without an SRS blob: %v", err)
+	}
+}
+
+func TestMatrixSetupBlobs(t *testing.T) {
+	ctx := context.Background()
+	stores := NewMemoryStores()
+	r := testSetupRecord()
+	m := &MatrixSetup{K: 2, SRS: []byte("matrix SRS"), Rows: []byte("rows"), Columns: []byte("columns")}
+	var err error
+	if m.SRSBlob, err = PutBlob(ctx, stores.Blobs, "rs2d-srs.dat", m.SRS); err != nil {
+		t.Fatal(err)
+	}
+	if m.RowsBlob, err = PutBlob(ctx, stores.Blobs, "rs2d-rows.dat", m.Rows); err != nil {
+		t.Fatal(err)
+	}
+	if m.ColumnsBlob, err = PutBlob(ctx, stores.Blobs, "rs2d-columns.dat", m.Columns); err != nil {
+		t.Fatal(err)
+	}
+	r.Commitment = nil
+	r.Matrix = m
+	if err := SaveSetup(ctx, stores.Setup, r); err != nil {
+		t.Fatal(err)
+	}
+	item, err := stores.Setup.GetSetup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"matrixSRS", "rowCommitments", "columnCommitments"} {
+		if _, ok := item[name]; ok {
+			t.Fatalf("%s is stored in the setup item", name)
+		}
+	}
+	got, err := LoadSetup(ctx, stores.Setup, stores.Blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Matrix.K != 2 || !bytes.Equal(got.Matrix.SRS, m.SRS) || !bytes.Equal(got.Matrix.Rows, m.Rows) || !bytes.Equal(got.Matrix.Columns, m.Columns) {
+		t.Fatalf("loaded matrix setup %+v", got.Matrix)
+	}
+
+	if err := stores.Blobs.Put(ctx, "rs2d-columns.dat", []byte("other columns")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSetup(ctx, stores.Setup, stores.Blobs); !errors.Is(err, ErrInvalidSetup) {
+		t.Fatalf("replaced column commitments: %v", err)
+	}
+	r.Matrix.RowsBlob = BlobRef{}
+	if err := SaveSetup(ctx, stores.Setup, r); !errors.Is(err, ErrInvalidSetup) {
+		t.Fatalf("matrix setup without a rows blob: %v", err)
+	}
+}
+
+func TestDropletStores(t *testing.T) {
+	ctx := context.Background()
+	for name, stores := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			droplet := StoredDroplet{LTBlock: lubyTransform.LTBlock{BlockCode: 3, Data: []byte{1, 2, 3}}, Proof: []byte{9}}
+			if err := stores.Droplets.PutDroplet(ctx, 3, droplet); err != nil {
+				t.Fatal(err)
+			}
+			if err := stores.Droplets.PutDroplet(ctx, 3, droplet); !errors.Is(err, ErrDropletExists) {
+				t.Fatalf("second droplet 3: %v", err)
+			}
+			got, err := stores.Droplets.GetDroplet(ctx, 3)
+			if err != nil || got.BlockCode != 3 || !bytes.Equal(got.Data, droplet.Data) || !bytes.Equal(got.Proof, droplet.Proof) {
+				t.Fatalf("GetDroplet = %+v, %v", got, err)
+			}
+			if _, err := stores.Droplets.GetDroplet(ctx, 4); !errors.Is(err, ErrDropletNotFound) {
+				t.Fatalf("missing droplet: %v", err)
+			}
+			if listed, err := stores.Droplets.ListDroplets(ctx); err != nil || len(listed) != 1 {
+				t.Fatalf("ListDroplets = %d droplets, %v", len(listed), err)
+			}
+
+			proof := RangeProof{Start: 0, End: 4, Proof: []byte{7}}
+			if err := stores.Droplets.PutRangeProof(ctx, proof); err != nil {
+				t.Fatal(err)
+			}
+			if err := stores.Droplets.PutRangeProof(ctx, proof); !errors.Is(err, ErrRangeExists) {
+				t.Fatalf("second range proof: %v", err)
+			}
+			if ranges, err := stores.Droplets.ListRangeProofs(ctx); err != nil || len(ranges) != 1 || ranges[0].End != 4 {
+				t.Fatalf("ListRangeProofs = %+v, %v", ranges, err)
+			}
+
+			cell := Cell{Row: 1, Column: 2, Value: []byte{5}, Proof: []byte{6}}
+			if err := stores.Droplets.PutCell(ctx, cell); err != nil {
+				t.Fatal(err)
+			}
+			if err := stores.Droplets.PutCell(ctx, cell); !errors.Is(err, ErrCellExists) {
+				t.Fatalf("second cell: %v", err)
+			}
+			if cells, err := stores.Droplets.ListCells(ctx); err != nil || len(cells) != 1 || cells[0].Column != 2 {
+				t.Fatalf("ListCells = %+v, %v", cells, err)
+			}
+		})
+	}
+}
@@ -1,8 +1,13 @@
 package utils
 
+import (
+	blockchainPkg "github.com/xm0onh/thesis/packages/blockchain"
+)
+
 type LTBlock struct {
 	BlockCode int64  `json:"blockCode"`
 	Data      []byte `json:"data"`
+	Proof     []byte `json:"proof,omitempty"`
 }
 
 type SizeOfMessage struct {
@@ -10,7 +15,9 @@ type SizeOfMessage struct {
 }
 
 type RequestedBlocks struct {
-	BlockNumber []int `json:"blockNumber"`
+	BlockNumber []int        `json:"blockNumber"`
+	BlockHashes []string     `json:"blockHashes"`
+	Ranges      []BlockRange `json:"ranges"`
 }
 
 type RequestedDroplets struct {
@@ -19,6 +26,9 @@ type RequestedDroplets struct {
 }
 
 type SetupParameters struct {
+	// SetupID names the run; sealed droplets are bound to it.
+	SetupID string `json:"setupID"`
+
 	DegreeCDF       []float64 `json:"degreeCDF"`
 	RandomSeed      int64     `json:"randomSeed"`
 	SourceBlocks    int       `json:"sourceBlocks"`
@@ -26,12 +36,48 @@ type SetupParameters struct {
 	NumberOfBlocks  int       `json:"numberOfBlocks"`
 	MessageSize     int       `json:"messageSize"`
 	Message         []byte    `json:"message"`
+	Serialization   string    `json:"serialization"`
+
+	// Compression names the compressor applied to Message before fountain
+	// encoding; MessageSize is the compressed size.
+	Compression string `json:"compression,omitempty"`
+
+	// Encryption names the AEAD that responders seal droplets with. The key
+	// is distributed out of band; only its fingerprint is recorded.
+	Encryption   string `json:"encryption,omitempty"`
+	DropletKeyID string `json:"dropletKeyID,omitempty"`
 }
 
 type StartSignal struct {
-	Start           bool  `json:"start"`
-	SourceBlocks    int   `json:"sourceBlocks"`
-	EncodedBlockIDs int   `json:"encodedBlockIDs"`
-	NumberOfBlocks  int   `json:"numberOfBlocks"`
-	RequestedBlocks []int `json:"requestedBlocks"`
+	Start           bool   `json:"start"`
+	SourceBlocks    int    `json:"sourceBlocks"`
+	EncodedBlockIDs int    `json:"encodedBlockIDs"`
+	NumberOfBlocks  int    `json:"numberOfBlocks"`
+	RequestedBlocks []int  `json:"requestedBlocks"`
+	Serialization   string `json:"serialization"`
+	Compression     string `json:"compression,omitempty"`
+	Encryption      string `json:"encryption,omitempty"`
+	Commitment      string `json:"commitment,omitempty"`
+	Coding          string `json:"coding,omitempty"`
+
+	// ResponderRange is the most droplets one responder is assigned. KZG
+	// setups size their multi-opening key for it, so that a responder
+	// proves its whole assignment with one proof. Without it the key
+	// covers 64 droplets.
+	ResponderRange int `json:"responderRange,omitempty"`
+
+	// RequestedBlockHashes adds blocks by hash to RequestedBlocks.
+	RequestedBlockHashes []string `json:"requestedBlockHashes,omitempty"`
+
+	// RequestedBlockRanges adds every block of each range to
+	// RequestedBlocks.
+	RequestedBlockRanges []BlockRange `json:"requestedBlockRanges,omitempty"`
+
+	// Workload, when set, replaces the fixed synthetic transactions with
+	// the configurable generator.
+	Workload *blockchainPkg.WorkloadConfig `json:"workload,omitempty"`
+
+	// EthereumBlocks, when set, imports real Ethereum blocks from disk
+	// instead of generating a synthetic chain.
+	EthereumBlocks *blockchainPkg.EthereumImportConfig `json:"ethereumBlocks,omitempty"`
 }
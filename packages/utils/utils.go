@@ -2,187 +2,200 @@ package utils
 
 import (
 	"bytes"
-	"context"
 	"encoding/gob"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
 	"math/rand"
-	"strconv"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go/aws"
 	blockchainPkg "github.com/xm0onh/thesis/packages/blockchain"
 	lubyTransform "github.com/xm0onh/thesis/packages/luby"
 )
 
-func BlockToByte(block []*blockchainPkg.Block) []byte {
-	var buffer bytes.Buffer
-	encoder := gob.NewEncoder(&buffer)
-	err := encoder.Encode(block)
+var (
+	// ErrInsufficientDroplets means the droplets do not cover the message
+	// yet; fetching more droplets and decoding again can succeed.
+	ErrInsufficientDroplets = errors.New("not enough droplets to decode the message")
+
+	// ErrCorruptMessage means bytes that should hold serialized blocks could
+	// not be decoded, for example because a responder sent bad droplets.
+	ErrCorruptMessage = errors.New("corrupt message")
+
+	// ErrProofInvalid means a commitment proof did not verify.
+	ErrProofInvalid = errors.New("invalid proof")
+)
+
+func BlockToByte(block []*blockchainPkg.Block) ([]byte, error) {
+	data, err := GobSerializer{}.Marshal(block)
 	if err != nil {
-		log.Fatalf("failed to encode block: %v", err)
+		return nil, fmt.Errorf("failed to encode block: %w", err)
 	}
-	return buffer.Bytes()
+	return data, nil
 }
 
-func ByteToBlock(data []byte) *[]blockchainPkg.Block {
-	var block []blockchainPkg.Block
-	buffer := bytes.NewBuffer(data)
-	decoder := gob.NewDecoder(buffer)
-	err := decoder.Decode(&block)
+func ByteToBlock(data []byte) ([]blockchainPkg.Block, error) {
+	block, err := GobSerializer{}.Unmarshal(data)
 	if err != nil {
-		log.Fatalf("failed to decode block: %v", err)
+		return nil, fmt.Errorf("failed to decode block: %v: %w", err, ErrCorruptMessage)
 	}
-	return &block
+	return block, nil
 }
 
-func BlockchainToBytes(bc *blockchainPkg.Blockchain) []byte {
+// BlockchainToBytes gob-encodes the Blockchain struct itself, the format
+// blobs have always been written in. SerializeBlockchain encodes the list
+// of blocks with a chosen Serializer instead.
+func BlockchainToBytes(bc *blockchainPkg.Blockchain) ([]byte, error) {
 	var buffer bytes.Buffer
-	encoder := gob.NewEncoder(&buffer)
-	err := encoder.Encode(bc)
-	if err != nil {
-		log.Fatalf("failed to encode blockchain: %v", err)
+	if err := gob.NewEncoder(&buffer).Encode(bc); err != nil {
+		return nil, fmt.Errorf("failed to encode blockchain: %w", err)
 	}
-	return buffer.Bytes()
+	return buffer.Bytes(), nil
 }
 
-func BytesToBlockchain(data []byte) *blockchainPkg.Blockchain {
+func BytesToBlockchain(data []byte) (*blockchainPkg.Blockchain, error) {
 	var bc blockchainPkg.Blockchain
-	buffer := bytes.NewBuffer(data)
-	decoder := gob.NewDecoder(buffer)
-	err := decoder.Decode(&bc)
-	if err != nil {
-		log.Fatalf("failed to decode blockchain: %v", err)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&bc); err != nil {
+		return nil, fmt.Errorf("failed to decode blockchain: %v: %w", err, ErrCorruptMessage)
 	}
-	return &bc
+	return &bc, nil
 }
 
-func InitializeBlockchain(NumberOfBlocks int, TransactionsPerBlock int) *blockchainPkg.Blockchain {
+func InitializeBlockchain(NumberOfBlocks int, TransactionsPerBlock int) (*blockchainPkg.Blockchain, error) {
 	bc := &blockchainPkg.Blockchain{}
 
-	// Simulate transaction data
-	transactions := blockchainPkg.GenerateTransactionsForBlock(TransactionsPerBlock)
-	// Add blocks to the blockchain
+	// Add blocks to the blockchain, each with its own transactions.
 	for i := 0; i < NumberOfBlocks; i++ {
-		block := blockchainPkg.CreateBlock(i, transactions)
-		bc.AddBlock(block)
+		transactions := blockchainPkg.GenerateTransactionsForBlock(TransactionsPerBlock)
+		block, err := blockchainPkg.CreateBlock(i, transactions)
+		if err != nil {
+			return nil, err
+		}
+		if err := bc.AddBlock(block); err != nil {
+			return nil, err
+		}
 	}
 
-	return bc
+	return bc, nil
 }
 
-func CalculateMessageAndMessageSize(blockchain blockchainPkg.Blockchain, blockNumber []int) ([]byte, int, error) {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-
-	if err := enc.Encode(blockchain); err != nil {
-		fmt.Printf("Error encoding object: %s\n", err)
-		return []byte{0}, 0, err
+// GenerateBlockchain builds a synthetic chain. With a workload config the
+// transactions come from the workload generator, otherwise from
+// InitializeBlockchain with TransactionsPerBlock transactions per block.
+func GenerateBlockchain(NumberOfBlocks int, TransactionsPerBlock int, workload *blockchainPkg.WorkloadConfig) (*blockchainPkg.Blockchain, error) {
+	if workload == nil {
+		return InitializeBlockchain(NumberOfBlocks, TransactionsPerBlock)
 	}
-	var tempBlockchain []*blockchainPkg.Block
-	for _, blockNumber := range blockNumber {
-		tempBlockchain = append(tempBlockchain, &blockchain.Chain[blockNumber])
+	generator, err := blockchainPkg.NewWorkloadGenerator(*workload)
+	if err != nil {
+		return nil, err
 	}
-	message := BlockToByte(tempBlockchain)
-	return message, len(message), nil
+	return generator.GenerateBlockchain(NumberOfBlocks)
 }
 
-func PullDataFromSetup(ctx context.Context, setupTableName string) (
-	degreeCDF []float64,
-	sourceBlocks,
-	encodedBlockIDs int,
-	randomSeed int64,
-	numberOfBlocks int,
-	message []byte,
-	messageSize int,
-	err error) {
+// BuildBlockchain creates the chain requested by a start signal: imported
+// Ethereum blocks if EthereumBlocks is set, a synthetic chain otherwise.
+func BuildBlockchain(event StartSignal, TransactionsPerBlock int) (*blockchainPkg.Blockchain, error) {
+	if event.EthereumBlocks != nil {
+		return blockchainPkg.ImportEthereumBlocks(*event.EthereumBlocks)
+	}
+	return GenerateBlockchain(event.NumberOfBlocks, TransactionsPerBlock, event.Workload)
+}
 
-	cfg, err := config.LoadDefaultConfig(ctx)
+// LoadOrInitializeBlockchain loads the chain persisted in storeDir. If the
+// store holds fewer than NumberOfBlocks blocks, the chain is built with build
+// and the missing blocks are saved so later runs read the same chain. Built
+// blocks that do not already follow the stored tip are linked onto it, so
+// the stored chain stays valid however build numbers and hashes its blocks.
+func LoadOrInitializeBlockchain(storeDir string, NumberOfBlocks int, build func() (*blockchainPkg.Blockchain, error)) (*blockchainPkg.Blockchain, error) {
+	store, err := blockchainPkg.OpenBlockStore(storeDir)
 	if err != nil {
-		fmt.Printf("failed to load AWS configuration, %v\n", err)
-		return
+		return nil, err
 	}
+	defer store.Close()
 
-	ddbClient := dynamodb.NewFromConfig(cfg)
-
-	result, err := ddbClient.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(setupTableName),
-		Key: map[string]types.AttributeValue{
-			"ID": &types.AttributeValueMemberS{Value: "setup"},
-		},
-	})
+	stored, err := store.Blockchain()
+	if err != nil || len(stored.Chain) >= NumberOfBlocks {
+		return stored, err
+	}
+	built, err := build()
 	if err != nil {
-		fmt.Printf("failed to get item from DynamoDB: %v\n", err)
-		return
+		return nil, err
 	}
-
-	// Extracting DegreeCDF
-	if v, ok := result.Item["degreeCDF"].(*types.AttributeValueMemberS); ok {
-		err = json.Unmarshal([]byte(v.Value), &degreeCDF)
-		if err != nil {
-			fmt.Printf("error parsing degreeCDF: %v\n", err)
-			return
+	for _, block := range built.Chain[min(len(stored.Chain), len(built.Chain)):] {
+		if err := extendChain(stored, block); err != nil {
+			return nil, err
 		}
 	}
+	if err := stored.Save(store); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
 
-	// Extracting SourceBlocks
-	if v, ok := result.Item["sourceBlocks"].(*types.AttributeValueMemberN); ok {
-		sourceBlocks, err = strconv.Atoi(v.Value)
-		if err != nil {
-			fmt.Printf("error parsing sourceBlocks: %v\n", err)
-			return
-		}
+// extendChain appends block to bc as it is if it already follows the tip,
+// and otherwise re-links it onto the tip at the next position. Imported
+// blocks keep their source chain's hashes and cannot be re-linked.
+func extendChain(bc *blockchainPkg.Blockchain, block blockchainPkg.Block) error {
+	n := len(bc.Chain)
+	if n == 0 || block.PrevHash == bc.Chain[n-1].Hash {
+		bc.Chain = append(bc.Chain, block)
+		return nil
+	}
+	if block.Origin != "" {
+		return fmt.Errorf("%s block %d does not follow the stored tip %s: %w", block.Origin, block.Index, bc.Chain[n-1].Hash, blockchainPkg.ErrInvalidBlock)
 	}
+	block.Index = n
+	return bc.AddBlock(block)
+}
 
-	// Extracting RandomSeed
-	if v, ok := result.Item["randomSeed"].(*types.AttributeValueMemberN); ok {
-		var rs int64
-		rs, err = strconv.ParseInt(v.Value, 10, 64)
-		if err != nil {
-			fmt.Printf("error parsing randomSeed: %v\n", err)
-			return
+// ResolveRequestedBlocks turns a request into chain positions. Block numbers
+// and ranges refer to Block.Index, which is the position in synthetic chains
+// and the Ethereum block number in imported ones; hashes are looked up in
+// the chain's index. Numbers the chain does not hold are rejected with
+// ErrBlockOutOfRange.
+func ResolveRequestedBlocks(bc *blockchainPkg.Blockchain, request RequestedBlocks) ([]int, error) {
+	numbers := append([]int{}, request.BlockNumber...)
+	for _, r := range request.Ranges {
+		if r.Start > r.End {
+			return nil, fmt.Errorf("block range [%d, %d) is reversed: %w", r.Start, r.End, ErrBlockOutOfRange)
+		}
+		for i := r.Start; i < r.End; i++ {
+			numbers = append(numbers, i)
 		}
-		randomSeed = int64(rs) // Note: Be cautious with potential int64 to int conversion issues on 32-bit systems
 	}
-
-	// Extracting EncodedBlockIDs
-	if v, ok := result.Item["encodedBlockIDs"].(*types.AttributeValueMemberN); ok {
-		encodedBlockIDs, err = strconv.Atoi(v.Value)
+	positions := make([]int, 0, len(numbers)+len(request.BlockHashes))
+	for _, number := range numbers {
+		position, err := bc.BlockPositionByNumber(number)
+		if errors.Is(err, blockchainPkg.ErrBlockNotFound) {
+			return nil, fmt.Errorf("block %d not in chain of %d blocks: %w", number, len(bc.Chain), ErrBlockOutOfRange)
+		}
 		if err != nil {
-			fmt.Printf("error parsing encodedBlockIDs: %v\n", err)
-			return
+			return nil, err
 		}
+		positions = append(positions, position)
 	}
-
-	// Extracting NumberOfBlocks
-	if v, ok := result.Item["numberOfBlocks"].(*types.AttributeValueMemberN); ok {
-		numberOfBlocks, err = strconv.Atoi(v.Value)
+	for _, hash := range request.BlockHashes {
+		i, err := bc.BlockPosition(hash)
 		if err != nil {
-			fmt.Printf("error parsing numberOfBlocks: %v\n", err)
-			return
+			return nil, err
 		}
+		positions = append(positions, i)
 	}
+	return positions, nil
+}
 
-	// Extracting Message
-	if v, ok := result.Item["message"].(*types.AttributeValueMemberB); ok {
-		message = v.Value
+// SelectBlocks answers a block request from decoded blocks, by number or
+// by hash, in the order ResolveRequestedBlocks gives.
+func SelectBlocks(blocks []blockchainPkg.Block, request RequestedBlocks) ([]blockchainPkg.Block, error) {
+	bc := &blockchainPkg.Blockchain{Chain: blocks}
+	positions, err := ResolveRequestedBlocks(bc, request)
+	if err != nil {
+		return nil, err
 	}
-
-	// Extracting MessageSize
-	if v, ok := result.Item["messageSize"].(*types.AttributeValueMemberN); ok {
-		messageSize, err = strconv.Atoi(v.Value)
-		if err != nil {
-			fmt.Printf("error parsing messageSize: %v\n", err)
-			return
-		}
+	selected := make([]blockchainPkg.Block, len(positions))
+	for i, position := range positions {
+		selected[i] = blocks[position]
 	}
-
-	return
+	return selected, nil
 }
 
 func GenerateDroplet(param SetupParameters) []lubyTransform.LTBlock {
@@ -190,18 +203,7 @@ func GenerateDroplet(param SetupParameters) []lubyTransform.LTBlock {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
 
-	// Define parameters for the Luby Codec.
-	sourceBlocks := param.SourceBlocks
-	degreeCDF := param.DegreeCDF
-
-	// Create a PRNG source.
-
-	seedValue := param.RandomSeed
-	seed := rand.NewSource(seedValue)
-	random := rand.New(seed)
-
-	// Create a new Luby Codec.
-	codec := lubyTransform.NewLubyCodec(sourceBlocks, random, degreeCDF)
+	codec := NewCodec(param)
 
 	// Encode the message into LTBlocks.
 	// Commitment size
@@ -220,78 +222,55 @@ func GenerateDroplet(param SetupParameters) []lubyTransform.LTBlock {
 	return droplets
 }
 
-func Decoder(Droplets []lubyTransform.LTBlock, param SetupParameters) ([]blockchainPkg.Block, error) {
-	// message := "Hello, World!"
-	sourceBlocks := param.SourceBlocks
-	degreeCDF := param.DegreeCDF
-
-	// Create a PRNG source.
-	seedValue := param.RandomSeed
-	seed := rand.NewSource(seedValue)
-	random := rand.New(seed)
-
-	// Create a new Luby Codec.
-	codec := lubyTransform.NewLubyCodec(sourceBlocks, random, degreeCDF)
-
-	decoder := codec.NewDecoder(param.MessageSize)
-
-	if decoder.AddBlocks(Droplets) {
-		decodedMessage := decoder.Decode()
-
-		if decodedMessage != nil {
-			// Convert blockchain bytes to a Blocks object.
-			decodedBlocks := ByteToBlock(decodedMessage)
-			// size of decodedBlockchain
-			fmt.Println("Decoded blockchain: ", len(*decodedBlocks))
-			return *decodedBlocks, nil
-
-		} else {
-			fmt.Println("Not enough blocks to decode the message.")
-		}
-	} else {
-		fmt.Println("Not enough blocks to decode the message.")
-	}
-	return []blockchainPkg.Block{}, nil
+// NewCodec returns the Luby codec of a run: the droplet with BlockCode i
+// XORs the source blocks codec.PickIndices(i).
+func NewCodec(param SetupParameters) lubyTransform.Codec {
+	random := rand.New(rand.NewSource(param.RandomSeed))
+	return lubyTransform.NewLubyCodec(param.SourceBlocks, random, param.DegreeCDF)
 }
 
-func UploadToS3(ctx context.Context, bucket, key string, data []byte) error {
-	cfg, err := config.LoadDefaultConfig(ctx)
+func Decoder(Droplets []lubyTransform.LTBlock, param SetupParameters) ([]blockchainPkg.Block, error) {
+	decodedMessage, err := DecodeDroplets(Droplets, param)
 	if err != nil {
-		return fmt.Errorf("failed to load AWS configuration, %w", err)
+		return []blockchainPkg.Block{}, err
 	}
+	return DecodeMessage(decodedMessage, param)
+}
 
-	s3Client := s3.NewFromConfig(cfg)
-
-	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-		Body:   bytes.NewReader(data),
-	})
-
-	return err
+// DecodeDroplets recovers the compressed message from droplets.
+func DecodeDroplets(droplets []lubyTransform.LTBlock, param SetupParameters) ([]byte, error) {
+	decoder := NewCodec(param).NewDecoder(param.MessageSize)
+	if !decoder.AddBlocks(droplets) {
+		return nil, fmt.Errorf("%d droplets for %d source blocks: %w", len(droplets), param.SourceBlocks, ErrInsufficientDroplets)
+	}
+	decodedMessage := decoder.Decode()
+	if decodedMessage == nil {
+		return nil, fmt.Errorf("%d droplets for %d source blocks: %w", len(droplets), param.SourceBlocks, ErrInsufficientDroplets)
+	}
+	return decodedMessage, nil
 }
 
-func DownloadFromS3(ctx context.Context, bucket, key string) ([]byte, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
+// DecodeMessage turns a recovered, still compressed message back into the
+// blocks it carries.
+func DecodeMessage(message []byte, param SetupParameters) ([]blockchainPkg.Block, error) {
+	serializer, err := SerializerByName(param.Serialization)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS configuration, %w", err)
+		return []blockchainPkg.Block{}, err
 	}
-
-	s3Client := s3.NewFromConfig(cfg)
-
-	resp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+	compressor, err := CompressorByName(param.Compression)
 	if err != nil {
-		return nil, err
+		return []blockchainPkg.Block{}, err
 	}
-	defer resp.Body.Close()
-
-	data, err := io.ReadAll(resp.Body)
+	decodedMessage, err := compressor.Decompress(message)
 	if err != nil {
-		return nil, err
+		return []blockchainPkg.Block{}, fmt.Errorf("failed to decompress message: %v: %w", err, ErrCorruptMessage)
 	}
-
-	return data, nil
+	// Convert blockchain bytes to a Blocks object.
+	decodedBlocks, err := DecodeBlockMessage(decodedMessage, serializer)
+	if err != nil {
+		return []blockchainPkg.Block{}, fmt.Errorf("%v: %w", err, ErrCorruptMessage)
+	}
+	// size of decodedBlockchain
+	fmt.Println("Decoded blockchain: ", len(decodedBlocks))
+	return decodedBlocks, nil
 }
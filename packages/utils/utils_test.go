@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	blockchainPkg "github.com/xm0onh/thesis/packages/blockchain"
+)
+
+func TestLoadOrInitializeBlockchainExtendsStoredTip(t *testing.T) {
+	dir := t.TempDir()
+	build := func(n int) func() (*blockchainPkg.Blockchain, error) {
+		return func() (*blockchainPkg.Blockchain, error) { return InitializeBlockchain(n, 2) }
+	}
+	first, err := LoadOrInitializeBlockchain(dir, 2, build(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An independently built chain: different timestamps and hashes.
+	extended, err := LoadOrInitializeBlockchain(dir, 5, build(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(extended.Chain) != 5 {
+		t.Fatalf("extended chain has %d blocks, want 5", len(extended.Chain))
+	}
+	for i := range first.Chain {
+		if extended.Chain[i].Hash != first.Chain[i].Hash {
+			t.Fatalf("stored block %d was replaced", i)
+		}
+	}
+	if err := blockchainPkg.ValidateChain(extended, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := LoadOrInitializeBlockchain(dir, 5, build(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Chain[4].Hash != extended.Chain[4].Hash {
+		t.Fatal("reloading rebuilt the chain")
+	}
+}
+
+func TestInitializeBlockchainGivesBlocksTheirOwnTransactions(t *testing.T) {
+	bc, err := InitializeBlockchain(3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bc.Chain[0].MerkleRoot) == string(bc.Chain[1].MerkleRoot) {
+		t.Fatal("blocks 0 and 1 share their transactions")
+	}
+}
+
+func TestResolveRequestedBlocksByNumber(t *testing.T) {
+	bc, err := InitializeBlockchain(3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As imported: Ethereum block numbers instead of positions.
+	for i := range bc.Chain {
+		bc.Chain[i].Index = 19000000 + i
+	}
+	positions, err := ResolveRequestedBlocks(bc, RequestedBlocks{
+		BlockNumber: []int{19000002},
+		Ranges:      []BlockRange{{Start: 19000000, End: 19000002}},
+		BlockHashes: []string{bc.Chain[1].Hash},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{2, 0, 1, 1}; fmt.Sprint(positions) != fmt.Sprint(want) {
+		t.Fatalf("positions %v, want %v", positions, want)
+	}
+	if _, err := ResolveRequestedBlocks(bc, RequestedBlocks{BlockNumber: []int{0}}); !errors.Is(err, ErrBlockOutOfRange) {
+		t.Fatalf("position used as a block number: %v", err)
+	}
+}
+
+func TestSelectBlocks(t *testing.T) {
+	bc, err := InitializeBlockchain(4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selected, err := SelectBlocks(bc.Chain, RequestedBlocks{BlockNumber: []int{0}, BlockHashes: []string{bc.Chain[3].Hash}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 2 || selected[0].Hash != bc.Chain[0].Hash || selected[1].Hash != bc.Chain[3].Hash {
+		t.Fatalf("selected %+v", selected)
+	}
+	if _, err := SelectBlocks(bc.Chain, RequestedBlocks{BlockHashes: []string{"00"}}); !errors.Is(err, blockchainPkg.ErrBlockNotFound) {
+		t.Fatalf("unknown hash: %v", err)
+	}
+}
@@ -5,48 +5,90 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"os"
-	"strconv"
 	"time"
 
 	blockchainPkg "github.com/xm0onh/thesis/packages/blockchain"
+	kzgPkg "github.com/xm0onh/thesis/packages/kzg"
+	rs2d "github.com/xm0onh/thesis/packages/rs2d"
 	utils "github.com/xm0onh/thesis/packages/utils"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-	"github.com/aws/aws-sdk-go/aws"
 )
 
 var ddbTableName = os.Getenv("DDB_TABLE_NAME")
 var setupTableName = os.Getenv("SETUP_DB")
 var responderID = os.Getenv("RESPONDER_ID")
 var bucketName = os.Getenv("BLOCKCHAIN_S3_BUCKET")
+var dropletKey = os.Getenv("DROPLET_KEY")
+var localStoreDir = os.Getenv("LOCAL_STORE_DIR")
 
 func init() {
 	gob.Register(blockchainPkg.Transaction{})
 	gob.Register(blockchainPkg.Block{})
 }
 
-func Handler(ctx context.Context, snsEvent events.SNSEvent) error {
+// Responder encodes the message and writes the requested droplets to the
+// shared droplet store.
+type Responder struct {
+	Stores utils.Stores
+}
+
+func (h *Responder) Handler(ctx context.Context, snsEvent events.SNSEvent) error {
 	fmt.Println("I'm responder: ", responderID)
-	cfg, err := config.LoadDefaultConfig(ctx)
+	setupRecord, err := utils.LoadSetup(ctx, h.Stores.Setup, h.Stores.Blobs)
 	if err != nil {
-		return fmt.Errorf("failed to load AWS configuration, %w", err)
+		fmt.Printf("Failed to load setup: %v\n", err)
+		return err
 	}
-	param := utils.SetupParameters{}
-	param.DegreeCDF, param.SourceBlocks, param.EncodedBlockIDs, param.RandomSeed, param.NumberOfBlocks, _, param.MessageSize, err = utils.PullDataFromSetup(ctx, setupTableName)
+	param := setupRecord.Parameters()
+	key, err := utils.ParseDropletKey(dropletKey)
+	if err != nil {
+		return err
+	}
+	dropletCipher, err := utils.DropletCipherForSetup(param, key)
 	if err != nil {
-		fmt.Printf("Failed to pull data from setup: %v\n", err)
 		return err
 	}
 	startTime := time.Now()
-	param.Message, _ = utils.DownloadFromS3(ctx, bucketName, "blockchain_data")
+	param.Message, err = setupRecord.LoadMessage(ctx, h.Stores.Blobs, dropletCipher)
+	if err != nil {
+		return err
+	}
 	fmt.Println("Time to download blockchain data: ", time.Since(startTime))
 
-	ddbClient := dynamodb.NewFromConfig(cfg)
+	// Setups that commit to the droplets publish an opening proof per
+	// droplet, stored next to it. With a multi-opening key the responder
+	// instead proves its range itself with one proof, or one per maxRange
+	// droplets if the range is larger than the setup's responderRange.
+	maxRange := kzgPkg.MaxRangeForSetup(setupRecord.Commitment)
+	var proofs [][]byte
+	if maxRange == 0 {
+		proofs, err = setupRecord.LoadDropletProofs(ctx, h.Stores.Blobs)
+		if err != nil {
+			return err
+		}
+	}
+
+	if setupRecord.Matrix != nil {
+		return h.storeCells(ctx, snsEvent, setupRecord.Matrix, param.Message)
+	}
+
+	// The droplets and the prover depend only on the setup, so they are
+	// built once for all records of the event.
+	droplets := utils.GenerateDroplet(param)
+	fmt.Println("Generated droplets: ", len(droplets))
+	var prover *kzgPkg.DropletProver
+	if maxRange > 0 {
+		prover, err = kzgPkg.NewDropletProverForSetup(setupRecord.Commitment, param.EncodedBlockIDs, droplets)
+		if err != nil {
+			return err
+		}
+	}
+
 	for _, record := range snsEvent.Records {
 		var dropletReq utils.RequestedDroplets
 
@@ -56,32 +98,115 @@ func Handler(ctx context.Context, snsEvent events.SNSEvent) error {
 			fmt.Printf("Failed to unmarshal LTBlock data: %v\n", err)
 			continue
 		}
-		droplets := utils.GenerateDroplet(param)
-		fmt.Println("Generated droplets: ", len(droplets))
+		// Serve only the part of the range that names droplets of the run.
+		start, end := max(dropletReq.Start, 0), min(dropletReq.End, len(droplets))
+		if start >= end {
+			fmt.Printf("Skip the request because it names no droplets of %d: %v\n", len(droplets), dropletReq)
+			continue
+		}
 
 		// Uploading only the droplets within the range of start and end
-		for i := dropletReq.Start; i < dropletReq.End; i++ {
-			_, err = ddbClient.PutItem(ctx, &dynamodb.PutItemInput{
-				TableName: aws.String(ddbTableName),
-				Item: map[string]types.AttributeValue{
-					"ID":        &types.AttributeValueMemberS{Value: strconv.Itoa(i)},
-					"Data":      &types.AttributeValueMemberB{Value: droplets[i].Data},
-					"BlockCode": &types.AttributeValueMemberN{Value: strconv.FormatInt(droplets[i].BlockCode, 10)},
-				},
-				ConditionExpression: aws.String("attribute_not_exists(ID)"),
-			})
-
+		for i := start; i < end; i++ {
+			droplet := droplets[i]
+			var proof []byte
+			if proofs != nil {
+				proof = proofs[droplet.BlockCode]
+			}
+			if dropletCipher != nil {
+				droplet, err = dropletCipher.Seal(droplet)
+				if err != nil {
+					return err
+				}
+			}
+			err = h.Stores.Droplets.PutDroplet(ctx, i, utils.StoredDroplet{LTBlock: droplet, Proof: proof})
 			if err != nil {
 				fmt.Printf("Skip the droplet because it's already exists: %v\n", err)
 				continue
 			}
 		}
 
+		if prover == nil {
+			continue
+		}
+		if end-start > maxRange {
+			fmt.Printf("The range of %d droplets is larger than the %d the setup's key covers; proving it in parts\n", end-start, maxRange)
+		}
+		startTime := time.Now()
+		for rangeStart := start; rangeStart < end; rangeStart += maxRange {
+			rangeEnd := min(rangeStart+maxRange, end)
+			proof, err := prover.OpenRange(rangeStart, rangeEnd)
+			if err != nil {
+				return err
+			}
+			err = h.Stores.Droplets.PutRangeProof(ctx, utils.RangeProof{Start: rangeStart, End: rangeEnd, Proof: proof})
+			if err != nil {
+				fmt.Printf("Skip the range proof because it already exists: %v\n", err)
+				continue
+			}
+		}
+		fmt.Println("Time to prove the range: ", time.Since(startTime))
+
 	}
 
 	return nil
 }
 
+// storeCells serves a two-dimensional run: the requested range selects
+// rows of the extended matrix, and every cell of those rows is stored with
+// its opening against the row's commitment.
+func (h *Responder) storeCells(ctx context.Context, snsEvent events.SNSEvent, setup *utils.MatrixSetup, message []byte) error {
+	prover, err := rs2d.NewProverForSetup(setup, message)
+	if err != nil {
+		return err
+	}
+	rows := 2 * prover.Code().K
+	for _, record := range snsEvent.Records {
+		var cellReq utils.RequestedDroplets
+		if err := json.Unmarshal([]byte(record.SNS.Message), &cellReq); err != nil {
+			fmt.Printf("Failed to unmarshal cell request: %v\n", err)
+			continue
+		}
+		fmt.Println("Received request for rows: ", cellReq)
+		startTime := time.Now()
+		for r := max(cellReq.Start, 0); r < min(cellReq.End, rows); r++ {
+			cells, err := prover.Row(r)
+			if err != nil {
+				return err
+			}
+			for _, cell := range cells {
+				if err := h.Stores.Droplets.PutCell(ctx, cell); err != nil {
+					fmt.Printf("Skip the cell because it already exists: %v\n", err)
+				}
+			}
+		}
+		fmt.Println("Time to prove and store the rows: ", time.Since(startTime))
+	}
+	return nil
+}
+
 func main() {
-	lambda.Start(Handler)
+	stores, err := utils.OpenStores(context.Background(), utils.StoreConfig{
+		Dir:          localStoreDir,
+		Bucket:       bucketName,
+		SetupTable:   setupTableName,
+		DropletTable: ddbTableName,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	responder := &Responder{Stores: stores}
+	if localStoreDir != "" {
+		// Offline run: serve a single droplet request, {"start": ..., "end": ...},
+		// read from stdin.
+		request, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		event := events.SNSEvent{Records: []events.SNSEventRecord{{SNS: events.SNSEntity{Message: string(request)}}}}
+		if err := responder.Handler(context.Background(), event); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	lambda.Start(responder.Handler)
 }
@@ -0,0 +1,3 @@
+GOOS=linux GOARCH=amd64 go build -tags lambda.norpc -o bootstrap main.go
+
+zip sampler.zip bootstrap
\ No newline at end of file
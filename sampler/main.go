@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+
+	kzg "github.com/xm0onh/thesis/packages/kzg"
+	sampling "github.com/xm0onh/thesis/packages/sampling"
+	utils "github.com/xm0onh/thesis/packages/utils"
+)
+
+var setupTableName = os.Getenv("SETUP_DB")
+var tableName = os.Getenv("DDB_TABLE_NAME")
+var bucketName = os.Getenv("BLOCKCHAIN_S3_BUCKET")
+var dropletKey = os.Getenv("DROPLET_KEY")
+var localStoreDir = os.Getenv("LOCAL_STORE_DIR")
+var kzgCeremonyFile = os.Getenv("KZG_CEREMONY_FILE")
+var kzgSRSCache = os.Getenv("KZG_SRS_CACHE")
+var kzgInsecureSetup = os.Getenv("KZG_INSECURE_SETUP") != ""
+
+// SampleRequest asks for one round of sampling. Overhead defaults to
+// sampling.DefaultOverhead; Seed, if set, makes the choice of droplets
+// repeatable.
+type SampleRequest struct {
+	Samples  int     `json:"samples"`
+	Overhead float64 `json:"overhead,omitempty"`
+	Seed     int64   `json:"seed,omitempty"`
+}
+
+// Sampler is a light client: instead of downloading the droplet pool like
+// the decoder, it fetches a few random droplets with their proofs and
+// reports how confident it is that the message can be recovered. SRS
+// selects the trusted SRS that a published KZG commitment is checked
+// against.
+type Sampler struct {
+	Stores utils.Stores
+	SRS    kzg.SRSConfig
+}
+
+func (h *Sampler) Handler(ctx context.Context, request SampleRequest) (sampling.Report, error) {
+	setupRecord, err := utils.LoadSetup(ctx, h.Stores.Setup, h.Stores.Blobs)
+	if err != nil {
+		fmt.Printf("Failed to load setup: %v\n", err)
+		return sampling.Report{}, err
+	}
+	key, err := utils.ParseDropletKey(dropletKey)
+	if err != nil {
+		return sampling.Report{}, err
+	}
+	dropletCipher, err := utils.DropletCipherForSetup(setupRecord.Parameters(), key)
+	if err != nil {
+		return sampling.Report{}, err
+	}
+	sampler, err := sampling.NewSampler(h.SRS, h.Stores, setupRecord, dropletCipher)
+	if err != nil {
+		fmt.Printf("Cannot sample this setup: %v\n", err)
+		return sampling.Report{}, err
+	}
+
+	overhead := request.Overhead
+	if overhead == 0 {
+		overhead = sampling.DefaultOverhead
+	}
+	seed := request.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	startTime := time.Now()
+	report, err := sampler.Sample(ctx, request.Samples, overhead, rand.New(rand.NewSource(seed)))
+	if err != nil {
+		fmt.Printf("Failed to sample droplets: %v\n", err)
+		return sampling.Report{}, err
+	}
+	fmt.Println("Time to sample: ", time.Since(startTime))
+	fmt.Printf("Sampled %d of %d droplets: %d available, %d missing, %d invalid.\n", len(report.Samples), report.Total, report.Available, len(report.Missing), len(report.Invalid))
+	if len(report.Invalid) > 0 {
+		fmt.Println("Invalid droplets: ", report.Invalid)
+	}
+	fmt.Printf("Confidence that %d droplets are available: %.6f\n", report.Needed, report.Confidence)
+	return report, nil
+}
+
+func main() {
+	stores, err := utils.OpenStores(context.Background(), utils.StoreConfig{
+		Dir:          localStoreDir,
+		Bucket:       bucketName,
+		SetupTable:   setupTableName,
+		DropletTable: tableName,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	sampler := &Sampler{
+		Stores: stores,
+		SRS: kzg.SRSConfig{
+			Ceremony: kzgCeremonyFile,
+			Cache:    kzgSRSCache,
+			Insecure: kzgInsecureSetup,
+		},
+	}
+	if localStoreDir != "" {
+		// Offline run: one sample request, {"samples": ...}, from stdin.
+		var request SampleRequest
+		if err := json.NewDecoder(os.Stdin).Decode(&request); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := sampler.Handler(context.Background(), request); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	lambda.Start(sampler.Handler)
+}